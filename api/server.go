@@ -1,8 +1,8 @@
 package api
 
 import (
-	"encoding/gob"
 	"encoding/hex"
+	"fmt"
 	"github.com/Phanile/uretra_network/core"
 	"github.com/Phanile/uretra_network/types"
 	"github.com/go-kit/log"
@@ -15,18 +15,63 @@ type ServerConfig struct {
 	Logger     log.Logger
 }
 
+// TxRequest submits a transaction to the node. Err receives nil once the
+// transaction is pooled, or why it was rejected.
+type TxRequest struct {
+	Tx  *core.Transaction
+	Err chan error
+}
+
 type Server struct {
-	txChan chan *core.Transaction
+	txChan chan TxRequest
 	ServerConfig
 	bc *core.Blockchain
 }
 
+type PostTransactionResponse struct {
+	Hash  string `json:"hash"`
+	Error string `json:"error"`
+}
+
 type GetBalanceResponse struct {
 	Balance uint64 `json:"balance"`
 	Error   string `json:"error"`
 }
 
-func NewServer(config ServerConfig, bc *core.Blockchain, txChan chan *core.Transaction) *Server {
+type GetNonceResponse struct {
+	Nonce   uint64 `json:"nonce"`
+	ChainID uint32 `json:"chainId"`
+	Error   string `json:"error"`
+}
+
+type GetBlockResponse struct {
+	Block *core.Block `json:"block"`
+	Error string      `json:"error"`
+}
+
+type GetTransactionProofResponse struct {
+	Proof       *core.MerkleProof `json:"proof"`
+	BlockHash   string            `json:"blockHash"`
+	BlockHeight uint32            `json:"blockHeight"`
+	DataHash    string            `json:"dataHash"`
+	Error       string            `json:"error"`
+}
+
+type GetStateProofResponse struct {
+	Proof       *core.StateProof `json:"proof"`
+	BlockHeight uint32           `json:"blockHeight"`
+	StateRoot   string           `json:"stateRoot"`
+	Error       string           `json:"error"`
+}
+
+type GetTransactionResponse struct {
+	Transaction *core.Transaction `json:"transaction"`
+	BlockHeight uint32            `json:"blockHeight"`
+	Index       uint32            `json:"index"`
+	Error       string            `json:"error"`
+}
+
+func NewServer(config ServerConfig, bc *core.Blockchain, txChan chan TxRequest) *Server {
 	return &Server{
 		ServerConfig: config,
 		bc:           bc,
@@ -39,6 +84,12 @@ func (s *Server) Start() error {
 
 	e.POST("/tx", s.handlePostTransaction)
 	e.GET("/getBalance/:address", s.handleGetBalance)
+	e.GET("/getNonce/:address", s.handleGetNonce)
+	e.GET("/block/:hash", s.handleGetBlock)
+	e.GET("/tx/:hash", s.handleGetTransaction)
+	e.GET("/tx/:hash/proof", s.handleGetTransactionProof)
+	e.GET("/proof/account/:address", s.handleGetAccountProof)
+	e.GET("/proof/storage/:key", s.handleGetStorageProof)
 
 	return e.Start(s.ListenAddr)
 }
@@ -46,20 +97,27 @@ func (s *Server) Start() error {
 func (s *Server) handlePostTransaction(c echo.Context) error {
 	tx := &core.Transaction{}
 
-	err := gob.NewDecoder(c.Request().Body).Decode(tx)
+	err := tx.Decode(core.NewBinaryTxDecoder(c.Request().Body))
 
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, err)
 	}
 
-	s.txChan <- tx
+	req := TxRequest{Tx: tx, Err: make(chan error, 1)}
+	s.txChan <- req
+
+	resp := PostTransactionResponse{Hash: tx.Hash(core.TxHasher{}).String()}
 
-	return nil
+	if errTx := <-req.Err; errTx != nil {
+		resp.Error = errTx.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) handleGetBalance(c echo.Context) error {
-	hexAddr := c.Param("address")
-	addrBytes, err := hex.DecodeString(hexAddr)
+	addr, err := addressFromHex(c.Param("address"))
 
 	resp := GetBalanceResponse{}
 
@@ -68,7 +126,7 @@ func (s *Server) handleGetBalance(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, resp)
 	}
 
-	balance, errBalance := s.bc.GetAccounts().GetBalance(types.AddressFromBytes(addrBytes))
+	balance, errBalance := s.bc.GetBalance(addr)
 
 	if errBalance != nil {
 		resp.Error = errBalance.Error()
@@ -78,3 +136,152 @@ func (s *Server) handleGetBalance(c echo.Context) error {
 	resp.Balance = balance
 	return c.JSON(http.StatusOK, resp)
 }
+
+func (s *Server) handleGetNonce(c echo.Context) error {
+	addr, err := addressFromHex(c.Param("address"))
+
+	resp := GetNonceResponse{}
+
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	resp.Nonce = s.bc.GetNonce(addr)
+	resp.ChainID = s.bc.ChainID()
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleGetBlock(c echo.Context) error {
+	resp := GetBlockResponse{}
+	hash, err := hashFromHex(c.Param("hash"))
+
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	block, errBlock := s.bc.GetBlockByHash(hash)
+
+	if errBlock != nil {
+		resp.Error = errBlock.Error()
+		return c.JSON(http.StatusNotFound, resp)
+	}
+
+	resp.Block = block
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleGetTransaction(c echo.Context) error {
+	resp := GetTransactionResponse{}
+	hash, err := hashFromHex(c.Param("hash"))
+
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	tx, loc, errTx := s.bc.GetTransaction(hash)
+
+	if errTx != nil {
+		resp.Error = errTx.Error()
+		return c.JSON(http.StatusNotFound, resp)
+	}
+
+	resp.Transaction = tx
+	resp.BlockHeight = loc.Height
+	resp.Index = loc.Index
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleGetTransactionProof(c echo.Context) error {
+	resp := GetTransactionProofResponse{}
+	hash, err := hashFromHex(c.Param("hash"))
+
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	proof, header, errProof := s.bc.GetTransactionProof(hash)
+
+	if errProof != nil {
+		resp.Error = errProof.Error()
+		return c.JSON(http.StatusNotFound, resp)
+	}
+
+	resp.Proof = proof
+	resp.BlockHash = core.HeaderHasher{}.Hash(header).String()
+	resp.BlockHeight = header.Height
+	resp.DataHash = header.DataHash.String()
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleGetAccountProof(c echo.Context) error {
+	resp := GetStateProofResponse{}
+	addr, err := addressFromHex(c.Param("address"))
+
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	proof, header, errProof := s.bc.GetAccountProof(addr)
+
+	return s.stateProofResponse(c, resp, proof, header, errProof)
+}
+
+func (s *Server) handleGetStorageProof(c echo.Context) error {
+	resp := GetStateProofResponse{}
+	key, err := hex.DecodeString(c.Param("key"))
+
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusBadRequest, resp)
+	}
+
+	proof, header, errProof := s.bc.GetStorageProof(key)
+
+	return s.stateProofResponse(c, resp, proof, header, errProof)
+}
+
+func (s *Server) stateProofResponse(c echo.Context, resp GetStateProofResponse, proof *core.StateProof, header *core.Header, err error) error {
+	if err != nil {
+		resp.Error = err.Error()
+		return c.JSON(http.StatusInternalServerError, resp)
+	}
+
+	resp.Proof = proof
+	resp.BlockHeight = header.Height
+	resp.StateRoot = header.StateRoot.String()
+	return c.JSON(http.StatusOK, resp)
+}
+
+func hashFromHex(s string) (types.Hash, error) {
+	b, err := hex.DecodeString(s)
+
+	if err != nil {
+		return types.Hash{}, err
+	}
+
+	if len(b) != 32 {
+		return types.Hash{}, fmt.Errorf("hash length must be 32 bytes, got %d", len(b))
+	}
+
+	return types.HashFromBytes(b), nil
+}
+
+func addressFromHex(s string) (types.Address, error) {
+	b, err := hex.DecodeString(s)
+
+	if err != nil {
+		return types.Address{}, err
+	}
+
+	if len(b) != 20 {
+		return types.Address{}, fmt.Errorf("address length must be 20 bytes, got %d", len(b))
+	}
+
+	return types.AddressFromBytes(b), nil
+}
@@ -1,33 +1,57 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/Phanile/uretra_network/crypto"
 	"github.com/Phanile/uretra_network/types"
+	"math"
 	"sync"
 )
 
+const accountKeyPrefix = "account/"
+
 var (
 	AccountNotFoundError         = errors.New("account not found")
 	AccountNotEnoughBalanceError = errors.New("account not enough balance")
+	InvalidNonceError            = errors.New("invalid nonce")
 )
 
 type Accounts struct {
-	mu    sync.RWMutex
-	state map[types.Address]*Account
+	mu   sync.RWMutex
+	tree *StateTree
 }
 
 type Account struct {
 	Address types.Address
 	Balance uint64
+	Nonce   uint64
 }
 
 func NewAccounts() *Accounts {
+	return NewAccountsWithTree(NewStateTree())
+}
+
+func NewAccountsWithTree(tree *StateTree) *Accounts {
 	return &Accounts{
-		state: make(map[types.Address]*Account),
+		tree: tree,
 	}
 }
 
+// Bytes is the value committed to the state tree for the account.
+func (acc *Account) Bytes() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], acc.Balance)
+	binary.BigEndian.PutUint64(buf[8:16], acc.Nonce)
+
+	return buf
+}
+
+func AccountKey(addr types.Address) []byte {
+	return append([]byte(accountKeyPrefix), addr[:]...)
+}
+
 func (a *Accounts) NewAccount(addr types.Address) *Account {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -36,7 +60,7 @@ func (a *Accounts) NewAccount(addr types.Address) *Account {
 		Address: addr,
 	}
 
-	a.state[addr] = acc
+	a.putAccount(acc)
 
 	return acc
 }
@@ -49,13 +73,17 @@ func (a *Accounts) GetAccount(addr types.Address) (*Account, error) {
 }
 
 func (a *Accounts) getNoLockAccount(addr types.Address) (*Account, error) {
-	acc, ok := a.state[addr]
+	data, ok := a.tree.Get(AccountKey(addr))
 
 	if !ok {
 		return nil, AccountNotFoundError
 	}
 
-	return acc, nil
+	return &Account{
+		Address: addr,
+		Balance: binary.BigEndian.Uint64(data[0:8]),
+		Nonce:   binary.BigEndian.Uint64(data[8:16]),
+	}, nil
 }
 
 func (a *Accounts) GetBalance(addr types.Address) (uint64, error) {
@@ -68,13 +96,44 @@ func (a *Accounts) GetBalance(addr types.Address) (uint64, error) {
 	return acc.Balance, nil
 }
 
-func (a *Accounts) Transfer(from, to types.Address, value uint64) error {
+// GetNonce returns the nonce the next transaction of addr must carry.
+func (a *Accounts) GetNonce(addr types.Address) uint64 {
+	acc, err := a.GetAccount(addr)
+
+	if err != nil {
+		return 0
+	}
+
+	return acc.Nonce
+}
+
+// UseNonce checks that nonce is the next one of addr and consumes it.
+func (a *Accounts) UseNonce(addr types.Address, nonce uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc := a.getOrCreateNoLockAccount(addr)
+
+	if acc.Nonce != nonce {
+		return fmt.Errorf("%w: expected %d, got %d", InvalidNonceError, acc.Nonce, nonce)
+	}
+
+	acc.Nonce++
+	a.putAccount(acc)
+
+	return nil
+}
+
+// Transfer moves value from one account to another and burns fee from the
+// sender; the caller is responsible for crediting the fee to the validator.
+func (a *Accounts) Transfer(from, to types.Address, value, fee uint64) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	if from == crypto.ZeroPublicKey().Address() && to == crypto.ZeroPublicKey().Address() {
 		fromAcc, _ := a.getNoLockAccount(from)
 		fromAcc.Balance += value
+		a.putAccount(fromAcc)
 
 		return nil
 	}
@@ -85,29 +144,50 @@ func (a *Accounts) Transfer(from, to types.Address, value uint64) error {
 		return errGetAcc
 	}
 
-	if fromAcc.Balance < value {
+	if value > math.MaxUint64-fee || fromAcc.Balance < value+fee {
 		return AccountNotEnoughBalanceError
 	}
 
-	fromAcc.Balance -= value
-
-	if a.state[to] == nil {
-		a.state[to] = &Account{
-			Address: to,
-		}
-	}
+	fromAcc.Balance -= value + fee
+	a.putAccount(fromAcc)
 
-	a.state[to].Balance += value
+	toAcc := a.getOrCreateNoLockAccount(to)
+	toAcc.Balance += value
+	a.putAccount(toAcc)
 
 	return nil
 }
 
 func (a *Accounts) AddBalance(to types.Address, value uint64) error {
-	if a.state[to] == nil {
-		a.state[to] = a.NewAccount(to)
-	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	a.state[to].Balance += value
+	acc := a.getOrCreateNoLockAccount(to)
+	acc.Balance += value
+	a.putAccount(acc)
 
 	return nil
 }
+
+func (a *Accounts) Prove(addr types.Address) *StateProof {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.tree.Prove(AccountKey(addr))
+}
+
+func (a *Accounts) getOrCreateNoLockAccount(addr types.Address) *Account {
+	acc, err := a.getNoLockAccount(addr)
+
+	if err != nil {
+		return &Account{
+			Address: addr,
+		}
+	}
+
+	return acc
+}
+
+func (a *Accounts) putAccount(acc *Account) {
+	a.tree.Put(AccountKey(acc.Address), acc.Bytes())
+}
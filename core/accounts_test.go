@@ -22,7 +22,7 @@ func TestAccounts_Transfer(t *testing.T) {
 	a := NewAccounts()
 
 	assert.Nil(t, a.AddBalance(pkAlice.PublicKey().Address(), uint64(1000)))
-	assert.Nil(t, a.Transfer(pkAlice.PublicKey().Address(), pkBob.PublicKey().Address(), uint64(700)))
+	assert.Nil(t, a.Transfer(pkAlice.PublicKey().Address(), pkBob.PublicKey().Address(), uint64(700), 0))
 
 	balanceAlice, okAlice := a.GetBalance(pkAlice.PublicKey().Address())
 	balanceBob, okBob := a.GetBalance(pkBob.PublicKey().Address())
@@ -32,3 +32,19 @@ func TestAccounts_Transfer(t *testing.T) {
 	assert.Equal(t, balanceAlice, uint64(300))
 	assert.Equal(t, balanceBob, uint64(700))
 }
+
+func TestAccounts_TransferFee(t *testing.T) {
+	alice := crypto.GeneratePrivateKey().PublicKey().Address()
+	bob := crypto.GeneratePrivateKey().PublicKey().Address()
+	a := NewAccounts()
+
+	assert.Nil(t, a.AddBalance(alice, uint64(1000)))
+	assert.ErrorIs(t, a.Transfer(alice, bob, uint64(990), uint64(20)), AccountNotEnoughBalanceError)
+	assert.Nil(t, a.Transfer(alice, bob, uint64(700), uint64(20)))
+
+	balanceAlice, _ := a.GetBalance(alice)
+	balanceBob, _ := a.GetBalance(bob)
+
+	assert.Equal(t, uint64(280), balanceAlice)
+	assert.Equal(t, uint64(700), balanceBob)
+}
@@ -3,16 +3,27 @@ package core
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
 	"github.com/Phanile/uretra_network/crypto"
 	"github.com/Phanile/uretra_network/types"
 	"time"
 )
 
+const (
+	HeaderVersionLegacy uint32 = 1
+	// HeaderVersionMerkle headers carry a Merkle root of transaction hashes
+	// in DataHash instead of a hash over the encoded transactions.
+	HeaderVersionMerkle uint32 = 2
+	// HeaderVersionStateRoot headers additionally commit to the state tree
+	// root after executing the block.
+	HeaderVersionStateRoot uint32 = 3
+	CurrentHeaderVersion          = HeaderVersionStateRoot
+)
+
 type Header struct {
 	Version       uint32
 	PrevBlockHash types.Hash
 	DataHash      types.Hash
+	StateRoot     types.Hash
 	Timestamp     int64
 	Height        uint32
 }
@@ -25,16 +36,32 @@ type Block struct {
 	hash         types.Hash
 }
 
-func (h *Header) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(h)
+// SignedHeader is a block without its transactions. It is enough to verify a
+// header chain before the block bodies are downloaded.
+type SignedHeader struct {
+	Header    *Header
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
 
-	if err != nil {
-		panic(err)
-	}
+func (sh *SignedHeader) Verify() bool {
+	return sh.Signature != nil && sh.Signature.VerifySignature(&sh.Validator, sh.Header.Bytes())
+}
+
+func (sh *SignedHeader) Decode(decoder Decoder[*SignedHeader]) error {
+	return decoder.Decode(sh)
+}
+
+func (sh *SignedHeader) Encode(encoder Encoder[*SignedHeader]) error {
+	return encoder.Encode(sh)
+}
+
+// Bytes is the canonical encoding of the header, which is hashed and signed.
+func (h *Header) Bytes() []byte {
+	cw := &codecWriter{}
+	cw.header(h)
 
-	return buf.Bytes()
+	return cw.buf.Bytes()
 }
 
 func NewBlock(h *Header, tr []*Transaction) *Block {
@@ -45,14 +72,14 @@ func NewBlock(h *Header, tr []*Transaction) *Block {
 }
 
 func NewBlockFromPrevHeader(prevHeader *Header, tx []*Transaction) (*Block, error) {
-	dataHash, err := CalculateDataHash(tx)
+	dataHash, err := CalculateDataHash(CurrentHeaderVersion, tx)
 
 	if err != nil {
 		return nil, err
 	}
 
 	header := &Header{
-		Version:       1,
+		Version:       CurrentHeaderVersion,
 		DataHash:      dataHash,
 		PrevBlockHash: HeaderHasher{}.Hash(prevHeader),
 		Timestamp:     time.Now().UnixNano(),
@@ -62,6 +89,26 @@ func NewBlockFromPrevHeader(prevHeader *Header, tx []*Transaction) (*Block, erro
 	return NewBlock(header, tx), nil
 }
 
+// Size is the total size of the block's transactions, which MaxBlockSize
+// bounds.
+func (b *Block) Size() int {
+	size := 0
+
+	for _, tx := range b.Transactions {
+		size += tx.Size()
+	}
+
+	return size
+}
+
+func (b *Block) SignedHeader() *SignedHeader {
+	return &SignedHeader{
+		Header:    b.Header,
+		Validator: b.Validator,
+		Signature: b.Signature,
+	}
+}
+
 func (b *Block) AddTransaction(tr *Transaction) {
 	b.Transactions = append(b.Transactions, tr)
 }
@@ -90,9 +137,9 @@ func (b *Block) Verify() bool {
 		}
 	}
 
-	dataHash, _ := b.CalculateDataHash(b.Transactions)
+	dataHash, err := b.CalculateDataHash(b.Transactions)
 
-	if dataHash != b.Header.DataHash {
+	if err != nil || dataHash != b.Header.DataHash {
 		return false
 	}
 
@@ -116,20 +163,27 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 }
 
 func (b *Block) CalculateDataHash(tx []*Transaction) (types.Hash, error) {
-	buf := &bytes.Buffer{}
+	return CalculateDataHash(b.Header.Version, tx)
+}
 
-	for _, tr := range tx {
-		err := tr.Encode(NewGobTxEncoder(buf))
+func CalculateDataHash(version uint32, tx []*Transaction) (types.Hash, error) {
+	switch {
+	case version == HeaderVersionLegacy:
+		return calculateLegacyDataHash(tx)
+	case version >= HeaderVersionMerkle && version <= CurrentHeaderVersion:
+		hashes := make([]types.Hash, len(tx))
 
-		if err != nil {
-			return types.Hash{}, err
+		for i, tr := range tx {
+			hashes[i] = TxHasher{}.Hash(tr)
 		}
-	}
 
-	return sha256.Sum256(buf.Bytes()), nil
+		return MerkleRoot(hashes), nil
+	default:
+		return types.Hash{}, UnsupportedHeaderVersionError
+	}
 }
 
-func CalculateDataHash(tx []*Transaction) (types.Hash, error) {
+func calculateLegacyDataHash(tx []*Transaction) (types.Hash, error) {
 	buf := &bytes.Buffer{}
 
 	for _, tr := range tx {
@@ -142,3 +196,11 @@ func CalculateDataHash(tx []*Transaction) (types.Hash, error) {
 
 	return sha256.Sum256(buf.Bytes()), nil
 }
+
+func (b *Block) MerkleProof(txHash types.Hash) (*MerkleProof, error) {
+	if b.Header.Version < HeaderVersionMerkle {
+		return nil, UnsupportedHeaderVersionError
+	}
+
+	return BuildMerkleProof(txHashes(b), txHash)
+}
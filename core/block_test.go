@@ -49,7 +49,7 @@ func randomBlockWithSignature(t *testing.T, height uint32, prevBlockHash types.H
 	privateKey := crypto.GeneratePrivateKey()
 
 	h := &Header{
-		Version:       1,
+		Version:       HeaderVersionMerkle,
 		PrevBlockHash: prevBlockHash,
 		Timestamp:     time.Now().UnixNano(),
 		Height:        height,
@@ -59,12 +59,12 @@ func randomBlockWithSignature(t *testing.T, height uint32, prevBlockHash types.H
 
 	b := NewBlock(h, []*Transaction{tr1})
 	b.Validator = privateKey.PublicKey()
-	assert.Nil(t, b.Sign(privateKey))
 
 	dataHash, err := b.CalculateDataHash(b.Transactions)
 	assert.Nil(t, err)
 
 	b.Header.DataHash = dataHash
+	assert.Nil(t, b.Sign(privateKey))
 	b.Hash(&HeaderHasher{})
 
 	return b
@@ -2,6 +2,7 @@ package core
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/Phanile/uretra_network/crypto"
 	"github.com/Phanile/uretra_network/types"
@@ -9,26 +10,178 @@ import (
 	"sync"
 )
 
+const (
+	DefaultChainID       uint32 = 1
+	defaultMaxReorgDepth        = 100
+	blockReduction              = 210000
+	initialBlockReward          = 500
+	// MaxBlockSize bounds the total size of the transactions in a block.
+	MaxBlockSize = 1 << 20
+)
+
+var (
+	BlockKnownError         = errors.New("block already known")
+	ParentUnknownError      = errors.New("parent block unknown")
+	InvalidBlockError       = errors.New("invalid block")
+	ForkTooDeepError        = errors.New("fork is deeper than max reorg depth")
+	StateRootMismatchError  = errors.New("state root mismatch")
+	WrongChainIDError       = errors.New("transaction signed for another chain")
+	CoinbaseNotAllowedError = errors.New("coinbase transaction outside genesis block")
+	BlockTooLargeError      = errors.New("block too large")
+)
+
+type BlockchainOptions struct {
+	Logger        log.Logger
+	ChainID       uint32
+	Store         Storage
+	ForkChoice    ForkChoiceRule
+	MaxReorgDepth uint32
+	// OnReorg receives transactions of disconnected blocks that are not part
+	// of the new canonical branch. It is called with the chain lock held.
+	OnReorg func(orphaned []*Transaction)
+}
+
 type Blockchain struct {
 	logger        log.Logger
+	chainID       uint32
 	Store         Storage
 	lock          sync.RWMutex
-	headers       []*Header
+	headers       []*SignedHeader
 	validator     Validator
+	stateTree     *StateTree
 	state         *State
 	accountsState *Accounts
+	journal       *journal
+	forkChoice    ForkChoiceRule
+	maxReorgDepth uint32
+	onReorg       func(orphaned []*Transaction)
+	nodes         map[types.Hash]*blockNode
+	sideNodes     map[types.Hash]*blockNode
+}
+
+// blockNode is an entry of the block tree. Bodies of canonical blocks live in
+// the Store, side-chain bodies are kept in memory until pruned.
+type blockNode struct {
+	hash      types.Hash
+	parent    *blockNode
+	height    uint32
+	version   uint32
+	weight    uint64
+	canonical bool
+	block     *Block
+	undo      []func()
 }
 
 func NewBlockchain(l log.Logger, genesis *Block) *Blockchain {
+	bc, err := NewBlockchainWithOptions(BlockchainOptions{Logger: l}, genesis)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return bc
+}
+
+func NewBlockchainWithOptions(opts BlockchainOptions, genesis *Block) (*Blockchain, error) {
+	if opts.Store == nil {
+		opts.Store = NewMemoryStorage()
+	}
+
+	if opts.ForkChoice == nil {
+		opts.ForkChoice = LongestChainRule{}
+	}
+
+	if opts.MaxReorgDepth == 0 {
+		opts.MaxReorgDepth = defaultMaxReorgDepth
+	}
+
+	if opts.ChainID == 0 {
+		opts.ChainID = DefaultChainID
+	}
+
 	bc := &Blockchain{
-		logger:  l,
-		headers: []*Header{},
-		state:   NewState(),
+		logger:        opts.Logger,
+		chainID:       opts.ChainID,
+		Store:         opts.Store,
+		headers:       []*SignedHeader{},
+		forkChoice:    opts.ForkChoice,
+		maxReorgDepth: opts.MaxReorgDepth,
+		onReorg:       opts.OnReorg,
+		nodes:         make(map[types.Hash]*blockNode),
+		sideNodes:     make(map[types.Hash]*blockNode),
 	}
 
-	bc.Store = NewMemoryStorage(bc)
 	bc.validator = NewBlockValidator(bc)
-	bc.accountsState = NewAccounts()
+	bc.initState()
+
+	height, err := bc.Store.Height()
+
+	if errors.Is(err, EmptyStorageError) {
+		if errGenesis := bc.addBlockWithoutValidation(genesis); errGenesis != nil {
+			return nil, errGenesis
+		}
+
+		return bc, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if errLoad := bc.loadFromStore(height); errLoad != nil {
+		return nil, errLoad
+	}
+
+	return bc, nil
+}
+
+// loadFromStore rebuilds headers, accounts and state by re-executing every
+// stored block up to the persisted tip.
+func (bc *Blockchain) loadFromStore(height uint32) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	var parent *blockNode
+
+	for h := uint32(0); h <= height; h++ {
+		b, err := bc.Store.Get(h)
+
+		if err != nil {
+			return err
+		}
+
+		node := bc.newNode(b, parent)
+
+		if errExec := bc.executeBlock(node); errExec != nil {
+			return errExec
+		}
+
+		if _, errIndex := bc.Store.GetHeightByHash(node.hash); errIndex != nil {
+			if errReindex := bc.indexBlock(b); errReindex != nil {
+				return errReindex
+			}
+		}
+
+		node.canonical = true
+		node.block = nil
+		bc.nodes[node.hash] = node
+		bc.headers = append(bc.headers, b.SignedHeader())
+		bc.prune()
+
+		parent = node
+	}
+
+	_ = bc.logger.Log("msg", "blockchain loaded from storage", "height", height)
+
+	return nil
+}
+
+// initState sets up the state every chain starts from, before genesis.
+func (bc *Blockchain) initState() {
+	bc.stateTree = NewStateTree()
+	bc.journal = newJournal()
+	bc.state = NewStateWithTree(bc.stateTree)
+	bc.accountsState = NewAccountsWithTree(bc.stateTree)
 
 	bc.accountsState.NewAccount(crypto.ZeroPublicKey().Address()) //coinbase account
 
@@ -38,38 +191,304 @@ func NewBlockchain(l log.Logger, genesis *Block) *Blockchain {
 	_ = bc.accountsState.AddBalance(types.AddressFromBytes(addrBytes), 1000000)
 	// TEST
 
-	err := bc.addBlockWithoutValidation(genesis)
+	bc.stateTree.journal = bc.journal
+}
+
+// NewGenesisBlock builds the unsigned genesis block holding txs, committing
+// to the state they leave the initial state in.
+func NewGenesisBlock(txs []*Transaction) (*Block, error) {
+	bc := &Blockchain{logger: log.NewNopLogger()}
+	bc.initState()
+
+	for _, tx := range txs {
+		if err := bc.handleTransaction(tx, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	dataHash, err := CalculateDataHash(CurrentHeaderVersion, txs)
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return bc
+	h := &Header{
+		Version:   CurrentHeaderVersion,
+		DataHash:  dataHash,
+		StateRoot: bc.stateTree.Root(),
+	}
+
+	return NewBlock(h, txs), nil
+}
+
+func (bc *Blockchain) AddBlock(b *Block) error {
+	if err := bc.validator.ValidateBlock(b); err != nil {
+		return err
+	}
+
+	return bc.addBlockWithoutValidation(b)
 }
 
-func (bc *Blockchain) AddBlock(b *Block) bool {
-	if bc.validator.ValidateBlock(b) {
+func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	hash := HeaderHasher{}.Hash(b.Header)
 
-		err := bc.addBlockWithoutValidation(b)
+	if _, ok := bc.nodes[hash]; ok {
+		return BlockKnownError
+	}
+
+	if len(bc.headers) == 0 {
+		return bc.connectBlock(bc.newNode(b, nil))
+	}
+
+	parent, ok := bc.nodes[b.Header.PrevBlockHash]
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ParentUnknownError, b.Header.PrevBlockHash)
+	}
+
+	tip := bc.tip()
+
+	if parent.height+bc.maxReorgDepth < tip.height {
+		return ForkTooDeepError
+	}
+
+	node := bc.newNode(b, parent)
+
+	if parent == tip {
+		return bc.connectBlock(node)
+	}
+
+	bc.nodes[node.hash] = node
+	bc.sideNodes[node.hash] = node
+
+	if node.weight <= tip.weight {
+		_ = bc.logger.Log("msg", "new side chain block", "hash", node.hash, "height", node.height)
+		return nil
+	}
+
+	return bc.reorganize(node)
+}
+
+func (bc *Blockchain) newNode(b *Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		hash:    HeaderHasher{}.Hash(b.Header),
+		parent:  parent,
+		height:  b.Header.Height,
+		version: b.Header.Version,
+		weight:  bc.forkChoice.BlockWeight(b),
+		block:   b,
+	}
+
+	if parent != nil {
+		node.weight += parent.weight
+	}
+
+	return node
+}
+
+func (bc *Blockchain) tip() *blockNode {
+	return bc.nodes[HeaderHasher{}.Hash(bc.headers[len(bc.headers)-1].Header)]
+}
+
+// connectBlock executes the block on top of the current tip and makes it the
+// new canonical tip.
+func (bc *Blockchain) connectBlock(node *blockNode) error {
+	b := node.block
+
+	if err := bc.executeBlock(node); err != nil {
+		return fmt.Errorf("%w: %w", InvalidBlockError, err)
+	}
+
+	if err := bc.Store.Put(b); err != nil {
+		revertEntries(node.undo)
+		node.undo = nil
+		return err
+	}
+
+	if err := bc.indexBlock(b); err != nil {
+		revertEntries(node.undo)
+		node.undo = nil
+		return err
+	}
+
+	node.canonical = true
+	node.block = nil
+	bc.nodes[node.hash] = node
+	delete(bc.sideNodes, node.hash)
+	bc.headers = append(bc.headers, b.SignedHeader())
+	bc.prune()
+
+	_ = bc.logger.Log("msg", "new block", "hash", node.hash, "height", node.height, "txs", len(b.Transactions))
+
+	return nil
+}
+
+// disconnectBlock rolls the current tip back to its parent and turns it into
+// a side-chain block.
+func (bc *Blockchain) disconnectBlock(node *blockNode) (*Block, error) {
+	b, err := bc.Store.Get(node.height)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if errUnindex := bc.Store.UnindexBlock(node.hash, txHashes(b)); errUnindex != nil {
+		return nil, errUnindex
+	}
+
+	revertEntries(node.undo)
+
+	node.undo = nil
+	node.canonical = false
+	node.block = b
+	bc.sideNodes[node.hash] = node
+	bc.headers = bc.headers[:node.height]
+
+	return b, nil
+}
+
+func (bc *Blockchain) reorganize(newTip *blockNode) error {
+	var branch []*blockNode
+
+	ancestor := newTip
+
+	for !ancestor.canonical {
+		branch = append([]*blockNode{ancestor}, branch...)
+		ancestor = ancestor.parent
+	}
+
+	if bc.tip().height-ancestor.height > bc.maxReorgDepth {
+		bc.dropSubtree(branch[0])
+		return ForkTooDeepError
+	}
+
+	connected := make([]*Block, len(branch))
+
+	for i, n := range branch {
+		connected[i] = n.block
+	}
+
+	var disconnected []*blockNode
+	var orphaned []*Block
+
+	for n := bc.tip(); n != ancestor; n = n.parent {
+		b, err := bc.disconnectBlock(n)
 
 		if err != nil {
-			return false
+			return err
 		}
 
-		return true
+		disconnected = append(disconnected, n)
+		orphaned = append(orphaned, b)
 	}
 
-	return false
+	for i, n := range branch {
+		if err := bc.connectBlock(n); err != nil {
+			bc.dropSubtree(n)
+
+			for j := i - 1; j >= 0; j-- {
+				if _, errDisconnect := bc.disconnectBlock(branch[j]); errDisconnect != nil {
+					return errDisconnect
+				}
+			}
+
+			for j := len(disconnected) - 1; j >= 0; j-- {
+				if errRestore := bc.connectBlock(disconnected[j]); errRestore != nil {
+					return errRestore
+				}
+			}
+
+			if errTruncate := bc.Store.Truncate(bc.height()); errTruncate != nil {
+				return errTruncate
+			}
+
+			return err
+		}
+	}
+
+	if err := bc.Store.Truncate(newTip.height); err != nil {
+		return err
+	}
+
+	_ = bc.logger.Log("msg", "chain reorganized", "ancestor", ancestor.height, "disconnected", len(disconnected), "connected", len(branch), "tip", newTip.hash)
+
+	if bc.onReorg != nil {
+		bc.onReorg(orphanedTransactions(orphaned, connected))
+	}
+
+	return nil
 }
 
-func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
+// dropSubtree forgets an invalid side-chain block and every block built on it.
+func (bc *Blockchain) dropSubtree(root *blockNode) {
+	delete(bc.nodes, root.hash)
+	delete(bc.sideNodes, root.hash)
+
+	for hash, n := range bc.sideNodes {
+		for p := n.parent; p != nil; p = p.parent {
+			if p == root {
+				delete(bc.nodes, hash)
+				delete(bc.sideNodes, hash)
+				break
+			}
+		}
+	}
+}
+
+// prune drops undo data and side chains that fell behind the max reorg depth.
+func (bc *Blockchain) prune() {
+	height := uint32(len(bc.headers) - 1)
+
+	if height < bc.maxReorgDepth {
+		return
+	}
 
-	validTxs := make([]*Transaction, 0, len(b.Transactions))
+	limit := height - bc.maxReorgDepth
+
+	if old, ok := bc.nodes[HeaderHasher{}.Hash(bc.headers[limit].Header)]; ok {
+		old.undo = nil
+	}
+
+	for hash, n := range bc.sideNodes {
+		if n.height <= limit {
+			delete(bc.nodes, hash)
+			delete(bc.sideNodes, hash)
+		}
+	}
+}
+
+func orphanedTransactions(disconnected []*Block, connected []*Block) []*Transaction {
+	included := make(map[types.Hash]bool)
+
+	for _, b := range connected {
+		for _, tx := range b.Transactions {
+			included[TxHasher{}.Hash(tx)] = true
+		}
+	}
+
+	var orphaned []*Transaction
+
+	for _, b := range disconnected {
+		for _, tx := range b.Transactions {
+			if tx.IsCoinbase() || included[TxHasher{}.Hash(tx)] {
+				continue
+			}
+
+			orphaned = append(orphaned, tx)
+		}
+	}
+
+	return orphaned
+}
+
+func (bc *Blockchain) executeBlock(node *blockNode) error {
+	b := node.block
 
 	for i := 0; i < len(b.Transactions); i++ {
-		err := bc.handleTransaction(b.Transactions[i])
+		err := bc.handleTransaction(b.Transactions[i], b.Header.Height)
 
 		if err != nil {
 			_ = bc.logger.Log(
@@ -77,29 +496,129 @@ func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
 				"hash", TxHasher{}.Hash(b.Transactions[i]),
 				"error", err,
 			)
+
+			bc.journal.revertTo(0)
+
+			return err
+		}
+	}
+
+	if err := bc.rewardValidator(b); err != nil {
+		bc.journal.revertTo(0)
+		return err
+	}
+
+	if b.Header.Version >= HeaderVersionStateRoot {
+		if root := bc.stateTree.Root(); root != b.Header.StateRoot {
+			bc.journal.revertTo(0)
+			return fmt.Errorf("%w: expected %s, got %s", StateRootMismatchError, b.Header.StateRoot, root)
+		}
+	}
+
+	node.undo = bc.journal.commit()
+
+	return nil
+}
+
+func (bc *Blockchain) rewardValidator(b *Block) error {
+	if b.Header.Height == 0 {
+		return nil
+	}
+
+	return bc.accountsState.AddBalance(b.Validator.Address(), BlockReward(b.Header.Height)+BlockFees(b))
+}
+
+// BlockFees sums the fees paid by the transactions of b.
+func BlockFees(b *Block) uint64 {
+	var fees uint64
+
+	for _, tx := range b.Transactions {
+		fees += tx.Fee
+	}
+
+	return fees
+}
+
+// NewBlockTemplate builds an unsigned block on top of the current tip from
+// the transactions that execute successfully in order and fit in MaxBlockSize,
+// with the state root the chain will reach after applying it. Callers pass
+// transactions in priority order. The state is left untouched.
+func (bc *Blockchain) NewBlockTemplate(txs []*Transaction, validator crypto.PublicKey) (*Block, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	defer bc.journal.revertTo(0)
+
+	included := make([]*Transaction, 0, len(txs))
+	height := bc.height() + 1
+	size := 0
+
+	for _, tx := range txs {
+		if size+tx.Size() > MaxBlockSize {
+			continue
+		}
+
+		snapshot := bc.journal.snapshot()
+
+		if err := bc.handleTransaction(tx, height); err != nil {
+			bc.journal.revertTo(snapshot)
 			continue
 		}
 
-		validTxs = append(validTxs, b.Transactions[i])
+		included = append(included, tx)
+		size += tx.Size()
+	}
+
+	b, err := NewBlockFromPrevHeader(bc.headers[len(bc.headers)-1].Header, included)
+
+	if err != nil {
+		return nil, err
 	}
 
-	b.Transactions = validTxs
-	hash, hashErr := CalculateDataHash(b.Transactions)
+	b.Validator = validator
 
-	if hashErr != nil {
-		return hashErr
+	if errReward := bc.rewardValidator(b); errReward != nil {
+		return nil, errReward
 	}
 
-	b.Header.DataHash = hash
+	b.Header.StateRoot = bc.stateTree.Root()
 
-	bc.headers = append(bc.headers, b.Header)
+	return b, nil
+}
+
+func (bc *Blockchain) indexBlock(b *Block) error {
+	return bc.Store.IndexBlock(HeaderHasher{}.Hash(b.Header), b.Header.Height, txHashes(b))
+}
 
-	_ = bc.logger.Log("msg", "new block", "hash", b.Hash(HeaderHasher{}), "height", b.Header.Height, "txs", len(b.Transactions))
+func txHashes(b *Block) []types.Hash {
+	hashes := make([]types.Hash, len(b.Transactions))
+
+	for i, tx := range b.Transactions {
+		hashes[i] = TxHasher{}.Hash(tx)
+	}
 
-	return bc.Store.Put(b)
+	return hashes
 }
 
-func (bc *Blockchain) handleTransaction(t *Transaction) error {
+// handleTransaction applies t as part of the block at height. Outside the
+// trusted genesis block a transaction must be signed for this chain and carry
+// the sender's next nonce, which it consumes, so it cannot be replayed here or
+// on another network.
+func (bc *Blockchain) handleTransaction(t *Transaction, height uint32) error {
+	if height > 0 {
+		if t.IsCoinbase() {
+			return CoinbaseNotAllowedError
+		}
+
+		if t.ChainID != bc.chainID {
+			return fmt.Errorf("%w: expected %d, got %d", WrongChainIDError, bc.chainID, t.ChainID)
+		}
+
+		if err := bc.accountsState.UseNonce(t.From.Address(), t.Nonce); err != nil {
+			return err
+		}
+	}
+
 	if len(t.Data) > 0 {
 		vm := NewVM(t.Data, bc.state)
 		err := vm.Run()
@@ -108,11 +627,11 @@ func (bc *Blockchain) handleTransaction(t *Transaction) error {
 			return err
 		}
 
-		fmt.Printf("state: %+v\n", bc.state)
+		_ = bc.logger.Log("msg", "contract executed", "hash", TxHasher{}.Hash(t))
 	}
 
-	if t.Value > 0 {
-		err := bc.accountsState.Transfer(t.From.Address(), t.To, t.Value)
+	if t.Value > 0 || t.Fee > 0 {
+		err := bc.accountsState.Transfer(t.From.Address(), t.To, t.Value, t.Fee)
 
 		if err != nil {
 			return err
@@ -122,28 +641,175 @@ func (bc *Blockchain) handleTransaction(t *Transaction) error {
 	return nil
 }
 
+func BlockReward(height uint32) uint64 {
+	halving := height / blockReduction
+
+	if halving >= 64 {
+		return 0
+	}
+
+	return uint64(initialBlockReward >> halving)
+}
+
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
-	return height <= bc.Height()
+	return height <= bc.height()
+}
+
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, ok := bc.nodes[hash]
+
+	return ok
+}
+
+func (bc *Blockchain) getNode(hash types.Hash) (*blockNode, bool) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	node, ok := bc.nodes[hash]
+
+	return node, ok
 }
 
 func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
-	if height > bc.Height() {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if height > bc.height() {
 		return nil, fmt.Errorf("trying get too high header (%d)", height)
 	}
 
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
+	return bc.headers[height].Header, nil
+}
+
+// GetSignedHeader returns the canonical header at height with the signature
+// of its validator.
+func (bc *Blockchain) GetSignedHeader(height uint32) (*SignedHeader, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if height > bc.height() {
+		return nil, fmt.Errorf("trying get too high header (%d)", height)
+	}
 
 	return bc.headers[height], nil
 }
 
+func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
+	height, err := bc.Store.GetHeightByHash(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.Store.Get(height)
+}
+
+func (bc *Blockchain) GetTransaction(hash types.Hash) (*Transaction, TxLocation, error) {
+	loc, err := bc.Store.GetTxLocation(hash)
+
+	if err != nil {
+		return nil, TxLocation{}, err
+	}
+
+	b, errGet := bc.Store.Get(loc.Height)
+
+	if errGet != nil {
+		return nil, TxLocation{}, errGet
+	}
+
+	if int(loc.Index) >= len(b.Transactions) {
+		return nil, TxLocation{}, fmt.Errorf("%w with hash %s", TxNotFoundError, hash)
+	}
+
+	return b.Transactions[loc.Index], loc, nil
+}
+
+// GetTransactionProof returns a Merkle inclusion proof for the transaction
+// together with the header whose DataHash it verifies against.
+func (bc *Blockchain) GetTransactionProof(hash types.Hash) (*MerkleProof, *Header, error) {
+	loc, err := bc.Store.GetTxLocation(hash)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, errGet := bc.Store.Get(loc.Height)
+
+	if errGet != nil {
+		return nil, nil, errGet
+	}
+
+	proof, errProof := b.MerkleProof(hash)
+
+	if errProof != nil {
+		return nil, nil, errProof
+	}
+
+	return proof, b.Header, nil
+}
+
+// GetAccountProof proves the account against the state root of the current
+// tip header, which is returned alongside.
+func (bc *Blockchain) GetAccountProof(addr types.Address) (*StateProof, *Header, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.accountsState.Prove(addr), bc.headers[len(bc.headers)-1].Header, nil
+}
+
+func (bc *Blockchain) GetStorageProof(key []byte) (*StateProof, *Header, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.state.Prove(key), bc.headers[len(bc.headers)-1].Header, nil
+}
+
+func (bc *Blockchain) StateRoot() types.Hash {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.stateTree.Root()
+}
+
+func (bc *Blockchain) ChainID() uint32 {
+	return bc.chainID
+}
+
 func (bc *Blockchain) Height() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.height()
+}
+
+func (bc *Blockchain) height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
+// GetAccounts returns the live account state, which blocks being executed or
+// built change before they are committed; GetBalance and GetNonce read only
+// committed state.
 func (bc *Blockchain) GetAccounts() *Accounts {
 	return bc.accountsState
 }
+
+func (bc *Blockchain) GetBalance(addr types.Address) (uint64, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.accountsState.GetBalance(addr)
+}
+
+// GetNonce returns the nonce the next transaction of addr must carry.
+func (bc *Blockchain) GetNonce(addr types.Address) uint64 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.accountsState.GetNonce(addr)
+}
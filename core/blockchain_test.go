@@ -1,11 +1,13 @@
 package core
 
 import (
+	"github.com/Phanile/uretra_network/crypto"
 	"github.com/Phanile/uretra_network/types"
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestBlockchain_Create(t *testing.T) {
@@ -31,10 +33,10 @@ func TestBlockchain_AddBlock(t *testing.T) {
 
 	for i := 0; i < lenBlocks; i++ {
 		newBlock := randomBlockWithSignature(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.True(t, bc.AddBlock(newBlock))
+		assert.Nil(t, bc.AddBlock(newBlock))
 	}
 
-	assert.False(t, bc.AddBlock(randomBlockWithSignature(t, 100, types.RandomHash())))
+	assert.NotNil(t, bc.AddBlock(randomBlockWithSignature(t, 100, types.RandomHash())))
 	assert.Equal(t, bc.Height(), uint32(lenBlocks))
 }
 
@@ -43,7 +45,7 @@ func TestBlockchain_AddBlockToHigh(t *testing.T) {
 
 	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), b)
 
-	assert.False(t, bc.AddBlock(randomBlockWithSignature(t, 3, getPrevBlockHash(t, bc, uint32(1)))))
+	assert.NotNil(t, bc.AddBlock(randomBlockWithSignature(t, 3, getPrevBlockHash(t, bc, uint32(1)))))
 }
 
 func TestBlockchain_GetHeader(t *testing.T) {
@@ -54,16 +56,314 @@ func TestBlockchain_GetHeader(t *testing.T) {
 
 	for i := 0; i < lenBlocks; i++ {
 		newBlock := randomBlockWithSignature(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.True(t, bc.AddBlock(newBlock))
+		assert.Nil(t, bc.AddBlock(newBlock))
 		header, err := bc.GetHeader(newBlock.Header.Height)
 		assert.Nil(t, err)
 		assert.Equal(t, header, newBlock.Header)
 	}
 }
 
+func TestBlockchain_GetSignedHeader(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+
+	b := randomBlockWithSignature(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.AddBlock(b))
+
+	header, err := bc.GetSignedHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b.SignedHeader(), header)
+	assert.True(t, header.Verify())
+
+	_, err = bc.GetSignedHeader(2)
+	assert.NotNil(t, err)
+}
+
 func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
 	header, err := bc.GetHeader(height - 1)
 	assert.Nil(t, err)
 
 	return HeaderHasher{}.Hash(header)
 }
+
+func TestBlockchain_GetBlockByHash(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+
+	for i := 0; i < 8; i++ {
+		newBlock := randomBlockWithSignature(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		assert.Nil(t, bc.AddBlock(newBlock))
+
+		block, err := bc.GetBlockByHash(HeaderHasher{}.Hash(newBlock.Header))
+		assert.Nil(t, err)
+		assert.Equal(t, newBlock, block)
+	}
+
+	_, err := bc.GetBlockByHash(types.RandomHash())
+	assert.ErrorIs(t, err, BlockNotFoundError)
+}
+
+func TestBlockchain_GetTransaction(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+
+	newBlock := randomBlockWithSignature(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.AddBlock(newBlock))
+
+	tx := newBlock.Transactions[0]
+	found, loc, err := bc.GetTransaction(TxHasher{}.Hash(tx))
+	assert.Nil(t, err)
+	assert.Equal(t, tx, found)
+	assert.Equal(t, TxLocation{Height: 1, Index: 0}, loc)
+
+	_, _, errMissing := bc.GetTransaction(types.RandomHash())
+	assert.ErrorIs(t, errMissing, TxNotFoundError)
+}
+
+func TestBlockchain_Reorg(t *testing.T) {
+	var orphaned []*Transaction
+
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOptions(BlockchainOptions{
+		Logger:  log.NewLogfmtLogger(os.Stderr),
+		OnReorg: func(txs []*Transaction) { orphaned = append(orphaned, txs...) },
+	}, genesis)
+	assert.Nil(t, err)
+
+	a1 := randomBlockWithSignature(t, 1, HeaderHasher{}.Hash(genesis.Header))
+	a2 := randomBlockWithSignature(t, 2, HeaderHasher{}.Hash(a1.Header))
+	assert.Nil(t, bc.AddBlock(a1))
+	assert.Nil(t, bc.AddBlock(a2))
+
+	b1 := randomBlockWithSignature(t, 1, HeaderHasher{}.Hash(genesis.Header))
+	b2 := randomBlockWithSignature(t, 2, HeaderHasher{}.Hash(b1.Header))
+	b3 := randomBlockWithSignature(t, 3, HeaderHasher{}.Hash(b2.Header))
+
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, HeaderHasher{}.Hash(a2.Header), getPrevBlockHash(t, bc, 3))
+	assert.ErrorIs(t, bc.AddBlock(b2), BlockKnownError)
+
+	assert.Nil(t, bc.AddBlock(b3))
+	assert.Equal(t, uint32(3), bc.Height())
+	assert.Equal(t, HeaderHasher{}.Hash(b3.Header), getPrevBlockHash(t, bc, 4))
+
+	for _, b := range []*Block{a1, a2} {
+		balance, _ := bc.GetAccounts().GetBalance(b.Validator.Address())
+		assert.Equal(t, uint64(0), balance)
+
+		_, errByHash := bc.GetBlockByHash(HeaderHasher{}.Hash(b.Header))
+		assert.ErrorIs(t, errByHash, BlockNotFoundError)
+	}
+
+	for _, b := range []*Block{b1, b2, b3} {
+		balance, errBalance := bc.GetAccounts().GetBalance(b.Validator.Address())
+		assert.Nil(t, errBalance)
+		assert.Equal(t, BlockReward(b.Header.Height), balance)
+
+		stored, errByHash := bc.GetBlockByHash(HeaderHasher{}.Hash(b.Header))
+		assert.Nil(t, errByHash)
+		assert.Equal(t, b.Header.Height, stored.Header.Height)
+	}
+
+	assert.Equal(t, []*Transaction{a2.Transactions[0], a1.Transactions[0]}, orphaned)
+}
+
+func TestBlockchain_HeaviestChainRule(t *testing.T) {
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOptions(BlockchainOptions{
+		Logger:     log.NewLogfmtLogger(os.Stderr),
+		ForkChoice: HeaviestChainRule{},
+	}, genesis)
+	assert.Nil(t, err)
+
+	a1 := randomBlockWithSignature(t, 1, HeaderHasher{}.Hash(genesis.Header))
+	a2 := randomBlockWithSignature(t, 2, HeaderHasher{}.Hash(a1.Header))
+	assert.Nil(t, bc.AddBlock(a1))
+	assert.Nil(t, bc.AddBlock(a2))
+
+	tie := blockWithTxs(t, 1, HeaderHasher{}.Hash(genesis.Header), 3)
+	assert.Nil(t, bc.AddBlock(tie))
+	assert.Equal(t, uint32(2), bc.Height())
+
+	heavier := blockWithTxs(t, 1, HeaderHasher{}.Hash(genesis.Header), 4)
+	assert.Nil(t, bc.AddBlock(heavier))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, HeaderHasher{}.Hash(heavier.Header), getPrevBlockHash(t, bc, 2))
+
+	_, errStored := bc.Store.Get(2)
+	assert.ErrorIs(t, errStored, BlockNotFoundError)
+}
+
+func TestBlockchain_ForkTooDeep(t *testing.T) {
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOptions(BlockchainOptions{
+		Logger:        log.NewLogfmtLogger(os.Stderr),
+		MaxReorgDepth: 2,
+	}, genesis)
+	assert.Nil(t, err)
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, bc.AddBlock(randomBlockWithSignature(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))))
+	}
+
+	fork := randomBlockWithSignature(t, 1, HeaderHasher{}.Hash(genesis.Header))
+	assert.ErrorIs(t, bc.AddBlock(fork), ForkTooDeepError)
+}
+
+func TestBlockchain_ReplayProtection(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+	tx := RandomTxWithSignature(t)
+
+	b1 := signedBlock(t, 1, getPrevBlockHash(t, bc, 1), []*Transaction{tx})
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, uint64(1), bc.GetAccounts().GetNonce(tx.From.Address()))
+
+	replay := signedBlock(t, 2, getPrevBlockHash(t, bc, 2), []*Transaction{tx})
+	assert.ErrorIs(t, bc.AddBlock(replay), InvalidNonceError)
+	assert.Equal(t, uint32(1), bc.Height())
+
+	privKey := crypto.GeneratePrivateKey()
+	next := &Transaction{ChainID: DefaultChainID, Data: tx.Data, Nonce: 1}
+	assert.Nil(t, next.Sign(privKey))
+
+	gap := signedBlock(t, 2, getPrevBlockHash(t, bc, 2), []*Transaction{next})
+	assert.ErrorIs(t, bc.AddBlock(gap), InvalidNonceError)
+}
+
+func TestBlockchain_WrongChainID(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+
+	tx := &Transaction{ChainID: DefaultChainID + 1, Data: []byte("AMOUNT 5000 BTC")}
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	b := signedBlock(t, 1, getPrevBlockHash(t, bc, 1), []*Transaction{tx})
+	assert.ErrorIs(t, bc.AddBlock(b), WrongChainIDError)
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestBlockchain_Fees(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+	alice := crypto.GeneratePrivateKey()
+	validator := crypto.GeneratePrivateKey()
+	bob := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	b1, err := bc.NewBlockTemplate(nil, alice.PublicKey())
+	assert.Nil(t, err)
+	assert.Nil(t, b1.Sign(alice))
+	assert.Nil(t, bc.AddBlock(b1))
+
+	tx := &Transaction{ChainID: DefaultChainID, To: bob, Value: 100, Fee: 25}
+	assert.Nil(t, tx.Sign(alice))
+
+	b2, errTemplate := bc.NewBlockTemplate([]*Transaction{tx}, validator.PublicKey())
+	assert.Nil(t, errTemplate)
+	assert.Len(t, b2.Transactions, 1)
+	assert.Nil(t, b2.Sign(validator))
+	assert.Nil(t, bc.AddBlock(b2))
+
+	balanceAlice, _ := bc.GetAccounts().GetBalance(alice.PublicKey().Address())
+	balanceBob, _ := bc.GetAccounts().GetBalance(bob)
+	balanceValidator, _ := bc.GetAccounts().GetBalance(validator.PublicKey().Address())
+
+	assert.Equal(t, BlockReward(1)-125, balanceAlice)
+	assert.Equal(t, uint64(100), balanceBob)
+	assert.Equal(t, BlockReward(2)+25, balanceValidator)
+}
+
+func TestBlockchain_NewBlockTemplateSizeLimit(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+	txs := make([]*Transaction, 3)
+
+	for i := range txs {
+		txs[i] = &Transaction{ChainID: DefaultChainID, Data: make([]byte, MaxBlockSize/2)}
+		assert.Nil(t, txs[i].Sign(crypto.GeneratePrivateKey()))
+	}
+
+	b, err := bc.NewBlockTemplate(txs, crypto.GeneratePrivateKey().PublicKey())
+	assert.Nil(t, err)
+	assert.Equal(t, txs[:1], b.Transactions)
+
+	tooLarge := signedBlock(t, 1, getPrevBlockHash(t, bc, 1), txs[:2])
+	assert.ErrorIs(t, bc.AddBlock(tooLarge), BlockTooLargeError)
+}
+
+func blockWithTxs(t *testing.T, height uint32, prevBlockHash types.Hash, count int) *Block {
+	txs := make([]*Transaction, count)
+
+	for i := range txs {
+		txs[i] = RandomTxWithSignature(t)
+	}
+
+	return signedBlock(t, height, prevBlockHash, txs)
+}
+
+func signedBlock(t *testing.T, height uint32, prevBlockHash types.Hash, txs []*Transaction) *Block {
+	privateKey := crypto.GeneratePrivateKey()
+
+	b := NewBlock(&Header{
+		Version:       HeaderVersionMerkle,
+		PrevBlockHash: prevBlockHash,
+		Timestamp:     time.Now().UnixNano(),
+		Height:        height,
+	}, txs)
+
+	dataHash, err := CalculateDataHash(HeaderVersionMerkle, txs)
+	assert.Nil(t, err)
+
+	b.Header.DataHash = dataHash
+	assert.Nil(t, b.Sign(privateKey))
+
+	return b
+}
+
+func TestBlockchain_HeaderVersionDowngrade(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+	validator := crypto.GeneratePrivateKey()
+
+	b, err := bc.NewBlockTemplate(nil, validator.PublicKey())
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(validator))
+	assert.Nil(t, bc.AddBlock(b))
+
+	// a version without a state root would get a forged one past the check
+	downgraded, errTemplate := bc.NewBlockTemplate(nil, validator.PublicKey())
+	assert.Nil(t, errTemplate)
+	downgraded.Header.Version = HeaderVersionMerkle
+	downgraded.Header.StateRoot = types.RandomHash()
+	assert.Nil(t, downgraded.Sign(validator))
+
+	assert.ErrorIs(t, bc.AddBlock(downgraded), InvalidBlockError)
+	assert.ErrorIs(t, bc.AddBlock(downgraded), UnsupportedHeaderVersionError)
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestBlockchain_NewBlockTemplateIsolation(t *testing.T) {
+	bc := NewBlockchain(log.NewNopLogger(), randomBlockWithSignature(t, 0, types.Hash{}))
+	sender := RandomTxWithSignature(t)
+	txs := []*Transaction{sender}
+
+	for i := 0; i < 200; i++ {
+		txs = append(txs, RandomTxWithSignature(t))
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			b, err := bc.NewBlockTemplate(txs, crypto.GeneratePrivateKey().PublicKey())
+			assert.Nil(t, err)
+			assert.Len(t, b.Transactions, len(txs))
+		}
+	}()
+
+	// the nonce a template uses up is never visible outside it
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		assert.Equal(t, uint64(0), bc.GetNonce(sender.From.Address()))
+	}
+}
@@ -0,0 +1,403 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"io"
+)
+
+// CodecVersion prefixes every canonical encoding. The layout of version 1,
+// with all integers big-endian, is:
+//
+//	Transaction: version u8 | chainID u32 | len(data) u32 | data | from [33] |
+//	             to [20] | value u64 | nonce u64 | fee u64 | len(sig) u8 | sig
+//	Header:      version u8 | header version u32 | prev hash [32] |
+//	             data hash [32] | state root [32] | timestamp i64 | height u32
+//	Block:       version u8 | header | tx count u32 | txs | validator [33] |
+//	             len(sig) u8 | sig
+//	SignedHeader: version u8 | header | validator [33] | len(sig) u8 | sig
+//
+// Public keys are SEC 1 compressed; an empty key encodes as 33 zero bytes
+// but does not decode, so only signed data can be read back. Signatures are r || s as 32-byte integers, or absent with length 0. The
+// signing payload of a transaction is its encoding up to the signature.
+const CodecVersion byte = 1
+
+const (
+	headerEncodedSize = 1 + 4 + 3*32 + 8 + 4
+	txEncodedBaseSize = 1 + 4 + 4 + crypto.PublicKeyLength + 20 + 8 + 8 + 8 + 1
+	// MaxTxsPerBlock is the most transactions that fit in MaxBlockSize.
+	MaxTxsPerBlock = MaxBlockSize / txEncodedBaseSize
+)
+
+var (
+	UnsupportedCodecVersionError = errors.New("unsupported codec version")
+	MalformedEncodingError       = errors.New("malformed encoding")
+)
+
+type BinaryTxEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryTxEncoder(w io.Writer) *BinaryTxEncoder {
+	return &BinaryTxEncoder{
+		w: w,
+	}
+}
+
+func (e *BinaryTxEncoder) Encode(tx *Transaction) error {
+	cw := &codecWriter{}
+	cw.tx(tx)
+
+	_, err := e.w.Write(cw.buf.Bytes())
+
+	return err
+}
+
+type BinaryTxDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryTxDecoder(r io.Reader) *BinaryTxDecoder {
+	return &BinaryTxDecoder{
+		r: r,
+	}
+}
+
+func (d *BinaryTxDecoder) Decode(tx *Transaction) error {
+	cr := &codecReader{r: d.r}
+	cr.tx(tx)
+
+	return cr.err
+}
+
+type BinaryHeaderEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryHeaderEncoder(w io.Writer) *BinaryHeaderEncoder {
+	return &BinaryHeaderEncoder{
+		w: w,
+	}
+}
+
+func (e *BinaryHeaderEncoder) Encode(h *Header) error {
+	_, err := e.w.Write(h.Bytes())
+
+	return err
+}
+
+type BinaryHeaderDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryHeaderDecoder(r io.Reader) *BinaryHeaderDecoder {
+	return &BinaryHeaderDecoder{
+		r: r,
+	}
+}
+
+func (d *BinaryHeaderDecoder) Decode(h *Header) error {
+	cr := &codecReader{r: d.r}
+	cr.header(h)
+
+	return cr.err
+}
+
+type BinaryBlockEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryBlockEncoder(w io.Writer) *BinaryBlockEncoder {
+	return &BinaryBlockEncoder{
+		w: w,
+	}
+}
+
+func (e *BinaryBlockEncoder) Encode(b *Block) error {
+	cw := &codecWriter{}
+	cw.version()
+	cw.header(b.Header)
+	cw.uint32(uint32(len(b.Transactions)))
+
+	for _, tx := range b.Transactions {
+		cw.tx(tx)
+	}
+
+	cw.publicKey(b.Validator)
+	cw.signature(b.Signature)
+
+	_, err := e.w.Write(cw.buf.Bytes())
+
+	return err
+}
+
+type BinaryBlockDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryBlockDecoder(r io.Reader) *BinaryBlockDecoder {
+	return &BinaryBlockDecoder{
+		r: r,
+	}
+}
+
+func (d *BinaryBlockDecoder) Decode(b *Block) error {
+	cr := &codecReader{r: d.r}
+	cr.version()
+
+	b.Header = &Header{}
+	cr.header(b.Header)
+
+	count := cr.uint32()
+
+	if cr.err == nil && count > MaxTxsPerBlock {
+		cr.fail(fmt.Errorf("%w: %d transactions", MalformedEncodingError, count))
+	}
+
+	b.Transactions = nil
+
+	for i := uint32(0); i < count && cr.err == nil; i++ {
+		tx := &Transaction{}
+		cr.tx(tx)
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	b.Validator = cr.publicKey()
+	b.Signature = cr.signature()
+	b.hash = types.Hash{}
+
+	return cr.err
+}
+
+type BinarySignedHeaderEncoder struct {
+	w io.Writer
+}
+
+func NewBinarySignedHeaderEncoder(w io.Writer) *BinarySignedHeaderEncoder {
+	return &BinarySignedHeaderEncoder{
+		w: w,
+	}
+}
+
+func (e *BinarySignedHeaderEncoder) Encode(sh *SignedHeader) error {
+	cw := &codecWriter{}
+	cw.version()
+	cw.header(sh.Header)
+	cw.publicKey(sh.Validator)
+	cw.signature(sh.Signature)
+
+	_, err := e.w.Write(cw.buf.Bytes())
+
+	return err
+}
+
+type BinarySignedHeaderDecoder struct {
+	r io.Reader
+}
+
+func NewBinarySignedHeaderDecoder(r io.Reader) *BinarySignedHeaderDecoder {
+	return &BinarySignedHeaderDecoder{
+		r: r,
+	}
+}
+
+func (d *BinarySignedHeaderDecoder) Decode(sh *SignedHeader) error {
+	cr := &codecReader{r: d.r}
+	cr.version()
+
+	sh.Header = &Header{}
+	cr.header(sh.Header)
+	sh.Validator = cr.publicKey()
+	sh.Signature = cr.signature()
+
+	return cr.err
+}
+
+// SigningBytes is the canonical encoding of the transaction without its
+// signature; the transaction hash and signature are computed over it.
+func (tx *Transaction) SigningBytes() []byte {
+	cw := &codecWriter{}
+	cw.txPayload(tx)
+
+	return cw.buf.Bytes()
+}
+
+type codecWriter struct {
+	buf bytes.Buffer
+}
+
+func (cw *codecWriter) version() {
+	cw.buf.WriteByte(CodecVersion)
+}
+
+func (cw *codecWriter) uint32(v uint32) {
+	cw.buf.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (cw *codecWriter) uint64(v uint64) {
+	cw.buf.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (cw *codecWriter) publicKey(pk crypto.PublicKey) {
+	if pk.Key == nil {
+		cw.buf.Write(make([]byte, crypto.PublicKeyLength))
+		return
+	}
+
+	cw.buf.Write(pk.CompressedBytes())
+}
+
+func (cw *codecWriter) signature(s *crypto.Signature) {
+	if s == nil {
+		cw.buf.WriteByte(0)
+		return
+	}
+
+	cw.buf.WriteByte(crypto.SignatureLength)
+	cw.buf.Write(s.Bytes())
+}
+
+func (cw *codecWriter) header(h *Header) {
+	cw.version()
+	cw.uint32(h.Version)
+	cw.buf.Write(h.PrevBlockHash[:])
+	cw.buf.Write(h.DataHash[:])
+	cw.buf.Write(h.StateRoot[:])
+	cw.uint64(uint64(h.Timestamp))
+	cw.uint32(h.Height)
+}
+
+func (cw *codecWriter) txPayload(tx *Transaction) {
+	cw.version()
+	cw.uint32(tx.ChainID)
+	cw.uint32(uint32(len(tx.Data)))
+	cw.buf.Write(tx.Data)
+	cw.publicKey(tx.From)
+	cw.buf.Write(tx.To[:])
+	cw.uint64(tx.Value)
+	cw.uint64(tx.Nonce)
+	cw.uint64(tx.Fee)
+}
+
+func (cw *codecWriter) tx(tx *Transaction) {
+	cw.txPayload(tx)
+	cw.signature(tx.Signature)
+}
+
+// codecReader decodes canonical encodings and keeps the first error, so a
+// sequence of reads needs a single check at the end.
+type codecReader struct {
+	r   io.Reader
+	err error
+}
+
+func (cr *codecReader) fail(err error) {
+	if cr.err == nil {
+		cr.err = err
+	}
+}
+
+func (cr *codecReader) read(n int) []byte {
+	if cr.err != nil {
+		return make([]byte, n)
+	}
+
+	buf := make([]byte, n)
+
+	if _, err := io.ReadFull(cr.r, buf); err != nil {
+		cr.fail(err)
+	}
+
+	return buf
+}
+
+func (cr *codecReader) version() {
+	if v := cr.read(1)[0]; cr.err == nil && v != CodecVersion {
+		cr.fail(fmt.Errorf("%w: %d", UnsupportedCodecVersionError, v))
+	}
+}
+
+func (cr *codecReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(cr.read(4))
+}
+
+func (cr *codecReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(cr.read(8))
+}
+
+func (cr *codecReader) hash() types.Hash {
+	return types.HashFromBytes(cr.read(32))
+}
+
+func (cr *codecReader) publicKey() crypto.PublicKey {
+	b := cr.read(crypto.PublicKeyLength)
+
+	if cr.err != nil {
+		return crypto.PublicKey{}
+	}
+
+	pk, err := crypto.PublicKeyFromCompressed(b)
+
+	if err != nil {
+		cr.fail(fmt.Errorf("%w: %w", MalformedEncodingError, err))
+	}
+
+	return pk
+}
+
+func (cr *codecReader) signature() *crypto.Signature {
+	switch n := cr.read(1)[0]; {
+	case cr.err != nil || n == 0:
+		return nil
+	case n != crypto.SignatureLength:
+		cr.fail(fmt.Errorf("%w: signature length %d", MalformedEncodingError, n))
+		return nil
+	}
+
+	sig, err := crypto.SignatureFromBytes(cr.read(crypto.SignatureLength))
+
+	if err != nil {
+		cr.fail(err)
+	}
+
+	return sig
+}
+
+func (cr *codecReader) header(h *Header) {
+	cr.version()
+	h.Version = cr.uint32()
+	h.PrevBlockHash = cr.hash()
+	h.DataHash = cr.hash()
+	h.StateRoot = cr.hash()
+	h.Timestamp = int64(cr.uint64())
+	h.Height = cr.uint32()
+}
+
+func (cr *codecReader) tx(tx *Transaction) {
+	cr.version()
+	tx.ChainID = cr.uint32()
+
+	dataLen := cr.uint32()
+
+	if cr.err == nil && dataLen > MaxBlockSize {
+		cr.fail(fmt.Errorf("%w: data length %d", MalformedEncodingError, dataLen))
+	}
+
+	tx.Data = nil
+
+	if cr.err == nil && dataLen > 0 {
+		tx.Data = cr.read(int(dataLen))
+	}
+
+	tx.From = cr.publicKey()
+	copy(tx.To[:], cr.read(20))
+	tx.Value = cr.uint64()
+	tx.Nonce = cr.uint64()
+	tx.Fee = cr.uint64()
+	tx.Signature = cr.signature()
+	tx.hash = types.Hash{}
+}
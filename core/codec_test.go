@@ -0,0 +1,159 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Golden vectors for the canonical encoding. Clients in other languages must
+// reproduce these bytes and hashes exactly.
+const (
+	goldenTxHex = "01" + "00000001" + "00000005" + "68656c6c6f" +
+		"036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296" +
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" +
+		"00000000000003e8" + "0000000000000007" + "0000000000000003" +
+		"40" + "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20" +
+		"2122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f40"
+	goldenTxHash = "6b4370dd7861aaa8dce6ac5f0f58ae2a1757647a4fba0b1d03e4b859fb1f181d"
+
+	goldenHeaderHex = "01" + "00000003" +
+		"1111111111111111111111111111111111111111111111111111111111111111" +
+		"2222222222222222222222222222222222222222222222222222222222222222" +
+		"3333333333333333333333333333333333333333333333333333333333333333" +
+		"17979cfe362a0000" + "0000002a"
+	goldenHeaderHash = "f348ec4932656f6fd699ef2d132cf3996b2f2ce50a9379b19f313bc3bed0899c"
+)
+
+func goldenTx(t *testing.T) *Transaction {
+	// the P-256 generator point stands in for a sender key
+	g, _ := hex.DecodeString("036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296")
+	from, err := crypto.PublicKeyFromCompressed(g)
+	assert.Nil(t, err)
+
+	sigBytes := make([]byte, crypto.SignatureLength)
+
+	for i := range sigBytes {
+		sigBytes[i] = byte(i + 1)
+	}
+
+	sig, errSig := crypto.SignatureFromBytes(sigBytes)
+	assert.Nil(t, errSig)
+
+	var to types.Address
+
+	for i := range to {
+		to[i] = 0xaa
+	}
+
+	return &Transaction{
+		ChainID:   1,
+		Data:      []byte("hello"),
+		From:      from,
+		To:        to,
+		Value:     1000,
+		Nonce:     7,
+		Fee:       3,
+		Signature: sig,
+	}
+}
+
+func goldenHeader() *Header {
+	h := &Header{
+		Version:   HeaderVersionStateRoot,
+		Timestamp: 1700000000000000000,
+		Height:    42,
+	}
+
+	for i := range h.PrevBlockHash {
+		h.PrevBlockHash[i] = 0x11
+		h.DataHash[i] = 0x22
+		h.StateRoot[i] = 0x33
+	}
+
+	return h
+}
+
+func TestBinaryTxEncoder_Golden(t *testing.T) {
+	tx := goldenTx(t)
+	buf := &bytes.Buffer{}
+
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
+	assert.Equal(t, goldenTxHex, hex.EncodeToString(buf.Bytes()))
+	assert.Equal(t, buf.Len(), tx.Size())
+
+	hash := TxHasher{}.Hash(tx)
+	assert.Equal(t, goldenTxHash, hex.EncodeToString(hash[:]))
+
+	decoded := &Transaction{}
+	assert.Nil(t, decoded.Decode(NewBinaryTxDecoder(buf)))
+	assert.Equal(t, TxHasher{}.Hash(tx), TxHasher{}.Hash(decoded))
+	assert.Equal(t, tx.Signature.Bytes(), decoded.Signature.Bytes())
+}
+
+func TestBinaryHeaderEncoder_Golden(t *testing.T) {
+	h := goldenHeader()
+
+	assert.Equal(t, goldenHeaderHex, hex.EncodeToString(h.Bytes()))
+
+	hash := HeaderHasher{}.Hash(h)
+	assert.Equal(t, goldenHeaderHash, hex.EncodeToString(hash[:]))
+
+	decoded := &Header{}
+	assert.Nil(t, NewBinaryHeaderDecoder(bytes.NewReader(h.Bytes())).Decode(decoded))
+	assert.Equal(t, h, decoded)
+}
+
+func TestBinaryBlockEncoder_RoundTrip(t *testing.T) {
+	b := randomBlockWithSignature(t, 3, types.RandomHash())
+	b.AddTransaction(RandomTxWithSignature(t))
+	buf := &bytes.Buffer{}
+
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(buf)))
+
+	decoded := &Block{}
+	assert.Nil(t, decoded.Decode(NewBinaryBlockDecoder(buf)))
+	assert.Equal(t, b.Header, decoded.Header)
+	assert.Equal(t, txHashes(b), txHashes(decoded))
+	assert.Equal(t, b.Validator.Address(), decoded.Validator.Address())
+	assert.Equal(t, b.Signature.Bytes(), decoded.Signature.Bytes())
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestBinarySignedHeaderEncoder_RoundTrip(t *testing.T) {
+	b := randomBlockWithSignature(t, 3, types.RandomHash())
+	buf := &bytes.Buffer{}
+
+	assert.Nil(t, b.SignedHeader().Encode(NewBinarySignedHeaderEncoder(buf)))
+
+	decoded := &SignedHeader{}
+	assert.Nil(t, decoded.Decode(NewBinarySignedHeaderDecoder(buf)))
+	assert.Equal(t, b.Header, decoded.Header)
+	assert.True(t, decoded.Verify())
+	assert.Equal(t, 0, buf.Len())
+
+	decoded.Header.Timestamp++
+	assert.False(t, decoded.Verify())
+}
+
+func TestBinaryTxDecoder_Rejects(t *testing.T) {
+	data, _ := hex.DecodeString(goldenTxHex)
+
+	unknownVersion := bytes.Clone(data)
+	unknownVersion[0] = 2
+	assert.ErrorIs(t, (&Transaction{}).Decode(NewBinaryTxDecoder(bytes.NewReader(unknownVersion))), UnsupportedCodecVersionError)
+
+	assert.NotNil(t, (&Transaction{}).Decode(NewBinaryTxDecoder(bytes.NewReader(data[:len(data)-1]))))
+
+	zeroKey := bytes.Clone(data)
+	copy(zeroKey[14:14+crypto.PublicKeyLength], make([]byte, crypto.PublicKeyLength))
+	assert.ErrorIs(t, (&Transaction{}).Decode(NewBinaryTxDecoder(bytes.NewReader(zeroKey))), MalformedEncodingError)
+
+	offCurve := bytes.Clone(zeroKey)
+	offCurve[14] = 0x03
+	copy(offCurve[15:14+crypto.PublicKeyLength], bytes.Repeat([]byte{0xff}, crypto.PublicKeyLength-1))
+	assert.ErrorIs(t, (&Transaction{}).Decode(NewBinaryTxDecoder(bytes.NewReader(offCurve))), MalformedEncodingError)
+}
@@ -0,0 +1,20 @@
+package core
+
+type ForkChoiceRule interface {
+	BlockWeight(b *Block) uint64
+}
+
+// LongestChainRule prefers the chain with the most blocks.
+type LongestChainRule struct{}
+
+func (LongestChainRule) BlockWeight(*Block) uint64 {
+	return 1
+}
+
+// HeaviestChainRule prefers the chain that carries the most transactions,
+// each block counting as one plus its transaction count.
+type HeaviestChainRule struct{}
+
+func (HeaviestChainRule) BlockWeight(b *Block) uint64 {
+	return 1 + uint64(len(b.Transactions))
+}
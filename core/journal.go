@@ -0,0 +1,45 @@
+package core
+
+// journal records undo operations for every state mutation so a block's
+// effects on Accounts and State can be rolled back during a reorg.
+type journal struct {
+	entries []func()
+}
+
+func newJournal() *journal {
+	return &journal{}
+}
+
+func (j *journal) append(undo func()) {
+	if j == nil {
+		return
+	}
+
+	j.entries = append(j.entries, undo)
+}
+
+func (j *journal) snapshot() int {
+	return len(j.entries)
+}
+
+func (j *journal) revertTo(snapshot int) {
+	for i := len(j.entries) - 1; i >= snapshot; i-- {
+		j.entries[i]()
+	}
+
+	j.entries = j.entries[:snapshot]
+}
+
+// commit hands over the recorded entries and starts a fresh journal.
+func (j *journal) commit() []func() {
+	entries := j.entries
+	j.entries = nil
+
+	return entries
+}
+
+func revertEntries(entries []func()) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i]()
+	}
+}
@@ -0,0 +1,435 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	kvSegmentExt          = ".seg"
+	kvRecordHeaderSize    = 12
+	kvTombstone           = ^uint32(0)
+	kvCommit              = ^uint32(0)
+	defaultMaxSegmentSize = 64 << 20
+)
+
+var (
+	KeyNotFoundError      = errors.New("key not found")
+	StoreClosedError      = errors.New("store closed")
+	CorruptedSegmentError = errors.New("corrupted segment")
+)
+
+type kvPosition struct {
+	segment uint32
+	offset  int64
+	size    uint32
+}
+
+// kvStore is an append-only key/value store. Records are appended to segment
+// files and an in-memory index (key -> position) is rebuilt by scanning the
+// segments when the store is opened. Every batch ends with a commit record,
+// and a torn tail of the last segment (e.g. after a crash) is truncated back
+// to the last commit on open, so a batch is applied whole or not at all.
+//
+// Record layout: crc32 | keyLen uint32 | valueLen uint32 | key | value,
+// where the checksum covers everything after itself and valueLen equal to
+// kvTombstone marks a deleted key. A commit record has keyLen kvCommit, the
+// number of records in its batch as valueLen and no body.
+type kvStore struct {
+	mu             sync.RWMutex
+	dir            string
+	segments       map[uint32]*os.File
+	active         uint32
+	activeSize     int64
+	maxSegmentSize int64
+	index          map[string]kvPosition
+	closed         bool
+}
+
+type kvBatch struct {
+	ops []kvOp
+}
+
+type kvOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// kvScanned is a record read on open whose batch is not committed yet.
+type kvScanned struct {
+	key    string
+	pos    kvPosition
+	delete bool
+}
+
+func (b *kvBatch) Put(key string, value []byte) {
+	b.ops = append(b.ops, kvOp{key: key, value: value})
+}
+
+func (b *kvBatch) Delete(key string) {
+	b.ops = append(b.ops, kvOp{key: key, delete: true})
+}
+
+func openKVStore(dir string, maxSegmentSize int64) (*kvStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = defaultMaxSegmentSize
+	}
+
+	s := &kvStore{
+		dir:            dir,
+		segments:       make(map[uint32]*os.File),
+		maxSegmentSize: maxSegmentSize,
+		index:          make(map[string]kvPosition),
+	}
+
+	ids, err := s.segmentIDs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		ids = append(ids, 1)
+	}
+
+	for i, id := range ids {
+		f, errOpen := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE, 0600)
+
+		if errOpen != nil {
+			s.closeFiles()
+			return nil, errOpen
+		}
+
+		s.segments[id] = f
+
+		size, errScan := s.scanSegment(id, f, i == len(ids)-1)
+
+		if errScan != nil {
+			s.closeFiles()
+			return nil, errScan
+		}
+
+		s.active = id
+		s.activeSize = size
+	}
+
+	return s, nil
+}
+
+func (s *kvStore) segmentPath(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d%s", id, kvSegmentExt))
+}
+
+func (s *kvStore) segmentIDs() ([]uint32, error) {
+	entries, err := os.ReadDir(s.dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint32, 0, len(entries))
+
+	for _, e := range entries {
+		name := e.Name()
+
+		if e.IsDir() || !strings.HasSuffix(name, kvSegmentExt) {
+			continue
+		}
+
+		id, errParse := strconv.ParseUint(strings.TrimSuffix(name, kvSegmentExt), 10, 32)
+
+		if errParse != nil {
+			continue
+		}
+
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func (s *kvStore) scanSegment(id uint32, f *os.File, last bool) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	header := make([]byte, kvRecordHeaderSize)
+	var offset, committed int64
+	var pending []kvScanned
+
+	for {
+		_, err := io.ReadFull(r, header)
+
+		if err == io.EOF && len(pending) == 0 {
+			return offset, nil
+		}
+
+		if err != nil {
+			return s.truncateTail(id, f, committed, last)
+		}
+
+		sum := binary.BigEndian.Uint32(header[0:4])
+		keyLen := binary.BigEndian.Uint32(header[4:8])
+		valueLen := binary.BigEndian.Uint32(header[8:12])
+
+		if keyLen == kvCommit {
+			if crc32.ChecksumIEEE(header[4:]) != sum || int(valueLen) != len(pending) {
+				return s.truncateTail(id, f, committed, last)
+			}
+
+			s.applyScanned(pending)
+			pending = pending[:0]
+			offset += kvRecordHeaderSize
+			committed = offset
+
+			continue
+		}
+
+		bodyLen := int64(keyLen)
+		if valueLen != kvTombstone {
+			bodyLen += int64(valueLen)
+		}
+
+		if bodyLen > s.maxSegmentSize {
+			return s.truncateTail(id, f, committed, last)
+		}
+
+		body := make([]byte, bodyLen)
+
+		if _, errBody := io.ReadFull(r, body); errBody != nil {
+			return s.truncateTail(id, f, committed, last)
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+
+		if crc.Sum32() != sum {
+			return s.truncateTail(id, f, committed, last)
+		}
+
+		pending = append(pending, kvScanned{
+			key: string(body[:keyLen]),
+			pos: kvPosition{
+				segment: id,
+				offset:  offset + kvRecordHeaderSize + int64(keyLen),
+				size:    valueLen,
+			},
+			delete: valueLen == kvTombstone,
+		})
+
+		offset += kvRecordHeaderSize + bodyLen
+	}
+}
+
+func (s *kvStore) applyScanned(records []kvScanned) {
+	for _, rec := range records {
+		if rec.delete {
+			delete(s.index, rec.key)
+		} else {
+			s.index[rec.key] = rec.pos
+		}
+	}
+}
+
+func (s *kvStore) truncateTail(id uint32, f *os.File, offset int64, last bool) (int64, error) {
+	if !last {
+		return 0, fmt.Errorf("%w: %s", CorruptedSegmentError, s.segmentPath(id))
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+func (s *kvStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, StoreClosedError
+	}
+
+	pos, ok := s.index[key]
+
+	if !ok {
+		return nil, KeyNotFoundError
+	}
+
+	value := make([]byte, pos.size)
+
+	if _, err := s.segments[pos.segment].ReadAt(value, pos.offset); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (s *kvStore) Has(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.index[key]
+
+	return ok
+}
+
+func (s *kvStore) Put(key string, value []byte) error {
+	batch := &kvBatch{}
+	batch.Put(key, value)
+
+	return s.Write(batch)
+}
+
+func (s *kvStore) Delete(key string) error {
+	batch := &kvBatch{}
+	batch.Delete(key)
+
+	return s.Write(batch)
+}
+
+// Write appends all operations of the batch and its commit record with a
+// single write and syncs the segment. Records without their commit are
+// dropped on open, so either the whole batch survives a crash or none of it
+// does.
+func (s *kvStore) Write(batch *kvBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return StoreClosedError
+	}
+
+	buf := &bytes.Buffer{}
+	offsets := make([]int64, len(batch.ops))
+
+	for i, op := range batch.ops {
+		offsets[i] = int64(buf.Len())
+
+		header := make([]byte, kvRecordHeaderSize)
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(op.key)))
+
+		if op.delete {
+			binary.BigEndian.PutUint32(header[8:12], kvTombstone)
+		} else {
+			binary.BigEndian.PutUint32(header[8:12], uint32(len(op.value)))
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write([]byte(op.key))
+
+		if !op.delete {
+			crc.Write(op.value)
+		}
+
+		binary.BigEndian.PutUint32(header[0:4], crc.Sum32())
+
+		buf.Write(header)
+		buf.WriteString(op.key)
+
+		if !op.delete {
+			buf.Write(op.value)
+		}
+	}
+
+	commit := make([]byte, kvRecordHeaderSize)
+	binary.BigEndian.PutUint32(commit[4:8], kvCommit)
+	binary.BigEndian.PutUint32(commit[8:12], uint32(len(batch.ops)))
+	binary.BigEndian.PutUint32(commit[0:4], crc32.ChecksumIEEE(commit[4:]))
+	buf.Write(commit)
+
+	if s.activeSize > 0 && s.activeSize+int64(buf.Len()) > s.maxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	f := s.segments[s.active]
+
+	if _, err := f.WriteAt(buf.Bytes(), s.activeSize); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	for i, op := range batch.ops {
+		if op.delete {
+			delete(s.index, op.key)
+			continue
+		}
+
+		s.index[op.key] = kvPosition{
+			segment: s.active,
+			offset:  s.activeSize + offsets[i] + kvRecordHeaderSize + int64(len(op.key)),
+			size:    uint32(len(op.value)),
+		}
+	}
+
+	s.activeSize += int64(buf.Len())
+
+	return nil
+}
+
+func (s *kvStore) rotate() error {
+	id := s.active + 1
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	s.segments[id] = f
+	s.active = id
+	s.activeSize = 0
+
+	return nil
+}
+
+func (s *kvStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	return s.closeFiles()
+}
+
+func (s *kvStore) closeFiles() error {
+	var firstErr error
+
+	for _, f := range s.segments {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"crypto/sha256"
+	"errors"
+	"github.com/Phanile/uretra_network/types"
+)
+
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+var (
+	TxNotInBlockError             = errors.New("transaction not in block")
+	UnsupportedHeaderVersionError = errors.New("unsupported header version")
+)
+
+// MerkleProof proves that TxHash is the Index-th leaf of a Merkle tree with
+// LeafCount leaves. Siblings are ordered from the leaf level up; a level where
+// the node is the unpaired last one has no sibling and is promoted as is.
+type MerkleProof struct {
+	TxHash    types.Hash
+	Index     uint32
+	LeafCount uint32
+	Siblings  []types.Hash
+}
+
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+
+	level := make([]types.Hash, len(leaves))
+
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf)
+	}
+
+	for len(level) > 1 {
+		level = merkleNextLevel(level)
+	}
+
+	return level[0]
+}
+
+func BuildMerkleProof(leaves []types.Hash, txHash types.Hash) (*MerkleProof, error) {
+	index := -1
+
+	for i, leaf := range leaves {
+		if leaf == txHash {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return nil, TxNotInBlockError
+	}
+
+	proof := &MerkleProof{
+		TxHash:    txHash,
+		Index:     uint32(index),
+		LeafCount: uint32(len(leaves)),
+	}
+
+	level := make([]types.Hash, len(leaves))
+
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf)
+	}
+
+	for idx := index; len(level) > 1; idx /= 2 {
+		sibling := idx ^ 1
+
+		if sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sibling])
+		}
+
+		level = merkleNextLevel(level)
+	}
+
+	return proof, nil
+}
+
+func VerifyMerkleProof(root types.Hash, proof *MerkleProof) bool {
+	if proof == nil || proof.Index >= proof.LeafCount {
+		return false
+	}
+
+	hash := merkleLeaf(proof.TxHash)
+	idx, count := proof.Index, proof.LeafCount
+	siblings := proof.Siblings
+
+	for count > 1 {
+		if idx%2 == 1 || idx+1 < count {
+			if len(siblings) == 0 {
+				return false
+			}
+
+			if idx%2 == 0 {
+				hash = merkleNode(hash, siblings[0])
+			} else {
+				hash = merkleNode(siblings[0], hash)
+			}
+
+			siblings = siblings[1:]
+		}
+
+		idx /= 2
+		count = (count + 1) / 2
+	}
+
+	return len(siblings) == 0 && hash == root
+}
+
+func merkleNextLevel(level []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(level)+1)/2)
+
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+func merkleLeaf(h types.Hash) types.Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, h[:]...))
+}
+
+func merkleNode(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return sha256.Sum256(buf)
+}
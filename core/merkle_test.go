@@ -0,0 +1,74 @@
+package core
+
+import (
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestMerkle_ProofForEveryLeaf(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		leaves := make([]types.Hash, count)
+
+		for i := range leaves {
+			leaves[i] = types.RandomHash()
+		}
+
+		root := MerkleRoot(leaves)
+
+		for _, leaf := range leaves {
+			proof, err := BuildMerkleProof(leaves, leaf)
+			assert.Nil(t, err)
+			assert.True(t, VerifyMerkleProof(root, proof))
+		}
+	}
+}
+
+func TestMerkle_RejectsTamperedProof(t *testing.T) {
+	leaves := []types.Hash{types.RandomHash(), types.RandomHash(), types.RandomHash()}
+	root := MerkleRoot(leaves)
+
+	proof, err := BuildMerkleProof(leaves, leaves[1])
+	assert.Nil(t, err)
+
+	proof.TxHash = types.RandomHash()
+	assert.False(t, VerifyMerkleProof(root, proof))
+
+	proof, _ = BuildMerkleProof(leaves, leaves[2])
+	proof.Index = 1
+	assert.False(t, VerifyMerkleProof(root, proof))
+
+	_, errMissing := BuildMerkleProof(leaves, types.RandomHash())
+	assert.ErrorIs(t, errMissing, TxNotInBlockError)
+}
+
+func TestBlockchain_GetTransactionProof(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+
+	b := blockWithTxs(t, 1, getPrevBlockHash(t, bc, 1), 5)
+	assert.Nil(t, bc.AddBlock(b))
+
+	for _, tx := range b.Transactions {
+		proof, header, err := bc.GetTransactionProof(TxHasher{}.Hash(tx))
+		assert.Nil(t, err)
+		assert.True(t, VerifyMerkleProof(header.DataHash, proof))
+	}
+}
+
+func TestBlock_VerifyLegacyDataHash(t *testing.T) {
+	b := blockWithTxs(t, 1, types.RandomHash(), 2)
+	b.Header.Version = HeaderVersionLegacy
+
+	dataHash, err := b.CalculateDataHash(b.Transactions)
+	assert.Nil(t, err)
+
+	b.Header.DataHash = dataHash
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.True(t, b.Verify())
+
+	_, errProof := b.MerkleProof(TxHasher{}.Hash(b.Transactions[0]))
+	assert.ErrorIs(t, errProof, UnsupportedHeaderVersionError)
+}
@@ -2,28 +2,38 @@ package core
 
 import "fmt"
 
+const storageKeyPrefix = "storage/"
+
 type State struct {
-	data map[string][]byte
+	tree *StateTree
 }
 
 func NewState() *State {
+	return NewStateWithTree(NewStateTree())
+}
+
+func NewStateWithTree(tree *StateTree) *State {
 	return &State{
-		data: make(map[string][]byte),
+		tree: tree,
 	}
 }
 
+func StorageKey(k []byte) []byte {
+	return append([]byte(storageKeyPrefix), k...)
+}
+
 func (s *State) Put(k, v []byte) error {
-	s.data[string(k)] = v
+	s.tree.Put(StorageKey(k), v)
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
-	delete(s.data, string(k))
+	s.tree.Delete(StorageKey(k))
 	return nil
 }
 
 func (s *State) Get(k []byte) ([]byte, error) {
-	value, ok := s.data[string(k)]
+	value, ok := s.tree.Get(StorageKey(k))
 
 	if !ok {
 		return nil, fmt.Errorf("key not found")
@@ -31,3 +41,7 @@ func (s *State) Get(k []byte) ([]byte, error) {
 
 	return value, nil
 }
+
+func (s *State) Prove(k []byte) *StateProof {
+	return s.tree.Prove(StorageKey(k))
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/Phanile/uretra_network/types"
+	"sync"
+)
+
+const (
+	stateLeafPrefix byte = 0x00
+	stateNodePrefix byte = 0x01
+)
+
+// StateTree is a compact sparse Merkle tree over sha256(key). An empty
+// subtree hashes to the zero hash and a subtree holding a single leaf is
+// represented by that leaf's hash, so the root depends only on the set of
+// key/value pairs and paths stop as soon as keys diverge. Node hashes are
+// kept, so a change only rehashes the path to its leaf.
+type StateTree struct {
+	mu      sync.RWMutex
+	leaves  map[types.Hash][]byte
+	root    *stateNode
+	journal *journal
+}
+
+// StateProof proves the value stored under Key (Value == nil proves absence).
+// Siblings are ordered from the root down. An absence proof may end in a
+// different leaf that occupies the subtree the key would be placed in.
+type StateProof struct {
+	Key           []byte
+	Value         []byte
+	Siblings      []types.Hash
+	HasOtherLeaf  bool
+	OtherKeyHash  types.Hash
+	OtherLeafHash types.Hash
+}
+
+// stateNode is a leaf if it has no children. An inner node holds at least
+// two leaves; a nil child is an empty subtree.
+type stateNode struct {
+	keyHash     types.Hash
+	valueHash   types.Hash
+	left, right *stateNode
+	hash        types.Hash
+}
+
+func NewStateTree() *StateTree {
+	return &StateTree{
+		leaves: make(map[types.Hash][]byte),
+	}
+}
+
+func (t *StateTree) Get(key []byte) ([]byte, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	value, ok := t.leaves[sha256.Sum256(key)]
+
+	return value, ok
+}
+
+func (t *StateTree) Put(key, value []byte) {
+	t.set(sha256.Sum256(key), bytes.Clone(value), true)
+}
+
+func (t *StateTree) Delete(key []byte) {
+	t.set(sha256.Sum256(key), nil, false)
+}
+
+func (t *StateTree) set(keyHash types.Hash, value []byte, exists bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev, existed := t.leaves[keyHash]
+
+	t.journal.append(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.setNoLock(keyHash, prev, existed)
+	})
+
+	t.setNoLock(keyHash, value, exists)
+}
+
+func (t *StateTree) setNoLock(keyHash types.Hash, value []byte, exists bool) {
+	if exists {
+		t.leaves[keyHash] = value
+		t.root = insertLeaf(t.root, newStateLeaf(keyHash, sha256.Sum256(value)), 0)
+	} else {
+		delete(t.leaves, keyHash)
+		t.root = deleteLeaf(t.root, keyHash, 0)
+	}
+}
+
+func (t *StateTree) Root() types.Hash {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.root.hashOrZero()
+}
+
+func (t *StateTree) Prove(key []byte) *StateProof {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	keyHash := types.Hash(sha256.Sum256(key))
+	proof := &StateProof{
+		Key: bytes.Clone(key),
+	}
+
+	if value, ok := t.leaves[keyHash]; ok {
+		proof.Value = bytes.Clone(value)
+	}
+
+	node := t.root
+
+	for depth := 0; node != nil && !node.isLeaf(); depth++ {
+		if bitAt(keyHash, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, node.right.hashOrZero())
+			node = node.left
+		} else {
+			proof.Siblings = append(proof.Siblings, node.left.hashOrZero())
+			node = node.right
+		}
+	}
+
+	if node != nil && node.keyHash != keyHash {
+		proof.HasOtherLeaf = true
+		proof.OtherKeyHash = node.keyHash
+		proof.OtherLeafHash = node.valueHash
+	}
+
+	return proof
+}
+
+func VerifyStateProof(root types.Hash, proof *StateProof) bool {
+	if proof == nil || len(proof.Siblings) > 256 {
+		return false
+	}
+
+	keyHash := types.Hash(sha256.Sum256(proof.Key))
+	depth := len(proof.Siblings)
+
+	var hash types.Hash
+
+	switch {
+	case proof.Value != nil:
+		if proof.HasOtherLeaf {
+			return false
+		}
+
+		hash = leafHash(keyHash, sha256.Sum256(proof.Value))
+	case proof.HasOtherLeaf:
+		if proof.OtherKeyHash == keyHash {
+			return false
+		}
+
+		for i := 0; i < depth; i++ {
+			if bitAt(proof.OtherKeyHash, i) != bitAt(keyHash, i) {
+				return false
+			}
+		}
+
+		hash = leafHash(proof.OtherKeyHash, proof.OtherLeafHash)
+	}
+
+	for i := depth - 1; i >= 0; i-- {
+		if bitAt(keyHash, i) == 0 {
+			hash = nodeHash(hash, proof.Siblings[i])
+		} else {
+			hash = nodeHash(proof.Siblings[i], hash)
+		}
+	}
+
+	return hash == root
+}
+
+func newStateLeaf(keyHash, valueHash types.Hash) *stateNode {
+	return &stateNode{
+		keyHash:   keyHash,
+		valueHash: valueHash,
+		hash:      leafHash(keyHash, valueHash),
+	}
+}
+
+func newStateNode(left, right *stateNode) *stateNode {
+	return &stateNode{
+		left:  left,
+		right: right,
+		hash:  nodeHash(left.hashOrZero(), right.hashOrZero()),
+	}
+}
+
+func (n *stateNode) isLeaf() bool {
+	return n.left == nil && n.right == nil
+}
+
+func (n *stateNode) hashOrZero() types.Hash {
+	if n == nil {
+		return types.Hash{}
+	}
+
+	return n.hash
+}
+
+// insertLeaf returns the subtree at depth with leaf added or replaced,
+// rebuilding only the nodes on its path.
+func insertLeaf(n *stateNode, leaf *stateNode, depth int) *stateNode {
+	switch {
+	case n == nil:
+		return leaf
+	case n.isLeaf() && n.keyHash == leaf.keyHash:
+		return leaf
+	case n.isLeaf():
+		// the two leaves share the subtree, so it splits at depth
+		if bitAt(n.keyHash, depth) == 0 {
+			return insertLeaf(newStateNode(n, nil), leaf, depth)
+		}
+
+		return insertLeaf(newStateNode(nil, n), leaf, depth)
+	case bitAt(leaf.keyHash, depth) == 0:
+		return newStateNode(insertLeaf(n.left, leaf, depth+1), n.right)
+	default:
+		return newStateNode(n.left, insertLeaf(n.right, leaf, depth+1))
+	}
+}
+
+// deleteLeaf returns the subtree at depth without the leaf of keyHash. An
+// inner node left with a single leaf collapses into it.
+func deleteLeaf(n *stateNode, keyHash types.Hash, depth int) *stateNode {
+	if n == nil {
+		return nil
+	}
+
+	if n.isLeaf() {
+		if n.keyHash == keyHash {
+			return nil
+		}
+
+		return n
+	}
+
+	left, right := n.left, n.right
+
+	if bitAt(keyHash, depth) == 0 {
+		left = deleteLeaf(left, keyHash, depth+1)
+	} else {
+		right = deleteLeaf(right, keyHash, depth+1)
+	}
+
+	switch {
+	case left == n.left && right == n.right:
+		return n
+	case left == nil && right.isLeaf():
+		return right
+	case right == nil && left.isLeaf():
+		return left
+	}
+
+	return newStateNode(left, right)
+}
+
+func bitAt(h types.Hash, depth int) byte {
+	return (h[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+func leafHash(keyHash, valueHash types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*len(keyHash))
+	buf = append(buf, stateLeafPrefix)
+	buf = append(buf, keyHash[:]...)
+	buf = append(buf, valueHash[:]...)
+
+	return sha256.Sum256(buf)
+}
+
+func nodeHash(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, stateNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return sha256.Sum256(buf)
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"fmt"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestStateTree_Proofs(t *testing.T) {
+	tree := NewStateTree()
+
+	for i := 0; i < 32; i++ {
+		tree.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	root := tree.Root()
+
+	for i := 0; i < 32; i++ {
+		proof := tree.Prove([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), proof.Value)
+		assert.True(t, VerifyStateProof(root, proof))
+
+		proof.Value = []byte("forged")
+		assert.False(t, VerifyStateProof(root, proof))
+	}
+
+	for i := 32; i < 64; i++ {
+		proof := tree.Prove([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, proof.Value)
+		assert.True(t, VerifyStateProof(root, proof))
+
+		proof.Value = []byte("forged")
+		assert.False(t, VerifyStateProof(root, proof))
+	}
+}
+
+func TestStateTree_RootIndependentOfOrder(t *testing.T) {
+	a := NewStateTree()
+	b := NewStateTree()
+
+	for i := 0; i < 16; i++ {
+		a.Put([]byte{byte(i)}, []byte{byte(i * 2)})
+		b.Put([]byte{byte(15 - i)}, []byte{byte((15 - i) * 2)})
+	}
+
+	assert.Equal(t, a.Root(), b.Root())
+
+	a.Delete([]byte{3})
+	assert.NotEqual(t, a.Root(), b.Root())
+
+	b.Delete([]byte{3})
+	assert.Equal(t, a.Root(), b.Root())
+	assert.Equal(t, types.Hash{}, NewStateTree().Root())
+}
+
+func TestStateTree_JournalRevert(t *testing.T) {
+	tree := NewStateTree()
+	tree.Put([]byte("a"), []byte("1"))
+	root := tree.Root()
+
+	tree.journal = newJournal()
+	tree.Put([]byte("a"), []byte("2"))
+	tree.Put([]byte("b"), []byte("3"))
+	assert.NotEqual(t, root, tree.Root())
+
+	tree.journal.revertTo(0)
+	assert.Equal(t, root, tree.Root())
+
+	_, ok := tree.Get([]byte("b"))
+	assert.False(t, ok)
+}
+
+func TestStateTree_DeleteMatchesRebuild(t *testing.T) {
+	tree := NewStateTree()
+	rebuilt := NewStateTree()
+
+	for i := 0; i < 256; i++ {
+		tree.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	for i := 0; i < 256; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+
+		if i%5 == 0 {
+			rebuilt.Put(key, []byte(fmt.Sprintf("value-%d", i)))
+		} else {
+			tree.Delete(key)
+		}
+	}
+
+	assert.Equal(t, rebuilt.Root(), tree.Root())
+
+	for i := 0; i < 256; i++ {
+		proof := tree.Prove([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Equal(t, i%5 == 0, proof.Value != nil)
+		assert.True(t, VerifyStateProof(rebuilt.Root(), proof))
+	}
+}
+
+func TestNewGenesisBlock(t *testing.T) {
+	coinbase := crypto.ZeroPublicKey()
+	genesis, err := NewGenesisBlock([]*Transaction{NewTransaction(nil, coinbase, coinbase.Address(), 1000, 0)})
+	assert.Nil(t, err)
+	assert.Nil(t, genesis.Sign(crypto.GeneratePrivateKey()))
+
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), genesis)
+	assert.Equal(t, genesis.Header.StateRoot, bc.StateRoot())
+
+	proof, header, errProof := bc.GetAccountProof(coinbase.Address())
+	assert.Nil(t, errProof)
+	assert.Equal(t, uint32(0), header.Height)
+	assert.True(t, VerifyStateProof(header.StateRoot, proof))
+}
+
+func TestBlockchain_StateRoot(t *testing.T) {
+	bc := NewBlockchain(log.NewLogfmtLogger(os.Stderr), randomBlockWithSignature(t, 0, types.Hash{}))
+	validator := crypto.GeneratePrivateKey()
+
+	b, err := bc.NewBlockTemplate([]*Transaction{RandomTxWithSignature(t)}, validator.PublicKey())
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(validator))
+
+	forged, errForged := bc.NewBlockTemplate(nil, validator.PublicKey())
+	assert.Nil(t, errForged)
+	forged.Header.StateRoot = types.RandomHash()
+	assert.Nil(t, forged.Sign(validator))
+	assert.ErrorIs(t, bc.AddBlock(forged), StateRootMismatchError)
+
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, b.Header.StateRoot, bc.StateRoot())
+
+	proof, header, errProof := bc.GetAccountProof(validator.PublicKey().Address())
+	assert.Nil(t, errProof)
+	assert.True(t, VerifyStateProof(header.StateRoot, proof))
+
+	acc := &Account{Address: validator.PublicKey().Address(), Balance: BlockReward(1)}
+	assert.Equal(t, acc.Bytes(), proof.Value)
+}
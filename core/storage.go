@@ -1,27 +1,50 @@
 package core
 
 import (
-	"encoding/json"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"os"
+	"github.com/Phanile/uretra_network/types"
 	"sync"
 )
 
+var (
+	EmptyStorageError  = errors.New("storage is empty")
+	BlockNotFoundError = errors.New("block not found")
+	TxNotFoundError    = errors.New("transaction not found")
+)
+
 type Storage interface {
 	Put(*Block) error
 	Get(height uint32) (*Block, error)
+	GetHeader(height uint32) (*Header, error)
+	Height() (uint32, error)
+	Truncate(height uint32) error
+	IndexBlock(blockHash types.Hash, height uint32, txHashes []types.Hash) error
+	UnindexBlock(blockHash types.Hash, txHashes []types.Hash) error
+	GetHeightByHash(hash types.Hash) (uint32, error)
+	GetTxLocation(hash types.Hash) (TxLocation, error)
+	Close() error
+}
+
+type TxLocation struct {
+	Height uint32
+	Index  uint32
 }
 
 type MemoryStorage struct {
-	mu         sync.RWMutex
-	blockchain *Blockchain
-	baseDir    string
+	mu        sync.RWMutex
+	blocks    map[uint32]*Block
+	hashIndex map[types.Hash]uint32
+	txIndex   map[types.Hash]TxLocation
 }
 
-func NewMemoryStorage(blockchain *Blockchain) *MemoryStorage {
+func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		blockchain: blockchain,
-		baseDir:    "./storageBlocks/",
+		blocks:    make(map[uint32]*Block),
+		hashIndex: make(map[types.Hash]uint32),
+		txIndex:   make(map[types.Hash]TxLocation),
 	}
 }
 
@@ -29,30 +52,289 @@ func (ms *MemoryStorage) Put(b *Block) error {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	data, err := json.Marshal(b)
+	ms.blocks[b.Header.Height] = b
+
+	return nil
+}
+
+func (ms *MemoryStorage) Get(height uint32) (*Block, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	b, ok := ms.blocks[height]
+
+	if !ok {
+		return nil, fmt.Errorf("%w at height %d", BlockNotFoundError, height)
+	}
+
+	return b, nil
+}
+
+func (ms *MemoryStorage) GetHeader(height uint32) (*Header, error) {
+	b, err := ms.Get(height)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	filename := fmt.Sprintf("%s/%d.json", ms.baseDir, b.Header.Height)
-	return os.WriteFile(filename, data, 0600)
+	return b.Header, nil
 }
 
-func (ms *MemoryStorage) Get(height uint32) (*Block, error) {
+func (ms *MemoryStorage) Height() (uint32, error) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
 
-	filename := fmt.Sprintf("%s%d.json", ms.baseDir, height)
-	data, err := os.ReadFile(filename)
+	if len(ms.blocks) == 0 {
+		return 0, EmptyStorageError
+	}
+
+	return uint32(len(ms.blocks) - 1), nil
+}
+
+func (ms *MemoryStorage) Truncate(height uint32) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for h := height + 1; ; h++ {
+		if _, ok := ms.blocks[h]; !ok {
+			return nil
+		}
+
+		delete(ms.blocks, h)
+	}
+}
+
+func (ms *MemoryStorage) IndexBlock(blockHash types.Hash, height uint32, txHashes []types.Hash) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.hashIndex[blockHash] = height
+
+	for i, txHash := range txHashes {
+		ms.txIndex[txHash] = TxLocation{
+			Height: height,
+			Index:  uint32(i),
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorage) UnindexBlock(blockHash types.Hash, txHashes []types.Hash) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.hashIndex, blockHash)
+
+	for _, txHash := range txHashes {
+		delete(ms.txIndex, txHash)
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorage) GetHeightByHash(hash types.Hash) (uint32, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	height, ok := ms.hashIndex[hash]
+
+	if !ok {
+		return 0, fmt.Errorf("%w with hash %s", BlockNotFoundError, hash)
+	}
+
+	return height, nil
+}
+
+func (ms *MemoryStorage) GetTxLocation(hash types.Hash) (TxLocation, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	loc, ok := ms.txIndex[hash]
+
+	if !ok {
+		return TxLocation{}, fmt.Errorf("%w with hash %s", TxNotFoundError, hash)
+	}
+
+	return loc, nil
+}
+
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+const (
+	blockKeyPrefix  = "b/"
+	headerKeyPrefix = "h/"
+	hashKeyPrefix   = "H/"
+	txKeyPrefix     = "t/"
+	heightMetaKey   = "m/height"
+)
+
+type DiskStorage struct {
+	db *kvStore
+}
+
+func NewDiskStorage(dataDir string) (*DiskStorage, error) {
+	db, err := openKVStore(dataDir, defaultMaxSegmentSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskStorage{
+		db: db,
+	}, nil
+}
+
+func (ds *DiskStorage) Put(b *Block) error {
+	blockBuf := &bytes.Buffer{}
+
+	if err := b.Encode(NewBinaryBlockEncoder(blockBuf)); err != nil {
+		return err
+	}
+
+	batch := &kvBatch{}
+	batch.Put(heightKey(blockKeyPrefix, b.Header.Height), blockBuf.Bytes())
+	batch.Put(heightKey(headerKeyPrefix, b.Header.Height), b.Header.Bytes())
+	batch.Put(heightMetaKey, encodeUint32(b.Header.Height))
+
+	return ds.db.Write(batch)
+}
+
+func (ds *DiskStorage) Get(height uint32) (*Block, error) {
+	data, err := ds.db.Get(heightKey(blockKeyPrefix, height))
+
+	if errors.Is(err, KeyNotFoundError) {
+		return nil, fmt.Errorf("%w at height %d", BlockNotFoundError, height)
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
-	var block Block
-	if errUnmarshall := json.Unmarshal(data, &block); errUnmarshall != nil {
-		return nil, errUnmarshall
+	b := &Block{}
+
+	if errDecode := b.Decode(NewBinaryBlockDecoder(bytes.NewReader(data))); errDecode != nil {
+		return nil, errDecode
+	}
+
+	b.Hash(HeaderHasher{})
+
+	return b, nil
+}
+
+func (ds *DiskStorage) GetHeader(height uint32) (*Header, error) {
+	data, err := ds.db.Get(heightKey(headerKeyPrefix, height))
+
+	if errors.Is(err, KeyNotFoundError) {
+		return nil, fmt.Errorf("%w at height %d", BlockNotFoundError, height)
 	}
 
-	return &block, nil
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Header{}
+
+	if errDecode := NewBinaryHeaderDecoder(bytes.NewReader(data)).Decode(h); errDecode != nil {
+		return nil, errDecode
+	}
+
+	return h, nil
+}
+
+func (ds *DiskStorage) Height() (uint32, error) {
+	data, err := ds.db.Get(heightMetaKey)
+
+	if errors.Is(err, KeyNotFoundError) {
+		return 0, EmptyStorageError
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(data), nil
+}
+
+func (ds *DiskStorage) Truncate(height uint32) error {
+	batch := &kvBatch{}
+
+	for h := height + 1; ds.db.Has(heightKey(blockKeyPrefix, h)); h++ {
+		batch.Delete(heightKey(blockKeyPrefix, h))
+		batch.Delete(heightKey(headerKeyPrefix, h))
+	}
+
+	batch.Put(heightMetaKey, encodeUint32(height))
+
+	return ds.db.Write(batch)
+}
+
+func (ds *DiskStorage) IndexBlock(blockHash types.Hash, height uint32, txHashes []types.Hash) error {
+	batch := &kvBatch{}
+	batch.Put(hashKeyPrefix+string(blockHash[:]), encodeUint32(height))
+
+	for i, txHash := range txHashes {
+		batch.Put(txKeyPrefix+string(txHash[:]), append(encodeUint32(height), encodeUint32(uint32(i))...))
+	}
+
+	return ds.db.Write(batch)
+}
+
+func (ds *DiskStorage) UnindexBlock(blockHash types.Hash, txHashes []types.Hash) error {
+	batch := &kvBatch{}
+	batch.Delete(hashKeyPrefix + string(blockHash[:]))
+
+	for _, txHash := range txHashes {
+		batch.Delete(txKeyPrefix + string(txHash[:]))
+	}
+
+	return ds.db.Write(batch)
+}
+
+func (ds *DiskStorage) GetHeightByHash(hash types.Hash) (uint32, error) {
+	data, err := ds.db.Get(hashKeyPrefix + string(hash[:]))
+
+	if errors.Is(err, KeyNotFoundError) {
+		return 0, fmt.Errorf("%w with hash %s", BlockNotFoundError, hash)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(data), nil
+}
+
+func (ds *DiskStorage) GetTxLocation(hash types.Hash) (TxLocation, error) {
+	data, err := ds.db.Get(txKeyPrefix + string(hash[:]))
+
+	if errors.Is(err, KeyNotFoundError) {
+		return TxLocation{}, fmt.Errorf("%w with hash %s", TxNotFoundError, hash)
+	}
+
+	if err != nil {
+		return TxLocation{}, err
+	}
+
+	return TxLocation{
+		Height: binary.BigEndian.Uint32(data[0:4]),
+		Index:  binary.BigEndian.Uint32(data[4:8]),
+	}, nil
+}
+
+func (ds *DiskStorage) Close() error {
+	return ds.db.Close()
+}
+
+func heightKey(prefix string, height uint32) string {
+	return prefix + string(encodeUint32(height))
+}
+
+func encodeUint32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+
+	return buf
 }
@@ -16,7 +16,7 @@ func TestStorage_Get(t *testing.T) {
 
 	for i := 0; i < lenBlocks; i++ {
 		newBlock := randomBlockWithSignature(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.True(t, bc.AddBlock(newBlock))
+		assert.Nil(t, bc.AddBlock(newBlock))
 		header, err := bc.GetHeader(newBlock.Header.Height)
 		assert.Nil(t, err)
 		assert.Equal(t, header, newBlock.Header)
@@ -25,3 +25,123 @@ func TestStorage_Get(t *testing.T) {
 		assert.Equal(t, block, newBlock)
 	}
 }
+
+func TestDiskStorage_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskStorage(dir)
+	assert.Nil(t, err)
+
+	bc, errBc := NewBlockchainWithOptions(BlockchainOptions{Logger: log.NewLogfmtLogger(os.Stderr), Store: store}, randomBlockWithSignature(t, 0, types.Hash{}))
+	assert.Nil(t, errBc)
+
+	lenBlocks := 16
+
+	for i := 0; i < lenBlocks; i++ {
+		newBlock := randomBlockWithSignature(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		assert.Nil(t, bc.AddBlock(newBlock))
+	}
+
+	assert.Nil(t, store.Close())
+
+	reopened, errOpen := NewDiskStorage(dir)
+	assert.Nil(t, errOpen)
+
+	bc2, errBc2 := NewBlockchainWithOptions(BlockchainOptions{Logger: log.NewLogfmtLogger(os.Stderr), Store: reopened}, randomBlockWithSignature(t, 0, types.Hash{}))
+	assert.Nil(t, errBc2)
+	assert.Equal(t, uint32(lenBlocks), bc2.Height())
+
+	for i := 0; i <= lenBlocks; i++ {
+		h1, _ := bc.GetHeader(uint32(i))
+		h2, errHeader := bc2.GetHeader(uint32(i))
+		assert.Nil(t, errHeader)
+		assert.Equal(t, HeaderHasher{}.Hash(h1), HeaderHasher{}.Hash(h2))
+
+		storedHeader, errStored := reopened.GetHeader(uint32(i))
+		assert.Nil(t, errStored)
+		assert.Equal(t, h1, storedHeader)
+
+		block, errByHash := bc2.GetBlockByHash(HeaderHasher{}.Hash(h1))
+		assert.Nil(t, errByHash)
+		assert.Equal(t, uint32(i), block.Header.Height)
+
+		for j, tx := range block.Transactions {
+			_, loc, errTx := bc2.GetTransaction(TxHasher{}.Hash(tx))
+			assert.Nil(t, errTx)
+			assert.Equal(t, TxLocation{Height: uint32(i), Index: uint32(j)}, loc)
+		}
+	}
+
+	assert.Nil(t, reopened.Close())
+}
+
+func TestKVStore_DropsTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openKVStore(dir, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put("a", []byte("first")))
+	committed := db.activeSize
+
+	batch := &kvBatch{}
+	batch.Put("b", []byte("second"))
+	batch.Put("c", []byte("third"))
+	batch.Delete("a")
+	assert.Nil(t, db.Write(batch))
+	assert.Nil(t, db.Close())
+
+	// cut the batch after its first two records, before the tombstone and
+	// the commit
+	torn := committed + 2*kvRecordHeaderSize + int64(len("b")+len("second")+len("c")+len("third"))
+	assert.Nil(t, os.Truncate(db.segmentPath(1), torn))
+
+	db2, errReopen := openKVStore(dir, 0)
+	assert.Nil(t, errReopen)
+	assert.Equal(t, committed, db2.activeSize)
+	assert.False(t, db2.Has("b"))
+	assert.False(t, db2.Has("c"))
+
+	value, errGet := db2.Get("a")
+	assert.Nil(t, errGet)
+	assert.Equal(t, []byte("first"), value)
+
+	assert.Nil(t, db2.Put("d", []byte("fourth")))
+	assert.Nil(t, db2.Close())
+
+	db3, errReopen3 := openKVStore(dir, 0)
+	assert.Nil(t, errReopen3)
+	assert.True(t, db3.Has("a"))
+	assert.True(t, db3.Has("d"))
+	assert.Nil(t, db3.Close())
+}
+
+func TestKVStore_TruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openKVStore(dir, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put("a", []byte("first")))
+	assert.Nil(t, db.Put("b", []byte("second")))
+	assert.Nil(t, db.Delete("a"))
+	assert.Nil(t, db.Close())
+
+	f, errOpen := os.OpenFile(db.segmentPath(1), os.O_WRONLY|os.O_APPEND, 0600)
+	assert.Nil(t, errOpen)
+	_, _ = f.Write([]byte{1, 2, 3, 4, 5})
+	assert.Nil(t, f.Close())
+
+	db2, errReopen := openKVStore(dir, 0)
+	assert.Nil(t, errReopen)
+
+	_, errGet := db2.Get("a")
+	assert.ErrorIs(t, errGet, KeyNotFoundError)
+
+	value, errGetB := db2.Get("b")
+	assert.Nil(t, errGetB)
+	assert.Equal(t, []byte("second"), value)
+
+	assert.Nil(t, db2.Put("c", []byte("third")))
+	value, errGetC := db2.Get("c")
+	assert.Nil(t, errGetC)
+	assert.Equal(t, []byte("third"), value)
+	assert.Nil(t, db2.Close())
+}
@@ -6,11 +6,13 @@ import (
 )
 
 type Transaction struct {
+	ChainID   uint32
 	Data      []byte
 	From      crypto.PublicKey
 	To        types.Address
 	Value     uint64
 	Nonce     uint64
+	Fee       uint64
 	Signature *crypto.Signature
 	hash      types.Hash
 }
@@ -31,6 +33,7 @@ func NewTransaction(
 }
 
 func (tx *Transaction) Sign(key crypto.PrivateKey) error {
+	tx.From = key.PublicKey()
 	txHash := tx.Hash(TxHasher{})
 
 	sign, err := key.Sign(txHash[:])
@@ -40,7 +43,6 @@ func (tx *Transaction) Sign(key crypto.PrivateKey) error {
 	}
 
 	tx.Signature = sign
-	tx.From = key.PublicKey()
 
 	return nil
 }
@@ -63,6 +65,19 @@ func (tx *Transaction) Verify() bool {
 	return tx.Signature.VerifySignature(&tx.From, txHash[:])
 }
 
+// IsCoinbase reports whether the transaction mints from the zero account,
+// which is only allowed in the genesis block.
+func (tx *Transaction) IsCoinbase() bool {
+	zero := crypto.ZeroPublicKey().Address()
+
+	return tx.From.Address() == zero && tx.To == zero
+}
+
+// Size is the length of the canonical encoding of the signed transaction.
+func (tx *Transaction) Size() int {
+	return txEncodedBaseSize + crypto.SignatureLength + len(tx.Data)
+}
+
 func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 	if tx.hash.IsEmptyOrZero() {
 		return hasher.Hash(tx)
@@ -35,6 +35,18 @@ func TestTransaction_Verify(t *testing.T) {
 	tx.From = otherKey.PublicKey()
 
 	assert.False(t, tx.Verify())
+
+	tx.From = crypto.PublicKey{}
+
+	assert.False(t, tx.Verify())
+}
+
+func TestTransaction_SignChainID(t *testing.T) {
+	tx := RandomTxWithSignature(t)
+	assert.True(t, tx.Verify())
+
+	tx.ChainID++
+	assert.False(t, tx.Verify())
 }
 
 func TestTransaction_Decode(t *testing.T) {
@@ -50,14 +62,14 @@ func TestTransaction_Decode(t *testing.T) {
 }
 
 func RandomTxWithSignature(t *testing.T) *Transaction {
-	data := []byte("AMOUNT 5000 BTC")
 	privKey := crypto.GeneratePrivateKey()
-	sign, err := privKey.Sign(data)
-	assert.Nil(t, err)
 
-	return &Transaction{
-		Data:      data,
-		From:      privKey.PublicKey(),
-		Signature: sign,
+	tx := &Transaction{
+		ChainID: DefaultChainID,
+		Data:    []byte("AMOUNT 5000 BTC"),
 	}
+
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
 }
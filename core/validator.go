@@ -1,7 +1,9 @@
 package core
 
+import "fmt"
+
 type Validator interface {
-	ValidateBlock(*Block) bool
+	ValidateBlock(*Block) error
 }
 
 type BlockValidator struct {
@@ -14,25 +16,34 @@ func NewBlockValidator(blockchain *Blockchain) *BlockValidator {
 	}
 }
 
-func (bv *BlockValidator) ValidateBlock(b *Block) bool {
-	if bv.bc.HasBlock(b.Header.Height) {
-		return false
+func (bv *BlockValidator) ValidateBlock(b *Block) error {
+	if bv.bc.HasBlockHash(HeaderHasher{}.Hash(b.Header)) {
+		return BlockKnownError
 	}
 
-	if b.Header.Height != bv.bc.Height()+1 {
-		return false
+	parent, ok := bv.bc.getNode(b.Header.PrevBlockHash)
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ParentUnknownError, b.Header.PrevBlockHash)
 	}
 
-	prevHeader, err := bv.bc.GetHeader(b.Header.Height - 1)
-	hash := HeaderHasher{}.Hash(prevHeader)
+	if b.Header.Height != parent.height+1 {
+		return fmt.Errorf("%w: height %d does not follow parent height %d", InvalidBlockError, b.Header.Height, parent.height)
+	}
+
+	// an older version would skip checks the parent's version commits to,
+	// such as the state root
+	if b.Header.Version < parent.version || b.Header.Version > CurrentHeaderVersion {
+		return fmt.Errorf("%w: %w: %d after parent version %d", InvalidBlockError, UnsupportedHeaderVersionError, b.Header.Version, parent.version)
+	}
 
-	if hash != b.Header.PrevBlockHash {
-		return false
+	if b.Size() > MaxBlockSize {
+		return fmt.Errorf("%w: %w: %d bytes", InvalidBlockError, BlockTooLargeError, b.Size())
 	}
 
-	if err != nil {
-		return false
+	if !b.Verify() {
+		return fmt.Errorf("%w: verification failed", InvalidBlockError)
 	}
 
-	return b.Verify()
+	return nil
 }
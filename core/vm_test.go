@@ -61,5 +61,7 @@ func TestVM_Store(t *testing.T) {
 	data := []byte{8, 0x01, 105, 0x03, 116, 0x03, 32, 0x03, 119, 0x03, 111, 0x03, 114, 0x03, 107, 0x03, 115, 0x03, 0x04, 21, 0x01, 0x06}
 	vm := NewVM(data, NewState())
 	assert.Nil(t, vm.Run())
-	assert.Equal(t, deserializeInt64(vm.state.data["it works"]), int64(21))
+	value, err := vm.state.Get([]byte("it works"))
+	assert.Nil(t, err)
+	assert.Equal(t, deserializeInt64(value), int64(21))
 }
@@ -15,6 +15,18 @@ import (
 	"math/big"
 )
 
+const (
+	// PublicKeyLength is the size of a compressed P-256 public key.
+	PublicKeyLength = 33
+	// SignatureLength is the size of a signature as fixed-width r || s.
+	SignatureLength = 64
+)
+
+var (
+	InvalidPublicKeyError = errors.New("invalid public key")
+	InvalidSignatureError = errors.New("invalid signature")
+)
+
 type PrivateKey struct {
 	key *ecdsa.PrivateKey
 }
@@ -54,7 +66,7 @@ func (pk *PublicKey) GobEncode() ([]byte, error) {
 		return nil, nil
 	}
 
-	return elliptic.MarshalCompressed(pk.Key.Curve, pk.Key.X, pk.Key.Y), nil
+	return pk.CompressedBytes(), nil
 }
 
 func (pk *PublicKey) GobDecode(data []byte) error {
@@ -62,15 +74,52 @@ func (pk *PublicKey) GobDecode(data []byte) error {
 		pk.Key = nil
 		return nil
 	}
-	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), data)
-	pk.Key = &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
-		X:     x,
-		Y:     y,
+
+	decoded, err := PublicKeyFromCompressed(data)
+
+	if err != nil {
+		return err
 	}
+
+	pk.Key = decoded.Key
 	return nil
 }
 
+// CompressedBytes returns the SEC 1 compressed form of the key, or nil for an
+// empty key. The zero key used by coinbase transactions encodes as 0x02
+// followed by zeros.
+func (pk PublicKey) CompressedBytes() []byte {
+	if pk.Key == nil {
+		return nil
+	}
+
+	return elliptic.MarshalCompressed(elliptic.P256(), pk.Key.X, pk.Key.Y)
+}
+
+func PublicKeyFromCompressed(b []byte) (PublicKey, error) {
+	if len(b) != PublicKeyLength {
+		return PublicKey{}, InvalidPublicKeyError
+	}
+
+	if b[0] == 0x02 && new(big.Int).SetBytes(b[1:]).Sign() == 0 {
+		return ZeroPublicKey(), nil
+	}
+
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), b)
+
+	if x == nil {
+		return PublicKey{}, InvalidPublicKeyError
+	}
+
+	return PublicKey{
+		Key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     x,
+			Y:     y,
+		},
+	}, nil
+}
+
 func (pk PrivateKey) PublicKey() PublicKey {
 	return PublicKey{
 		Key: &pk.key.PublicKey,
@@ -124,7 +173,27 @@ func (s *Signature) GobEncode() ([]byte, error) {
 		return nil, nil
 	}
 
-	return append(s.r.Bytes(), s.s.Bytes()...), nil
+	return s.Bytes(), nil
+}
+
+// Bytes returns r and s as 32-byte big-endian integers.
+func (s *Signature) Bytes() []byte {
+	buf := make([]byte, SignatureLength)
+	s.r.FillBytes(buf[:SignatureLength/2])
+	s.s.FillBytes(buf[SignatureLength/2:])
+
+	return buf
+}
+
+func SignatureFromBytes(b []byte) (*Signature, error) {
+	if len(b) != SignatureLength {
+		return nil, InvalidSignatureError
+	}
+
+	return &Signature{
+		r: new(big.Int).SetBytes(b[:SignatureLength/2]),
+		s: new(big.Int).SetBytes(b[SignatureLength/2:]),
+	}, nil
 }
 
 func (s *Signature) GobDecode(data []byte) error {
@@ -138,8 +207,12 @@ func (s *Signature) GobDecode(data []byte) error {
 	return nil
 }
 
+// Sign signs the sha256 digest of data. ECDSA only uses as many bytes of its
+// input as the curve order has, so signing data directly would leave all but
+// its first 32 bytes unauthenticated.
 func (pk PrivateKey) Sign(data []byte) (*Signature, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, pk.key, data)
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, pk.key, digest[:])
 
 	if err != nil {
 		return nil, err
@@ -152,7 +225,13 @@ func (pk PrivateKey) Sign(data []byte) (*Signature, error) {
 }
 
 func (signature *Signature) VerifySignature(pk *PublicKey, data []byte) bool {
-	return ecdsa.Verify(pk.Key, data, signature.r, signature.s)
+	if signature == nil || pk == nil || pk.Key == nil {
+		return false
+	}
+
+	digest := sha256.Sum256(data)
+
+	return ecdsa.Verify(pk.Key, digest[:], signature.r, signature.s)
 }
 
 func ZeroPublicKey() PublicKey {
@@ -19,4 +19,38 @@ func TestKeypair_Sign_Verify(t *testing.T) {
 	assert.True(t, sign.VerifySignature(&publicKey, msg))
 	assert.False(t, sign.VerifySignature(&publicKey, []byte("Random data")))
 	assert.False(t, sign.VerifySignature(&randomPublicKey, msg))
+
+	// the signature covers the whole message, not just its first 32 bytes
+	tampered := append([]byte{}, msg...)
+	tampered[len(tampered)-1]++
+	assert.False(t, sign.VerifySignature(&publicKey, tampered))
+}
+
+func TestKeypair_CompressedBytes(t *testing.T) {
+	publicKey := GeneratePrivateKey().PublicKey()
+
+	decoded, err := PublicKeyFromCompressed(publicKey.CompressedBytes())
+	assert.Nil(t, err)
+	assert.Equal(t, publicKey.Address(), decoded.Address())
+
+	zero, errZero := PublicKeyFromCompressed(ZeroPublicKey().CompressedBytes())
+	assert.Nil(t, errZero)
+	assert.Equal(t, ZeroPublicKey().Address(), zero.Address())
+
+	_, errInvalid := PublicKeyFromCompressed(make([]byte, PublicKeyLength))
+	assert.ErrorIs(t, errInvalid, InvalidPublicKeyError)
+}
+
+func TestSignature_Bytes(t *testing.T) {
+	privateKey := GeneratePrivateKey()
+	publicKey := privateKey.PublicKey()
+	msg := []byte("Test message")
+
+	sign, err := privateKey.Sign(msg)
+	assert.Nil(t, err)
+	assert.Len(t, sign.Bytes(), SignatureLength)
+
+	decoded, errDecode := SignatureFromBytes(sign.Bytes())
+	assert.Nil(t, errDecode)
+	assert.True(t, decoded.VerifySignature(&publicKey, msg))
 }
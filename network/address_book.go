@@ -0,0 +1,289 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	addressBookFileName = "peers.json"
+	maxAddressBookSize  = 1000
+	maxAddressFailures  = 5
+	maxPeersPerMessage  = 100
+	// retryInterval is the minimum time between two dials of one address.
+	retryInterval = time.Minute
+)
+
+const (
+	AddressSourceSeed   = "seed"
+	AddressSourcePeer   = "peer"
+	AddressSourceGossip = "gossip"
+)
+
+type KnownAddress struct {
+	Address     string    `json:"address"`
+	ID          PeerID    `json:"id"`
+	Source      string    `json:"source"`
+	LastSeen    time.Time `json:"lastSeen"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	Failures    int       `json:"failures"`
+}
+
+// AddressBook keeps the addresses a node has learned about together with
+// connection metadata. It is persisted next to the chain data and never
+// touches the user's seed config; seed addresses are kept even when they fail.
+type AddressBook struct {
+	mu    sync.RWMutex
+	path  string
+	clock Clock
+	rand  *rand.Rand
+	addrs map[string]*KnownAddress
+}
+
+// NewAddressBook loads the book stored at path; an empty path keeps it in
+// memory only. Times come from clock and samples are drawn with rng.
+func NewAddressBook(path string, clock Clock, rng *rand.Rand) (*AddressBook, error) {
+	ab := &AddressBook{
+		path:  path,
+		clock: clock,
+		rand:  rng,
+		addrs: make(map[string]*KnownAddress),
+	}
+
+	if len(path) == 0 {
+		return ab, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return ab, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var known []*KnownAddress
+
+	if errUnmarshal := json.Unmarshal(data, &known); errUnmarshal != nil {
+		return nil, errUnmarshal
+	}
+
+	for _, ka := range known {
+		ab.addrs[ka.Address] = ka
+	}
+
+	return ab, nil
+}
+
+// Add records addr if it is a valid host:port not known yet.
+func (ab *AddressBook) Add(addr string, source string) bool {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return false
+	}
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	if ka, ok := ab.addrs[addr]; ok {
+		if source == AddressSourceSeed {
+			ka.Source = source
+		}
+
+		return false
+	}
+
+	if len(ab.addrs) >= maxAddressBookSize && !ab.evictNoLock() {
+		return false
+	}
+
+	ab.addrs[addr] = &KnownAddress{
+		Address: addr,
+		Source:  source,
+	}
+
+	return true
+}
+
+func (ab *AddressBook) Get(addr string) (KnownAddress, bool) {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	ka, ok := ab.addrs[addr]
+
+	if !ok {
+		return KnownAddress{}, false
+	}
+
+	return *ka, true
+}
+
+func (ab *AddressBook) Size() int {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	return len(ab.addrs)
+}
+
+func (ab *AddressBook) MarkAttempt(addr string) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	if ka, ok := ab.addrs[addr]; ok {
+		ka.LastAttempt = ab.clock.Now()
+	}
+}
+
+func (ab *AddressBook) MarkGood(addr string, id PeerID) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	if ka, ok := ab.addrs[addr]; ok {
+		ka.ID = id
+		ka.LastSeen = ab.clock.Now()
+		ka.Failures = 0
+	}
+}
+
+// MarkFailed counts a failed connection attempt and forgets non-seed
+// addresses that keep failing.
+func (ab *AddressBook) MarkFailed(addr string) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	ka, ok := ab.addrs[addr]
+
+	if !ok {
+		return
+	}
+
+	ka.Failures++
+
+	if ka.Failures >= maxAddressFailures && ka.Source != AddressSourceSeed {
+		delete(ab.addrs, addr)
+	}
+}
+
+// Candidates returns up to n addresses worth dialing, fewest failures and most
+// recently seen first, then in address order. Addresses tried within retryInterval or rejected by
+// skip are left out.
+func (ab *AddressBook) Candidates(n int, skip func(addr string) bool) []string {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	now := ab.clock.Now()
+	known := make([]*KnownAddress, 0, len(ab.addrs))
+
+	for _, ka := range ab.addrs {
+		if now.Sub(ka.LastAttempt) < retryInterval || skip(ka.Address) {
+			continue
+		}
+
+		known = append(known, ka)
+	}
+
+	sort.Slice(known, func(i, j int) bool {
+		if known[i].Failures != known[j].Failures {
+			return known[i].Failures < known[j].Failures
+		}
+
+		if !known[i].LastSeen.Equal(known[j].LastSeen) {
+			return known[i].LastSeen.After(known[j].LastSeen)
+		}
+
+		return known[i].Address < known[j].Address
+	})
+
+	result := make([]string, 0, n)
+
+	for i := 0; i < len(known) && len(result) < n; i++ {
+		result = append(result, known[i].Address)
+	}
+
+	return result
+}
+
+// Sample returns up to n random addresses, preferring ones that were
+// connected to successfully, for sharing with other peers.
+func (ab *AddressBook) Sample(n int) []string {
+	ab.mu.RLock()
+	defer ab.mu.RUnlock()
+
+	var good, untried []string
+
+	for addr, ka := range ab.addrs {
+		if ka.LastSeen.IsZero() {
+			untried = append(untried, addr)
+		} else {
+			good = append(good, addr)
+		}
+	}
+
+	// sorted first so the same rng gives the same sample
+	sort.Strings(good)
+	sort.Strings(untried)
+	ab.rand.Shuffle(len(good), func(i, j int) { good[i], good[j] = good[j], good[i] })
+	ab.rand.Shuffle(len(untried), func(i, j int) { untried[i], untried[j] = untried[j], untried[i] })
+
+	result := append(good, untried...)
+
+	if len(result) > n {
+		result = result[:n]
+	}
+
+	return result
+}
+
+func (ab *AddressBook) Save() error {
+	if len(ab.path) == 0 {
+		return nil
+	}
+
+	ab.mu.RLock()
+	known := make([]*KnownAddress, 0, len(ab.addrs))
+
+	for _, ka := range ab.addrs {
+		known = append(known, ka)
+	}
+
+	data, err := json.MarshalIndent(known, "", "  ")
+	ab.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(ab.path, data, 0644)
+}
+
+// evictNoLock drops the non-seed address with the most failures, oldest seen
+// first, to make room for a new one.
+func (ab *AddressBook) evictNoLock() bool {
+	var worst *KnownAddress
+
+	for _, ka := range ab.addrs {
+		if ka.Source == AddressSourceSeed {
+			continue
+		}
+
+		if worst == nil || ka.Failures > worst.Failures ||
+			ka.Failures == worst.Failures && ka.LastSeen.Before(worst.LastSeen) {
+			worst = ka
+		}
+	}
+
+	if worst == nil {
+		return false
+	}
+
+	delete(ab.addrs, worst.Address)
+
+	return true
+}
@@ -0,0 +1,83 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddressBook_Add(t *testing.T) {
+	ab, err := NewAddressBook("", realClock{}, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+
+	assert.True(t, ab.Add("10.0.0.1:3228", AddressSourceGossip))
+	assert.False(t, ab.Add("10.0.0.1:3228", AddressSourceGossip))
+	assert.False(t, ab.Add("not an address", AddressSourceGossip))
+	assert.Equal(t, 1, ab.Size())
+
+	ab.Add("10.0.0.1:3228", AddressSourceSeed)
+	ka, ok := ab.Get("10.0.0.1:3228")
+	assert.True(t, ok)
+	assert.Equal(t, AddressSourceSeed, ka.Source)
+}
+
+func TestAddressBook_MarkFailed(t *testing.T) {
+	ab, _ := NewAddressBook("", realClock{}, rand.New(rand.NewSource(1)))
+
+	ab.Add("10.0.0.1:3228", AddressSourceSeed)
+	ab.Add("10.0.0.2:3228", AddressSourceGossip)
+
+	for i := 0; i < maxAddressFailures; i++ {
+		ab.MarkFailed("10.0.0.1:3228")
+		ab.MarkFailed("10.0.0.2:3228")
+	}
+
+	seed, ok := ab.Get("10.0.0.1:3228")
+	assert.True(t, ok)
+	assert.Equal(t, maxAddressFailures, seed.Failures)
+
+	_, ok = ab.Get("10.0.0.2:3228")
+	assert.False(t, ok)
+}
+
+func TestAddressBook_Candidates(t *testing.T) {
+	ab, _ := NewAddressBook("", realClock{}, rand.New(rand.NewSource(1)))
+
+	ab.Add("10.0.0.1:3228", AddressSourceGossip)
+	ab.Add("10.0.0.2:3228", AddressSourceGossip)
+	ab.Add("10.0.0.3:3228", AddressSourceGossip)
+	ab.Add("10.0.0.4:3228", AddressSourceGossip)
+
+	ab.MarkFailed("10.0.0.1:3228")
+	ab.MarkGood("10.0.0.2:3228", PeerID{2})
+	ab.MarkAttempt("10.0.0.3:3228")
+
+	skip := func(addr string) bool {
+		return addr == "10.0.0.4:3228"
+	}
+
+	assert.Equal(t, []string{"10.0.0.2:3228", "10.0.0.1:3228"}, ab.Candidates(10, skip))
+	assert.Equal(t, []string{"10.0.0.2:3228"}, ab.Candidates(1, skip))
+}
+
+func TestAddressBook_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), addressBookFileName)
+
+	ab, err := NewAddressBook(path, realClock{}, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+
+	ab.Add("10.0.0.1:3228", AddressSourceSeed)
+	ab.Add("10.0.0.2:3228", AddressSourceGossip)
+	ab.MarkGood("10.0.0.2:3228", PeerID{2})
+	assert.Nil(t, ab.Save())
+
+	loaded, err := NewAddressBook(path, realClock{}, rand.New(rand.NewSource(1)))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, loaded.Size())
+
+	ka, ok := loaded.Get("10.0.0.2:3228")
+	assert.True(t, ok)
+	assert.Equal(t, PeerID{2}, ka.ID)
+	assert.False(t, ka.LastSeen.IsZero())
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const banListFileName = "bans.json"
+
+type Ban struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// BanList holds banned peer IPs and peer IDs until their ban expires. It is
+// persisted next to the chain data so bans survive restarts.
+type BanList struct {
+	mu    sync.RWMutex
+	path  string
+	clock Clock
+	bans  map[string]Ban
+}
+
+// NewBanList loads the list stored at path, dropping bans that expired by
+// the clock; an empty path keeps it in memory only.
+func NewBanList(path string, clock Clock) (*BanList, error) {
+	bl := &BanList{
+		path:  path,
+		clock: clock,
+		bans:  make(map[string]Ban),
+	}
+
+	if len(path) == 0 {
+		return bl, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return bl, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if errUnmarshal := json.Unmarshal(data, &bl.bans); errUnmarshal != nil {
+		return nil, errUnmarshal
+	}
+
+	bl.purgeNoLock()
+
+	return bl, nil
+}
+
+func (bl *BanList) Ban(key string, d time.Duration, reason string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	bl.bans[key] = Ban{
+		Until:  bl.clock.Now().Add(d),
+		Reason: reason,
+	}
+}
+
+func (bl *BanList) Unban(key string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	delete(bl.bans, key)
+}
+
+func (bl *BanList) IsBanned(key string) bool {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+
+	ban, ok := bl.bans[key]
+
+	return ok && bl.clock.Now().Before(ban.Until)
+}
+
+func (bl *BanList) Save() error {
+	if len(bl.path) == 0 {
+		return nil
+	}
+
+	bl.mu.Lock()
+	bl.purgeNoLock()
+	data, err := json.MarshalIndent(bl.bans, "", "  ")
+	bl.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(bl.path, data, 0644)
+}
+
+func (bl *BanList) purgeNoLock() {
+	now := bl.clock.Now()
+
+	for key, ban := range bl.bans {
+		if !now.Before(ban.Until) {
+			delete(bl.bans, key)
+		}
+	}
+}
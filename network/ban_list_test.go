@@ -0,0 +1,47 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanList_Ban(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	bl, err := NewBanList("", clock)
+	assert.Nil(t, err)
+
+	bl.Ban("10.0.0.1", time.Hour, "invalid block")
+	bl.Ban("10.0.0.2", -time.Second, "invalid block")
+
+	assert.True(t, bl.IsBanned("10.0.0.1"))
+	assert.False(t, bl.IsBanned("10.0.0.2"))
+	assert.False(t, bl.IsBanned("10.0.0.3"))
+
+	bl.Unban("10.0.0.1")
+	assert.False(t, bl.IsBanned("10.0.0.1"))
+
+	bl.Ban("10.0.0.3", time.Hour, "invalid block")
+	clock.Advance(time.Hour - time.Second)
+	assert.True(t, bl.IsBanned("10.0.0.3"))
+	clock.Advance(time.Second)
+	assert.False(t, bl.IsBanned("10.0.0.3"))
+}
+
+func TestBanList_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), banListFileName)
+
+	bl, err := NewBanList(path, realClock{})
+	assert.Nil(t, err)
+
+	bl.Ban("10.0.0.1", time.Hour, "invalid block")
+	bl.Ban("10.0.0.2", -time.Second, "invalid block")
+	assert.Nil(t, bl.Save())
+
+	loaded, err := NewBanList(path, realClock{})
+	assert.Nil(t, err)
+	assert.True(t, loaded.IsBanned("10.0.0.1"))
+	assert.Len(t, loaded.bans, 1)
+	assert.Equal(t, "invalid block", loaded.bans["10.0.0.1"].Reason)
+}
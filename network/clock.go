@@ -0,0 +1,341 @@
+package network
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of a Server's loops and timeouts, and runs the
+// work they start. The real clock is the default; a SimClock only moves when
+// advanced.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func())
+	// Go runs f on a new goroutine.
+	Go(f func())
+	// Hold and Release bracket work a goroutine does outside of Go and the
+	// clock's own callbacks. A goroutine releases before it blocks, and
+	// whoever wakes it holds for it first.
+	Hold()
+	Release()
+}
+
+// Ticker delivers ticks like time.Ticker. A receiver calls C each time it
+// waits for a tick.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+func (realClock) Go(f func()) {
+	go f()
+}
+
+func (realClock) Hold() {}
+
+func (realClock) Release() {}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+type simTimer struct {
+	at time.Time
+	// key orders timers due at the same time before their creation order.
+	key    string
+	seq    uint64
+	period time.Duration
+	f      func()
+	ticker *simTicker
+}
+
+type simTicker struct {
+	clock *SimClock
+	// waiting is the channel of a receiver waiting for the next tick.
+	waiting chan time.Time
+	held    bool
+	stopped bool
+}
+
+// SimClock is a virtual clock. Timers fire only from Advance, in deadline
+// order with ties by name and then creation order, and each one only once the work started
+// by the previous one is done: goroutines from Go, holds, and receivers of
+// ticks until they wait for the next one. Runs with the same inputs thus see
+// the same sequence of events.
+//
+// Code run on a SimClock must keep to the Hold and Release rules, or Advance
+// either waits forever or fires timers while work is still going on.
+type SimClock struct {
+	mu     sync.Mutex
+	idle   *sync.Cond
+	now    time.Time
+	seq    uint64
+	timers []*simTimer
+	busy   int
+}
+
+func NewSimClock(start time.Time) *SimClock {
+	c := &SimClock{now: start}
+	c.idle = sync.NewCond(&c.mu)
+
+	return c
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTicker returns a ticker whose receiver counts as busy until it first
+// calls C.
+func (c *SimClock) NewTicker(d time.Duration) Ticker {
+	return c.newTicker(d, "")
+}
+
+func (c *SimClock) AfterFunc(d time.Duration, f func()) {
+	c.schedule(&simTimer{f: f}, d, "")
+}
+
+// Go starts f once the work before it is done.
+func (c *SimClock) Go(f func()) {
+	c.goNamed(f, "")
+}
+
+// For returns the clock as seen by one node. Timers of different nodes due
+// at the same time fire in the order of their names, whichever was set
+// first.
+func (c *SimClock) For(name string) Clock {
+	return simClockView{SimClock: c, name: name}
+}
+
+func (c *SimClock) newTicker(d time.Duration, key string) Ticker {
+	t := &simTicker{clock: c}
+
+	c.mu.Lock()
+	t.held = true
+	c.busy++
+	c.mu.Unlock()
+
+	c.schedule(&simTimer{period: d, ticker: t}, d, key)
+
+	return t
+}
+
+func (c *SimClock) goNamed(f func(), key string) {
+	c.schedule(&simTimer{f: func() {
+		c.Hold()
+
+		go func() {
+			defer c.Release()
+			f()
+		}()
+	}}, 0, key)
+}
+
+func (c *SimClock) Hold() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.busy++
+}
+
+func (c *SimClock) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.releaseNoLock()
+}
+
+func (c *SimClock) releaseNoLock() {
+	c.busy--
+
+	if c.busy == 0 {
+		c.idle.Broadcast()
+	}
+}
+
+func (c *SimClock) waitNoLock() {
+	for c.busy > 0 {
+		c.idle.Wait()
+	}
+}
+
+func (c *SimClock) schedule(t *simTimer, d time.Duration, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	t.at = c.now.Add(d)
+	t.key = key
+	t.seq = c.seq
+	c.timers = append(c.timers, t)
+}
+
+// Advance moves the clock forward by d, firing every timer that falls due on
+// the way at its own deadline, and returns once the work they started is
+// done.
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+
+	for {
+		c.waitNoLock()
+		t := c.nextDueNoLock(target)
+
+		if t == nil {
+			break
+		}
+
+		at := t.at
+		c.now = at
+
+		if t.period > 0 {
+			c.seq++
+			t.seq = c.seq
+			t.at = at.Add(t.period)
+			c.timers = append(c.timers, t)
+		}
+
+		c.mu.Unlock()
+		t.fire(at)
+		c.mu.Lock()
+	}
+
+	c.now = target
+	c.mu.Unlock()
+}
+
+// nextDueNoLock removes and returns the earliest timer due by target.
+func (c *SimClock) nextDueNoLock(target time.Time) *simTimer {
+	sort.Slice(c.timers, func(i, j int) bool {
+		a, b := c.timers[i], c.timers[j]
+
+		if !a.at.Equal(b.at) {
+			return a.at.Before(b.at)
+		}
+
+		if a.key != b.key {
+			return a.key < b.key
+		}
+
+		return a.seq < b.seq
+	})
+
+	for len(c.timers) > 0 && c.timers[0].ticker != nil && c.timers[0].ticker.stopped {
+		c.timers = c.timers[1:]
+	}
+
+	if len(c.timers) == 0 || c.timers[0].at.After(target) {
+		return nil
+	}
+
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+
+	return t
+}
+
+func (t *simTimer) fire(at time.Time) {
+	if t.f != nil {
+		t.f()
+		return
+	}
+
+	t.ticker.tick(at)
+}
+
+// tick hands at to the waiting receiver, which is busy from then on. Ticks
+// nobody waits for are dropped.
+func (t *simTicker) tick(at time.Time) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.stopped || t.waiting == nil {
+		return
+	}
+
+	t.waiting <- at
+	t.waiting = nil
+	t.held = true
+	t.clock.busy++
+}
+
+// C ends the receiver's work on the previous tick.
+func (t *simTicker) C() <-chan time.Time {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.held {
+		t.held = false
+		t.clock.releaseNoLock()
+	}
+
+	t.waiting = make(chan time.Time, 1)
+
+	return t.waiting
+}
+
+func (t *simTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.stopped = true
+	t.waiting = nil
+
+	if t.held {
+		t.held = false
+		t.clock.releaseNoLock()
+	}
+}
+
+type simClockView struct {
+	*SimClock
+	name string
+}
+
+func (v simClockView) NewTicker(d time.Duration) Ticker {
+	return v.newTicker(d, v.name)
+}
+
+func (v simClockView) AfterFunc(d time.Duration, f func()) {
+	v.schedule(&simTimer{f: f}, d, v.name)
+}
+
+func (v simClockView) Go(f func()) {
+	v.goNamed(f, v.name)
+}
+
+// sleep blocks for d on clock.
+func sleep(clock Clock, d time.Duration) {
+	done := make(chan struct{})
+
+	clock.AfterFunc(d, func() {
+		clock.Hold()
+		close(done)
+	})
+
+	clock.Release()
+	<-done
+}
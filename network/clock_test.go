@@ -0,0 +1,74 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSimClock_Advance(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewSimClock(start)
+
+	var fired []string
+
+	clock.For("b").AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "a") })
+	clock.For("b").AfterFunc(2*time.Second, func() { fired = append(fired, "c") })
+	clock.For("a").AfterFunc(2*time.Second, func() { fired = append(fired, "d") })
+
+	clock.Advance(1500 * time.Millisecond)
+	assert.Equal(t, []string{"a"}, fired)
+	assert.Equal(t, start.Add(1500*time.Millisecond), clock.Now())
+
+	// ties go by name, then by creation order
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, []string{"a", "d", "b", "c"}, fired)
+}
+
+func TestSimClock_Ticker(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewSimClock(start)
+	ticker := clock.NewTicker(time.Second)
+	done := make(chan struct{})
+
+	var ticks []time.Time
+
+	go func() {
+		for {
+			select {
+			case at := <-ticker.C():
+				ticks = append(ticks, at)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	clock.Advance(2500 * time.Millisecond)
+	assert.Equal(t, []time.Time{start.Add(time.Second), start.Add(2 * time.Second)}, ticks)
+
+	close(done)
+	clock.Advance(time.Minute)
+	assert.Len(t, ticks, 2)
+}
+
+func TestSimClock_Go(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+
+	var order []string
+
+	clock.Go(func() {
+		order = append(order, "go")
+		sleep(clock, time.Second)
+		order = append(order, "slept")
+	})
+
+	clock.AfterFunc(0, func() { order = append(order, "now") })
+	clock.AfterFunc(time.Second, func() { order = append(order, "timer") })
+
+	// every timer waits for the goroutine to block, and Advance for it to end
+	clock.Advance(time.Second)
+	assert.Equal(t, []string{"go", "now", "timer", "slept"}, order)
+}
@@ -0,0 +1,268 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/types"
+	"net"
+	"time"
+)
+
+// maxPartialBlocks bounds the compact blocks waiting for missing
+// transactions.
+const maxPartialBlocks = 16
+
+var InvalidTxIndexError = errors.New("transaction index out of range")
+
+// shortTxID is the first six bytes of sha256(blockHash || txHash). Keying it
+// by the block means a colliding pair of transactions only collides in one
+// block.
+func shortTxID(blockHash types.Hash, txHash types.Hash) ShortTxID {
+	sum := sha256.Sum256(append(blockHash[:], txHash[:]...))
+
+	var id ShortTxID
+	copy(id[:], sum[:])
+
+	return id
+}
+
+func newCompactBlock(b *core.Block) *CompactBlockMessage {
+	hash := b.Hash(core.HeaderHasher{})
+	ids := make([]ShortTxID, len(b.Transactions))
+
+	for i, tx := range b.Transactions {
+		ids[i] = shortTxID(hash, tx.Hash(core.TxHasher{}))
+	}
+
+	return &CompactBlockMessage{
+		Header:   b.SignedHeader(),
+		ShortIDs: ids,
+	}
+}
+
+// partialBlock is a compact block waiting for the transactions that were not
+// in the mempool.
+type partialBlock struct {
+	from    net.Addr
+	header  *core.SignedHeader
+	txs     []*core.Transaction
+	missing []uint32
+	expires time.Time
+}
+
+// relayBlock sends b as a compact block to every peer not known to have it.
+func (s *Server) relayBlock(b *core.Block) {
+	buf := &bytes.Buffer{}
+
+	if err := newCompactBlock(b).Encode(buf); err != nil {
+		_ = s.so.Logger.Log("error", "encode compact block failed", "err", err)
+		return
+	}
+
+	data, err := NewMessage(MessageTypeCompactBlock, buf.Bytes()).Bytes()
+
+	if err != nil {
+		_ = s.so.Logger.Log("error", "encode compact block failed", "err", err)
+		return
+	}
+
+	for _, peer := range s.peersLacking(b.Hash(core.HeaderHasher{})) {
+		if errSend := peer.Send(data); errSend != nil {
+			_ = s.so.Logger.Log("error", "relay block failed", "addr", peer.conn.RemoteAddr().String(), "err", errSend)
+		}
+	}
+}
+
+// matchShortIDs fills in the transactions of a compact block from the
+// mempool and returns the indexes of those it could not find. A short ID that
+// matches several pooled transactions counts as missing.
+func (s *Server) matchShortIDs(blockHash types.Hash, ids []ShortTxID) ([]*core.Transaction, []uint32) {
+	byID := make(map[ShortTxID]*core.Transaction)
+	ambiguous := make(map[ShortTxID]bool)
+
+	for txHash, tx := range s.memPool.All() {
+		id := shortTxID(blockHash, txHash)
+
+		if _, ok := byID[id]; ok {
+			ambiguous[id] = true
+		}
+
+		byID[id] = tx
+	}
+
+	txs := make([]*core.Transaction, len(ids))
+	var missing []uint32
+
+	for i, id := range ids {
+		if tx, ok := byID[id]; ok && !ambiguous[id] {
+			txs[i] = tx
+			continue
+		}
+
+		missing = append(missing, uint32(i))
+	}
+
+	return txs, missing
+}
+
+func (s *Server) processCompactBlockMessage(from net.Addr, m *CompactBlockMessage) error {
+	hash := core.HeaderHasher{}.Hash(m.Header.Header)
+	s.received(from, hash)
+
+	if s.seen.Contains(hash) || s.chain.HasBlockHash(hash) {
+		s.gossip.duplicateBlocks.Add(1)
+		return nil
+	}
+
+	if !m.Header.Verify() {
+		return fmt.Errorf("%w: bad signature on compact block %s", InvalidHeaderError, hash)
+	}
+
+	txs, missing := s.matchShortIDs(hash, m.ShortIDs)
+
+	if len(missing) == 0 {
+		return s.completeBlock(from, m.Header, txs)
+	}
+
+	s.mu.Lock()
+	s.addPartialNoLock(hash, &partialBlock{
+		from:    from,
+		header:  m.Header,
+		txs:     txs,
+		missing: missing,
+		expires: s.clock.Now().Add(getDataTimeout),
+	})
+	s.mu.Unlock()
+
+	peer, err := s.peerAt(from)
+
+	if err != nil {
+		return err
+	}
+
+	return s.sendMessage(peer, MessageTypeGetBlockTxn, &GetBlockTxnMessage{BlockHash: hash, Indexes: missing})
+}
+
+// addPartialNoLock keeps pb until its transactions arrive, dropping expired
+// partial blocks and, when full, the one closest to expiring.
+func (s *Server) addPartialNoLock(hash types.Hash, pb *partialBlock) {
+	now := s.clock.Now()
+	var oldest types.Hash
+
+	for h, other := range s.partials {
+		if !now.Before(other.expires) {
+			delete(s.partials, h)
+			continue
+		}
+
+		if oldest.IsEmptyOrZero() || other.expires.Before(s.partials[oldest].expires) {
+			oldest = h
+		}
+	}
+
+	if len(s.partials) >= maxPartialBlocks {
+		delete(s.partials, oldest)
+	}
+
+	s.partials[hash] = pb
+}
+
+// completeBlock assembles a compact block once all its transactions are
+// known. If they do not match the header, which a short ID collision can
+// cause, the full block is fetched instead.
+func (s *Server) completeBlock(from net.Addr, header *core.SignedHeader, txs []*core.Transaction) error {
+	b := core.NewBlock(header.Header, txs)
+	b.Validator = header.Validator
+	b.Signature = header.Signature
+
+	dataHash, err := b.CalculateDataHash(txs)
+
+	if err != nil || dataHash != header.Header.DataHash {
+		hash := b.Hash(core.HeaderHasher{})
+		_ = s.so.Logger.Log("msg", "compact block reconstruction failed", "hash", hash, "addr", from.String())
+
+		return s.requestBlock(from, hash)
+	}
+
+	return s.processBlock(from, b)
+}
+
+func (s *Server) requestBlock(from net.Addr, hash types.Hash) error {
+	peer, err := s.peerAt(from)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.inflight[hash] = s.clock.Now().Add(getDataTimeout)
+	s.mu.Unlock()
+
+	return s.sendMessage(peer, MessageTypeGetData, &GetDataMessage{Items: []InvItem{{Type: InvTypeBlock, Hash: hash}}})
+}
+
+func (s *Server) processGetBlockTxnMessage(from net.Addr, m *GetBlockTxnMessage) error {
+	b, err := s.chain.GetBlockByHash(m.BlockHash)
+
+	if err != nil {
+		return nil
+	}
+
+	txs := make([]*core.Transaction, len(m.Indexes))
+
+	for i, index := range m.Indexes {
+		if index >= uint32(len(b.Transactions)) {
+			return fmt.Errorf("%w: %d of %d", InvalidTxIndexError, index, len(b.Transactions))
+		}
+
+		txs[i] = b.Transactions[index]
+	}
+
+	buf := &bytes.Buffer{}
+
+	if errEncode := (&BlockTxnMessage{BlockHash: m.BlockHash, Transactions: txs}).Encode(buf); errEncode != nil {
+		return errEncode
+	}
+
+	data, errBytes := NewMessage(MessageTypeBlockTxn, buf.Bytes()).Bytes()
+
+	if errBytes != nil {
+		return errBytes
+	}
+
+	peer, errPeer := s.peerAt(from)
+
+	if errPeer != nil {
+		return errPeer
+	}
+
+	return peer.Send(data)
+}
+
+func (s *Server) processBlockTxnMessage(from net.Addr, m *BlockTxnMessage) error {
+	s.mu.Lock()
+	pb, ok := s.partials[m.BlockHash]
+
+	if ok && pb.from.String() == from.String() {
+		delete(s.partials, m.BlockHash)
+	}
+
+	s.mu.Unlock()
+
+	if !ok || pb.from.String() != from.String() {
+		return fmt.Errorf("%w: transactions of block %s", UnsolicitedMessageError, m.BlockHash)
+	}
+
+	if len(m.Transactions) != len(pb.missing) {
+		return fmt.Errorf("%w: %d transactions for %d missing", UnsolicitedMessageError, len(m.Transactions), len(pb.missing))
+	}
+
+	for i, index := range pb.missing {
+		pb.txs[index] = m.Transactions[i]
+	}
+
+	return s.completeBlock(from, pb.header, pb.txs)
+}
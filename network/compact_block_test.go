@@ -0,0 +1,110 @@
+package network
+
+import (
+	"bytes"
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testCompactBlock(t *testing.T, s *Server, txs []*core.Transaction) *core.Block {
+	b, err := s.chain.NewBlockTemplate(txs, s.so.PrivateKey.PublicKey())
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(*s.so.PrivateKey))
+	assert.Len(t, b.Transactions, len(txs))
+
+	return b
+}
+
+func TestCompactBlockMessage_EncodeDecode(t *testing.T) {
+	s := newTestServer(t)
+	b := testCompactBlock(t, s, []*core.Transaction{
+		signedTx(t, crypto.GeneratePrivateKey(), 0, 0),
+		signedTx(t, crypto.GeneratePrivateKey(), 0, 0),
+	})
+
+	compact := newCompactBlock(b)
+	buf := &bytes.Buffer{}
+	assert.Nil(t, compact.Encode(buf))
+
+	decoded := &CompactBlockMessage{}
+	assert.Nil(t, decoded.Decode(buf))
+	assert.Equal(t, compact.ShortIDs, decoded.ShortIDs)
+	assert.Equal(t, b.Hash(core.HeaderHasher{}), core.HeaderHasher{}.Hash(decoded.Header.Header))
+	assert.True(t, decoded.Header.Verify())
+
+	txn := &BlockTxnMessage{BlockHash: b.Hash(core.HeaderHasher{}), Transactions: b.Transactions}
+	buf.Reset()
+	assert.Nil(t, txn.Encode(buf))
+
+	decodedTxn := &BlockTxnMessage{}
+	assert.Nil(t, decodedTxn.Decode(buf))
+	assert.Equal(t, txn.BlockHash, decodedTxn.BlockHash)
+	assert.Len(t, decodedTxn.Transactions, 2)
+}
+
+func TestServer_processCompactBlockMessage(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	// the block reward pays the fees on both chains
+	funding := testCompactBlock(t, b, nil)
+	assert.Nil(t, b.processBlock(nil, funding))
+	assert.Nil(t, a.processBlock(nil, funding))
+
+	pooled := signedTx(t, *b.so.PrivateKey, 0, 1)
+	unknown := signedTx(t, *b.so.PrivateKey, 1, 1)
+	assert.Nil(t, a.memPool.Add(pooled))
+
+	block := testCompactBlock(t, b, []*core.Transaction{pooled, unknown})
+	hash := block.Hash(core.HeaderHasher{})
+
+	assert.Nil(t, a.processCompactBlockMessage(conn.RemoteAddr(), newCompactBlock(block)))
+	assert.Equal(t, []uint32{1}, a.partials[hash].missing)
+	assert.Equal(t, uint32(1), a.chain.Height())
+
+	txn := &BlockTxnMessage{BlockHash: hash, Transactions: []*core.Transaction{unknown}}
+	assert.Nil(t, a.processBlockTxnMessage(conn.RemoteAddr(), txn))
+	assert.True(t, a.chain.HasBlockHash(hash))
+	assert.False(t, a.memPool.Contains(pooled.Hash(core.TxHasher{})))
+
+	assert.ErrorIs(t, a.processBlockTxnMessage(conn.RemoteAddr(), txn), UnsolicitedMessageError)
+}
+
+func TestServer_processBlockTxnMessageMismatch(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	block := testCompactBlock(t, b, []*core.Transaction{signedTx(t, crypto.GeneratePrivateKey(), 0, 0)})
+	hash := block.Hash(core.HeaderHasher{})
+
+	assert.Nil(t, a.processCompactBlockMessage(conn.RemoteAddr(), newCompactBlock(block)))
+
+	// transactions that do not match the header make us fetch the full block
+	wrong := &BlockTxnMessage{BlockHash: hash, Transactions: []*core.Transaction{signedTx(t, crypto.GeneratePrivateKey(), 0, 0)}}
+	assert.Nil(t, a.processBlockTxnMessage(conn.RemoteAddr(), wrong))
+	assert.False(t, a.chain.HasBlockHash(hash))
+	assert.Contains(t, a.inflight, hash)
+}
+
+func TestServer_processGetBlockTxnMessage(t *testing.T) {
+	a := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	block := testCompactBlock(t, a, []*core.Transaction{signedTx(t, crypto.GeneratePrivateKey(), 0, 0)})
+	assert.Nil(t, a.chain.AddBlock(block))
+
+	hash := block.Hash(core.HeaderHasher{})
+
+	assert.Nil(t, a.processGetBlockTxnMessage(conn.RemoteAddr(), &GetBlockTxnMessage{BlockHash: hash, Indexes: []uint32{0}}))
+	assert.ErrorIs(t, a.processGetBlockTxnMessage(conn.RemoteAddr(), &GetBlockTxnMessage{BlockHash: hash, Indexes: []uint32{1}}), InvalidTxIndexError)
+}
@@ -0,0 +1,160 @@
+package network
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	minReconnectDelay      = time.Second
+	maxReconnectDelay      = 10 * time.Minute
+	reconnectCheckInterval = time.Second
+)
+
+type persistentPeer struct {
+	attempts  int
+	nextDial  time.Time
+	dialing   bool
+	connected bool
+}
+
+// ConnManager tracks the peers the node always wants to be connected to, the
+// seeds and the configured persistent peers, and schedules redials of the
+// ones that dropped with exponential backoff.
+type ConnManager struct {
+	mu    sync.Mutex
+	rand  *rand.Rand
+	peers map[string]*persistentPeer
+}
+
+// NewConnManager tracks addrs, jittering their redials with rng.
+func NewConnManager(addrs []string, rng *rand.Rand) *ConnManager {
+	cm := &ConnManager{
+		rand:  rng,
+		peers: make(map[string]*persistentPeer),
+	}
+
+	for _, addr := range addrs {
+		cm.Add(addr)
+	}
+
+	return cm
+}
+
+func (cm *ConnManager) Add(addr string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, ok := cm.peers[addr]; !ok {
+		cm.peers[addr] = &persistentPeer{}
+	}
+}
+
+func (cm *ConnManager) Contains(addr string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	_, ok := cm.peers[addr]
+
+	return ok
+}
+
+// Due returns the peers whose redial time has come, in address order, and
+// marks them as being dialed.
+func (cm *ConnManager) Due(now time.Time) []string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var due []string
+
+	for addr, p := range cm.peers {
+		if p.dialing || p.connected || now.Before(p.nextDial) {
+			continue
+		}
+
+		p.dialing = true
+		due = append(due, addr)
+	}
+
+	sort.Strings(due)
+
+	return due
+}
+
+// Connected records an open connection to addr.
+func (cm *ConnManager) Connected(addr string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if p, ok := cm.peers[addr]; ok {
+		p.dialing = false
+		p.connected = true
+	}
+}
+
+// Handshaked records that the connection to addr is usable, which resets its
+// backoff.
+func (cm *ConnManager) Handshaked(addr string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if p, ok := cm.peers[addr]; ok {
+		p.dialing = false
+		p.connected = true
+		p.attempts = 0
+	}
+}
+
+// Disconnected schedules a redial of addr after a failed dial or a dropped
+// connection. Each failure without a successful handshake in between doubles
+// the delay.
+func (cm *ConnManager) Disconnected(addr string, now time.Time) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	p, ok := cm.peers[addr]
+
+	if !ok || !p.dialing && !p.connected {
+		return
+	}
+
+	p.dialing = false
+	p.connected = false
+	p.nextDial = now.Add(reconnectDelay(p.attempts, cm.rand))
+	p.attempts++
+}
+
+// reconnectDelay is the backoff after attempts failures: it doubles from
+// minReconnectDelay up to maxReconnectDelay, with the upper half jittered so
+// peers that dropped together do not redial together.
+func reconnectDelay(attempts int, rng *rand.Rand) time.Duration {
+	delay := maxReconnectDelay
+
+	if attempts < 32 {
+		delay = min(minReconnectDelay<<attempts, maxReconnectDelay)
+	}
+
+	half := delay / 2
+
+	return half + time.Duration(rng.Int63n(int64(half)+1))
+}
+
+// reconnectLoop dials the seeds and persistent peers at start and redials
+// them whenever their backoff runs out.
+func (s *Server) reconnectLoop(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		for _, addr := range s.connManager.Due(s.clock.Now()) {
+			s.clock.Go(func() { s.connect(addr, true) })
+		}
+
+		select {
+		case <-ticker.C():
+		case <-s.quitChannel:
+			return
+		}
+	}
+}
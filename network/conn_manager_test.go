@@ -0,0 +1,77 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for attempts := 0; attempts < 100; attempts++ {
+		want := min(minReconnectDelay<<min(attempts, 31), maxReconnectDelay)
+		delay := reconnectDelay(attempts, rng)
+
+		assert.GreaterOrEqual(t, delay, want/2)
+		assert.LessOrEqual(t, delay, want)
+	}
+}
+
+func TestConnManager_Due(t *testing.T) {
+	cm := NewConnManager([]string{"10.0.0.1:3228", "10.0.0.2:3228"}, rand.New(rand.NewSource(1)))
+	now := time.Now()
+
+	assert.Equal(t, []string{"10.0.0.1:3228", "10.0.0.2:3228"}, cm.Due(now))
+	assert.Empty(t, cm.Due(now))
+
+	cm.Connected("10.0.0.1:3228")
+	cm.Disconnected("10.0.0.2:3228", now)
+
+	assert.Empty(t, cm.Due(now))
+	assert.Equal(t, []string{"10.0.0.2:3228"}, cm.Due(now.Add(minReconnectDelay)))
+
+	cm.Disconnected("10.0.0.2:3228", now)
+	assert.Empty(t, cm.Due(now.Add(minReconnectDelay)))
+	assert.Equal(t, []string{"10.0.0.2:3228"}, cm.Due(now.Add(2*minReconnectDelay)))
+
+	cm.Disconnected("10.0.0.1:3228", now)
+	assert.Equal(t, []string{"10.0.0.1:3228"}, cm.Due(now.Add(minReconnectDelay)))
+}
+
+func TestConnManager_HandshakedResetsBackoff(t *testing.T) {
+	cm := NewConnManager([]string{"10.0.0.1:3228"}, rand.New(rand.NewSource(1)))
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		cm.Due(now.Add(maxReconnectDelay))
+		cm.Disconnected("10.0.0.1:3228", now)
+	}
+
+	assert.Empty(t, cm.Due(now.Add(time.Minute)))
+
+	cm.Handshaked("10.0.0.1:3228")
+	cm.Disconnected("10.0.0.1:3228", now)
+
+	assert.Equal(t, []string{"10.0.0.1:3228"}, cm.Due(now.Add(minReconnectDelay)))
+
+	cm.Disconnected("10.0.0.9:3228", now)
+	assert.False(t, cm.Contains("10.0.0.9:3228"))
+}
+
+func TestServer_removePersistentPeer(t *testing.T) {
+	s := newTestServer(t)
+	s.connManager.Add("10.0.0.1:3228")
+
+	conn := pipeConn(t, 1)
+	s.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn, dialAddr: "10.0.0.1:3228", Outgoing: true}}
+
+	assert.Equal(t, []string{"10.0.0.1:3228"}, s.connManager.Due(time.Now()))
+	s.connManager.Connected("10.0.0.1:3228")
+
+	s.removePeer(conn.RemoteAddr())
+
+	assert.Empty(t, s.connManager.Due(time.Now()))
+	assert.Equal(t, []string{"10.0.0.1:3228"}, s.connManager.Due(time.Now().Add(minReconnectDelay)))
+}
@@ -0,0 +1,408 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	defaultTargetOutboundPeers = 8
+	discoveryInterval          = 30 * time.Second
+	dhtCheckInterval           = time.Minute
+	findNodeTimeout            = 10 * time.Second
+	handshakePollInterval      = 50 * time.Millisecond
+)
+
+var RequestTimeoutError = errors.New("request timed out")
+
+// discoveryLoop keeps the node at TargetOutboundPeers outbound connections,
+// dialing addresses from the address book and asking peers for more when it
+// runs out of candidates.
+func (s *Server) discoveryLoop(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		s.discoverPeers()
+
+		if err := s.addrBook.Save(); err != nil {
+			_ = s.so.Logger.Log("error", "save address book failed", "err", err)
+		}
+
+		select {
+		case <-ticker.C():
+		case <-s.quitChannel:
+			return
+		}
+	}
+}
+
+func (s *Server) discoverPeers() {
+	missing := s.so.TargetOutboundPeers - s.outboundCount()
+
+	if missing <= 0 {
+		return
+	}
+
+	candidates := s.addrBook.Candidates(missing, func(addr string) bool {
+		return s.isConnectedOrDialing(addr) || s.isBanned(addr, PeerID{})
+	})
+
+	for _, addr := range candidates {
+		s.clock.Go(func() { s.connect(addr, false) })
+	}
+
+	if len(candidates) < missing {
+		s.requestPeers()
+	}
+}
+
+// connect dials addr and hands the peer to the server loop. Failures are
+// recorded in the address book and the connection manager. Persistent peers
+// are dialed even when all outbound slots are taken.
+func (s *Server) connect(addr string, persistent bool) {
+	if s.isBanned(addr, PeerID{}) {
+		s.connManager.Disconnected(addr, s.clock.Now())
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.dialing[addr] || !persistent && s.countNoLock(true)+len(s.dialing) >= s.so.MaxOutboundPeers {
+		s.mu.Unlock()
+		return
+	}
+
+	s.dialing[addr] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.dialing, addr)
+		s.mu.Unlock()
+	}()
+
+	s.addrBook.MarkAttempt(addr)
+
+	// an address we connected to before must be answered by the same key
+	known, _ := s.addrBook.Get(addr)
+	peer, err := s.Transport.Dial(addr, known.ID)
+
+	if err != nil {
+		_ = s.so.Logger.Log("msg", "dial failed", "addr", addr, "err", err)
+		s.addrBook.MarkFailed(addr)
+		s.connManager.Disconnected(addr, s.clock.Now())
+		return
+	}
+
+	peer.dialAddr = addr
+	s.connManager.Connected(addr)
+
+	s.clock.Hold()
+	s.peerCh <- peer
+}
+
+func (s *Server) outboundCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.countNoLock(true)
+}
+
+func (s *Server) isConnectedOrDialing(addr string) bool {
+	if addr == s.so.ListenAddress {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.dialing[addr] {
+		return true
+	}
+
+	for remote, peerInfo := range s.peerMap {
+		if peerInfo.ListenAddress == addr || remote.String() == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestPeers asks a random handshaked peer for addresses.
+func (s *Server) requestPeers() {
+	s.mu.RLock()
+	peers := make([]*TCPPeer, 0, len(s.peerMap))
+
+	for _, peerInfo := range s.peerMap {
+		if peerInfo.Handshaked {
+			peers = append(peers, peerInfo.Peer)
+		}
+	}
+
+	s.mu.RUnlock()
+
+	if len(peers) == 0 {
+		return
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].conn.RemoteAddr().String() < peers[j].conn.RemoteAddr().String()
+	})
+
+	peer := peers[s.rand.Intn(len(peers))]
+
+	if err := s.sendMessage(peer, MessageTypeGetPeers, &GetPeersMessage{}); err != nil {
+		_ = s.so.Logger.Log("error", "send get peers failed", "err", err)
+	}
+}
+
+func (s *Server) processGetPeersMessage(from net.Addr) error {
+	s.mu.RLock()
+	peerInfo, ok := s.peerMap[from]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	addresses := make([]string, 0, maxPeersPerMessage)
+
+	for _, addr := range s.addrBook.Sample(maxPeersPerMessage + 1) {
+		if addr != peerInfo.ListenAddress && len(addresses) < maxPeersPerMessage {
+			addresses = append(addresses, addr)
+		}
+	}
+
+	return s.sendMessage(peerInfo.Peer, MessageTypePeers, &PeersMessage{
+		Addresses: addresses,
+	})
+}
+
+func (s *Server) processPeersMessage(from net.Addr, m *PeersMessage) error {
+	added := 0
+
+	for i, addr := range m.Addresses {
+		if i >= maxPeersPerMessage {
+			break
+		}
+
+		if addr != s.so.ListenAddress && s.addrBook.Add(addr, AddressSourceGossip) {
+			added++
+		}
+	}
+
+	_ = s.so.Logger.Log("msg", "received peer addresses", "from", from.String(), "count", len(m.Addresses), "new", added)
+
+	return nil
+}
+
+// dhtLoop bootstraps the DHT once the first peer is known and then keeps its
+// buckets fresh.
+func (s *Server) dhtLoop(ticker Ticker) {
+	defer ticker.Stop()
+
+	bootstrapped := false
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-s.quitChannel:
+			return
+		}
+
+		if s.dht.Table().Size() == 0 {
+			continue
+		}
+
+		if !bootstrapped {
+			s.dht.Bootstrap(nil)
+			bootstrapped = true
+			continue
+		}
+
+		s.dht.Refresh(bucketRefreshInterval)
+	}
+}
+
+type findNodeRequest struct {
+	addr  net.Addr
+	nodes chan []Contact
+}
+
+// findNode sends a FIND_NODE to c, connecting to it first if needed, and
+// waits for the answer. A connection opened for the lookup is closed again if
+// it takes the node over MaxOutboundPeers.
+func (s *Server) findNode(c Contact, target PeerID) ([]Contact, error) {
+	peer, dialed, err := s.peerFor(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dialed {
+		defer func() {
+			if s.outboundCount() > s.so.MaxOutboundPeers {
+				s.removePeer(peer.conn.RemoteAddr())
+			}
+		}()
+	}
+
+	req := &findNodeRequest{
+		addr:  peer.conn.RemoteAddr(),
+		nodes: make(chan []Contact, 1),
+	}
+
+	s.mu.Lock()
+	s.nextRequest++
+	id := s.nextRequest
+	s.requests[id] = req
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.requests, id)
+		s.mu.Unlock()
+	}()
+
+	if errSend := s.sendMessage(peer, MessageTypeFindNode, &FindNodeMessage{RequestID: id, Target: target}); errSend != nil {
+		return nil, errSend
+	}
+
+	s.clock.AfterFunc(findNodeTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.requests[id]; ok {
+			delete(s.requests, id)
+			s.clock.Hold()
+			close(req.nodes)
+		}
+	})
+
+	s.clock.Release()
+	nodes, ok := <-req.nodes
+
+	if !ok {
+		return nil, fmt.Errorf("%w: find node %s", RequestTimeoutError, c.ID)
+	}
+
+	for _, node := range nodes {
+		if node.ID != s.dht.self.ID {
+			s.addrBook.Add(node.Address, AddressSourceGossip)
+		}
+	}
+
+	return nodes, nil
+}
+
+// pingContact checks that c is alive and holds its key. A handshaked
+// connection is proof enough; otherwise a TLS connection is opened and closed.
+func (s *Server) pingContact(c Contact) error {
+	if s.handshakedPeer(c.ID) != nil {
+		return nil
+	}
+
+	peer, err := s.Transport.Dial(c.Address, c.ID)
+
+	if err != nil {
+		return err
+	}
+
+	_ = peer.conn.Close()
+
+	return nil
+}
+
+// peerFor returns a handshaked connection to c, dialing it and waiting for
+// the handshake if there is none.
+func (s *Server) peerFor(c Contact) (*TCPPeer, bool, error) {
+	if peer := s.handshakedPeer(c.ID); peer != nil {
+		return peer, false, nil
+	}
+
+	peer, err := s.Transport.Dial(c.Address, c.ID)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.clock.Hold()
+
+	select {
+	case s.peerCh <- peer:
+	case <-s.quitChannel:
+		s.clock.Release()
+		_ = peer.conn.Close()
+		return nil, false, net.ErrClosed
+	}
+
+	deadline := s.clock.Now().Add(handshakeTimeout)
+
+	for s.clock.Now().Before(deadline) {
+		if s.isHandshaked(peer.conn.RemoteAddr()) {
+			return peer, true, nil
+		}
+
+		sleep(s.clock, handshakePollInterval)
+	}
+
+	return nil, false, fmt.Errorf("%w: handshake with %s", RequestTimeoutError, c.Address)
+}
+
+func (s *Server) handshakedPeer(id PeerID) *TCPPeer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, peerInfo := range s.peerMap {
+		if peerInfo.Handshaked && peerInfo.Peer.ID == id {
+			return peerInfo.Peer
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) processFindNodeMessage(from net.Addr, m *FindNodeMessage) error {
+	s.mu.RLock()
+	peerInfo, ok := s.peerMap[from]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	return s.sendMessage(peerInfo.Peer, MessageTypeNodes, &NodesMessage{
+		RequestID: m.RequestID,
+		Nodes:     s.dht.Closest(m.Target),
+	})
+}
+
+// processNodesMessage hands a FIND_NODE answer to the request waiting for it.
+// Answers nobody asked this peer for are dropped.
+func (s *Server) processNodesMessage(from net.Addr, m *NodesMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[m.RequestID]
+
+	if !ok || req.addr.String() != from.String() {
+		return nil
+	}
+
+	delete(s.requests, m.RequestID)
+
+	nodes := m.Nodes
+
+	if len(nodes) > kBucketSize {
+		nodes = nodes[:kBucketSize]
+	}
+
+	s.clock.Hold()
+	req.nodes <- nodes
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestServer_processPeersMessage(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn, ID: testPeerID(b), Outgoing: true, dialAddr: "10.0.0.1:3228"}}
+
+	handshake := b.newHandshakeMessage()
+	handshake.ListenAddress = "10.0.0.1:3228"
+	assert.Nil(t, a.processHandshakeMessage(conn.RemoteAddr(), handshake))
+
+	known, ok := a.addrBook.Get("10.0.0.1:3228")
+	assert.True(t, ok)
+	assert.Equal(t, testPeerID(b), known.ID)
+
+	assert.Nil(t, a.processPeersMessage(conn.RemoteAddr(), &PeersMessage{
+		Addresses: []string{"10.0.0.2:3228", "garbage", a.so.ListenAddress},
+	}))
+
+	assert.Equal(t, 2, a.addrBook.Size())
+	assert.True(t, a.isConnectedOrDialing("10.0.0.1:3228"))
+	assert.Equal(t, []string{"10.0.0.2:3228"}, a.addrBook.Candidates(10, a.isConnectedOrDialing))
+
+	duplicate := pipeConn(t, 2)
+	a.peerMap[duplicate.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: duplicate, ID: testPeerID(b)}}
+
+	assert.ErrorIs(t, a.processHandshakeMessage(duplicate.RemoteAddr(), b.newHandshakeMessage()), IncompatiblePeerError)
+	assert.False(t, a.isHandshaked(duplicate.RemoteAddr()))
+
+	// an inbound peer cannot tie its key to the address it claims
+	c := newTestServer(t)
+	inbound := pipeConn(t, 3)
+	a.peerMap[inbound.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: inbound, ID: testPeerID(c)}}
+
+	claim := c.newHandshakeMessage()
+	claim.ListenAddress = "10.0.0.3:3228"
+	assert.Nil(t, a.processHandshakeMessage(inbound.RemoteAddr(), claim))
+
+	claimed, ok := a.addrBook.Get("10.0.0.3:3228")
+	assert.True(t, ok)
+	assert.True(t, claimed.ID.IsZero())
+}
+
+func TestServer_processNodesMessage(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	other := pipeConn(t, 2)
+
+	req := &findNodeRequest{
+		addr:  conn.RemoteAddr(),
+		nodes: make(chan []Contact, 1),
+	}
+
+	a.requests[7] = req
+
+	nodes := []Contact{{ID: testPeerID(b), Address: "10.0.0.1:3228"}}
+
+	assert.Nil(t, a.processNodesMessage(other.RemoteAddr(), &NodesMessage{RequestID: 7, Nodes: nodes}))
+	assert.Nil(t, a.processNodesMessage(conn.RemoteAddr(), &NodesMessage{RequestID: 8, Nodes: nodes}))
+	assert.Len(t, req.nodes, 0)
+
+	assert.Nil(t, a.processNodesMessage(conn.RemoteAddr(), &NodesMessage{RequestID: 7, Nodes: nodes}))
+
+	select {
+	case got := <-req.nodes:
+		assert.Equal(t, nodes, got)
+	case <-time.After(time.Second):
+		t.Fatal("nodes were not delivered")
+	}
+}
+
+func TestServer_findNode(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{
+		Peer:       &TCPPeer{conn: conn, ID: testPeerID(b)},
+		Handshaked: true,
+	}
+
+	target := randomPeerID()
+	nodes := []Contact{{ID: randomPeerID(), Address: "10.0.0.2:3228"}}
+
+	go func() {
+		for {
+			a.mu.RLock()
+			n := len(a.requests)
+			a.mu.RUnlock()
+
+			if n > 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		_ = a.processNodesMessage(conn.RemoteAddr(), &NodesMessage{RequestID: 1, Nodes: nodes})
+	}()
+
+	got, err := a.findNode(Contact{ID: testPeerID(b), Address: "10.0.0.1:3228"}, target)
+
+	assert.Nil(t, err)
+	assert.Equal(t, nodes, got)
+
+	_, ok := a.addrBook.Get("10.0.0.2:3228")
+	assert.True(t, ok)
+}
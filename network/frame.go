@@ -0,0 +1,142 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A frame is magic [4] | message type u8 | payload length u32 | checksum [4] |
+// payload, where the checksum is the first four bytes of sha256(payload).
+const (
+	frameHeaderSize = 4 + 1 + 4 + 4
+	// MaxMessageSize bounds the payload of a single frame.
+	MaxMessageSize = 32 << 20
+	// defaultMaxPayloadSize bounds the message types missing from
+	// maxPayloadSizes.
+	defaultMaxPayloadSize = 64 << 10
+	maxHandshakeSize      = 4 << 10
+	// frameChunkSize is how much payload is buffered ahead of what has
+	// arrived, so a large length costs nothing until it is sent.
+	frameChunkSize = 64 << 10
+)
+
+var frameMagic = [4]byte{'U', 'R', 'T', 'N'}
+
+// maxPayloadSizes bounds the message types that carry blocks, transactions or
+// long lists.
+var maxPayloadSizes = map[MessageType]int{
+	MessageTypeTx:           2 << 20,
+	MessageTypeBlock:        4 << 20,
+	MessageTypeBlocks:       MaxMessageSize,
+	MessageTypeHeaders:      1 << 20,
+	MessageTypeInv:          256 << 10,
+	MessageTypeGetData:      256 << 10,
+	MessageTypeCompactBlock: 1 << 20,
+	MessageTypeGetBlockTxn:  1 << 20,
+	MessageTypeBlockTxn:     4 << 20,
+	MessageTypeMempoolInv:   256 << 10,
+}
+
+var (
+	InvalidMagicError     = errors.New("invalid frame magic")
+	FrameTooLargeError    = errors.New("frame exceeds max message size")
+	ChecksumMismatchError = errors.New("frame checksum mismatch")
+)
+
+func encodeFrame(t MessageType, payload []byte) ([]byte, error) {
+	if len(payload) > MaxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes", FrameTooLargeError, len(payload))
+	}
+
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	copy(buf[0:4], frameMagic[:])
+	buf[4] = byte(t)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(payload)))
+	copy(buf[9:13], frameChecksum(payload))
+
+	return append(buf, payload...), nil
+}
+
+// maxPayloadSize returns the largest payload accepted for t.
+func maxPayloadSize(t MessageType) int {
+	if size, ok := maxPayloadSizes[t]; ok {
+		return size
+	}
+
+	return defaultMaxPayloadSize
+}
+
+// preHandshakeSize only lets a handshake through, which must be the first
+// frame of a connection.
+func preHandshakeSize(t MessageType) int {
+	if t == MessageTypeHandshake {
+		return maxHandshakeSize
+	}
+
+	return 0
+}
+
+// readFrame reads exactly one frame from r and returns it raw, rejecting a
+// payload longer than maxSize allows for its type. It only checks what is
+// needed to find the frame boundaries; a stream that fails those checks
+// cannot be resynchronized.
+func readFrame(r io.Reader, maxSize func(MessageType) int) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[0:4], frameMagic[:]) {
+		return nil, InvalidMagicError
+	}
+
+	t := MessageType(header[4])
+	length := binary.BigEndian.Uint32(header[5:9])
+
+	if int64(length) > int64(maxSize(t)) {
+		return nil, fmt.Errorf("%w: %d bytes of type %d", FrameTooLargeError, length, t)
+	}
+
+	frame := bytes.NewBuffer(make([]byte, 0, frameHeaderSize+min(int(length), frameChunkSize)))
+	frame.Write(header)
+
+	n, err := frame.ReadFrom(io.LimitReader(r, int64(length)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n < int64(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return frame.Bytes(), nil
+}
+
+// ReadMessage reads one frame from r and verifies its checksum.
+func ReadMessage(r io.Reader) (*Message, error) {
+	frame, err := readFrame(r, maxPayloadSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload := frame[frameHeaderSize:]
+
+	if !bytes.Equal(frame[9:13], frameChecksum(payload)) {
+		return nil, ChecksumMismatchError
+	}
+
+	return NewMessage(MessageType(frame[4]), payload), nil
+}
+
+func frameChecksum(payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+
+	return sum[:4]
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadMessage(t *testing.T) {
+	first, err := NewMessage(MessageTypeTx, []byte("first")).Bytes()
+	assert.Nil(t, err)
+
+	second, err := NewMessage(MessageTypeBlocks, bytes.Repeat([]byte{0x42}, 10000)).Bytes()
+	assert.Nil(t, err)
+
+	// coalesced frames delivered one byte per read
+	r := iotest.OneByteReader(bytes.NewReader(append(first, second...)))
+
+	msg, err := ReadMessage(r)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeTx, msg.Header)
+	assert.Equal(t, []byte("first"), msg.Data)
+
+	msg, err = ReadMessage(r)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeBlocks, msg.Header)
+	assert.Len(t, msg.Data, 10000)
+}
+
+func TestReadMessage_Rejects(t *testing.T) {
+	frame, err := NewMessage(MessageTypeTx, []byte("payload")).Bytes()
+	assert.Nil(t, err)
+
+	badMagic := bytes.Clone(frame)
+	badMagic[0] = 'X'
+	_, err = ReadMessage(bytes.NewReader(badMagic))
+	assert.ErrorIs(t, err, InvalidMagicError)
+
+	corrupted := bytes.Clone(frame)
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, err = ReadMessage(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ChecksumMismatchError)
+
+	tooLarge := bytes.Clone(frame)
+	tooLarge[5] = 0xff
+	_, err = ReadMessage(bytes.NewReader(tooLarge))
+	assert.ErrorIs(t, err, FrameTooLargeError)
+
+	_, err = NewMessage(MessageTypeTx, make([]byte, MaxMessageSize+1)).Bytes()
+	assert.ErrorIs(t, err, FrameTooLargeError)
+}
+
+func TestReadFrame_Limits(t *testing.T) {
+	frameOf := func(mt MessageType, length uint32) []byte {
+		frame, err := NewMessage(mt, []byte("short")).Bytes()
+		assert.Nil(t, err)
+		binary.BigEndian.PutUint32(frame[5:9], length)
+
+		return frame
+	}
+
+	// each type has its own limit
+	_, err := readFrame(bytes.NewReader(frameOf(MessageTypeInv, uint32(maxPayloadSizes[MessageTypeInv]+1))), maxPayloadSize)
+	assert.ErrorIs(t, err, FrameTooLargeError)
+
+	_, err = readFrame(bytes.NewReader(frameOf(MessageTypePing, defaultMaxPayloadSize+1)), maxPayloadSize)
+	assert.ErrorIs(t, err, FrameTooLargeError)
+
+	// a length within the limit is only buffered as the payload arrives
+	_, err = readFrame(bytes.NewReader(frameOf(MessageTypeBlocks, MaxMessageSize)), maxPayloadSize)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// before the handshake nothing else gets through
+	handshake, err := NewMessage(MessageTypeHandshake, []byte("hello")).Bytes()
+	assert.Nil(t, err)
+
+	_, err = readFrame(bytes.NewReader(handshake), preHandshakeSize)
+	assert.Nil(t, err)
+
+	_, err = readFrame(bytes.NewReader(frameOf(MessageTypeHandshake, maxHandshakeSize+1)), preHandshakeSize)
+	assert.ErrorIs(t, err, FrameTooLargeError)
+
+	_, err = readFrame(bytes.NewReader(frameOf(MessageTypeTx, 5)), preHandshakeSize)
+	assert.ErrorIs(t, err, FrameTooLargeError)
+}
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"container/list"
+	"errors"
+	"github.com/Phanile/uretra_network/types"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// seenCacheSize is how many transaction and block hashes are remembered
+	// as already handled.
+	seenCacheSize = 1 << 14
+
+	defaultTxRelayRate  = 100
+	defaultTxRelayBurst = 500
+)
+
+var RateLimitedError = errors.New("transaction relay rate exceeded")
+
+// seenCache is a bounded LRU set of the hashes of gossiped transactions and
+// blocks this node has already handled, so copies arriving from other peers
+// are dropped before any verification.
+type seenCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[types.Hash]*list.Element
+	order   *list.List
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		size:    size,
+		entries: make(map[types.Hash]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Add records hash as the most recently seen and reports whether it was new.
+func (c *seenCache) Add(hash types.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(e)
+		return false
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(types.Hash))
+	}
+
+	c.entries[hash] = c.order.PushFront(hash)
+
+	return true
+}
+
+// Contains reports whether hash was seen, refreshing it if so.
+func (c *seenCache) Contains(hash types.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[hash]
+
+	if ok {
+		c.order.MoveToFront(e)
+	}
+
+	return ok
+}
+
+// Remove forgets hash, so it is handled again when it next arrives.
+func (c *seenCache) Remove(hash types.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[hash]; ok {
+		c.order.Remove(e)
+		delete(c.entries, hash)
+	}
+}
+
+func (c *seenCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// tokenBucket allows burst events at once and rate events per second after
+// that.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Allow takes a token if there is one.
+func (b *tokenBucket) Allow(now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// GossipMetrics counts the gossiped messages dropped without being
+// processed.
+type GossipMetrics struct {
+	DuplicateTxs    uint64
+	DuplicateBlocks uint64
+	RateLimitedTxs  uint64
+}
+
+type gossipCounters struct {
+	duplicateTxs    atomic.Uint64
+	duplicateBlocks atomic.Uint64
+	rateLimitedTxs  atomic.Uint64
+}
+
+func (s *Server) GossipMetrics() GossipMetrics {
+	return GossipMetrics{
+		DuplicateTxs:    s.gossip.duplicateTxs.Load(),
+		DuplicateBlocks: s.gossip.duplicateBlocks.Load(),
+		RateLimitedTxs:  s.gossip.rateLimitedTxs.Load(),
+	}
+}
+
+// allowTx takes a token from the tx relay bucket of the peer at from. Local
+// transactions are not limited.
+func (s *Server) allowTx(from net.Addr) bool {
+	if from == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peerInfo, ok := s.peerMap[from]
+
+	if !ok {
+		return true
+	}
+
+	now := s.clock.Now()
+
+	if peerInfo.txBucket == nil {
+		peerInfo.txBucket = newTokenBucket(s.so.TxRelayRate, s.so.TxRelayBurst, now)
+	}
+
+	return peerInfo.txBucket.Allow(now)
+}
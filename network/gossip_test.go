@@ -0,0 +1,173 @@
+package network
+
+import (
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSeenCache_Add(t *testing.T) {
+	c := newSeenCache(2)
+
+	assert.True(t, c.Add(types.Hash{1}))
+	assert.False(t, c.Add(types.Hash{1}))
+	assert.True(t, c.Add(types.Hash{2}))
+
+	// a lookup refreshes the entry, so the other one is evicted
+	assert.True(t, c.Contains(types.Hash{1}))
+	assert.True(t, c.Add(types.Hash{3}))
+
+	assert.True(t, c.Contains(types.Hash{1}))
+	assert.False(t, c.Contains(types.Hash{2}))
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestTokenBucket_Allow(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(2, 3, now)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.Allow(now))
+	}
+
+	assert.False(t, b.Allow(now))
+	assert.True(t, b.Allow(now.Add(500*time.Millisecond)))
+	assert.False(t, b.Allow(now.Add(500*time.Millisecond)))
+
+	// refills stop at the burst
+	later := now.Add(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.Allow(later))
+	}
+
+	assert.False(t, b.Allow(later))
+}
+
+func TestServer_processTransactionDuplicate(t *testing.T) {
+	a := newTestServer(t)
+
+	first := pipeConn(t, 1)
+	second := pipeConn(t, 2)
+	a.peerMap[first.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: first}, Handshaked: true}
+	a.peerMap[second.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: second}, Handshaked: true}
+
+	tx := signedTx(t, fundedKey(t, a), 0, 1)
+	hash := tx.Hash(core.TxHasher{})
+
+	assert.Nil(t, a.processTransaction(first.RemoteAddr(), tx))
+	assert.Nil(t, a.processTransaction(second.RemoteAddr(), tx))
+	assert.Equal(t, uint64(1), a.GossipMetrics().DuplicateTxs)
+
+	// still a duplicate once mined out of the mempool
+	a.memPool.Remove(hash)
+	assert.Nil(t, a.processTransaction(second.RemoteAddr(), tx))
+	assert.False(t, a.memPool.Contains(hash))
+	assert.Equal(t, uint64(2), a.GossipMetrics().DuplicateTxs)
+
+	inv := &InvMessage{Items: []InvItem{{Type: InvTypeTx, Hash: hash}}}
+	assert.Nil(t, a.processInvMessage(first.RemoteAddr(), inv))
+	assert.NotContains(t, a.inflight, hash)
+}
+
+func TestServer_processTransactionRateLimit(t *testing.T) {
+	a := newTestServer(t)
+	key := fundedKey(t, a)
+	clock := NewSimClock(time.Unix(0, 0))
+	a.clock = clock
+	a.so.TxRelayRate = 1
+	a.so.TxRelayBurst = 2
+
+	first := pipeConn(t, 1)
+	second := pipeConn(t, 2)
+	a.peerMap[first.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: first}, Handshaked: true}
+	a.peerMap[second.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: second}, Handshaked: true}
+
+	txs := make([]*core.Transaction, 4)
+
+	for i := range txs {
+		txs[i] = signedTx(t, key, uint64(i), 1)
+	}
+
+	for _, tx := range txs[:3] {
+		assert.Nil(t, a.processTransaction(first.RemoteAddr(), tx))
+	}
+
+	assert.Equal(t, 2, a.memPool.Count())
+	assert.Equal(t, uint64(1), a.GossipMetrics().RateLimitedTxs)
+
+	// the limit is per peer, and a dropped transaction is not remembered
+	assert.Nil(t, a.processTransaction(second.RemoteAddr(), txs[2]))
+	assert.True(t, a.memPool.Contains(txs[2].Hash(core.TxHasher{})))
+
+	clock.Advance(time.Second)
+	assert.Nil(t, a.processTransaction(first.RemoteAddr(), txs[3]))
+	assert.Equal(t, 4, a.memPool.Count())
+
+	// local transactions are not limited
+	assert.Nil(t, a.processTransaction(nil, signedTx(t, key, 4, 1)))
+	assert.Equal(t, 5, a.memPool.Count())
+
+	// nor are the ones we asked for
+	requested := signedTx(t, key, 5, 1)
+	inv := &InvMessage{Items: []InvItem{{Type: InvTypeTx, Hash: requested.Hash(core.TxHasher{})}}}
+	assert.Nil(t, a.processInvMessage(first.RemoteAddr(), inv))
+	assert.Nil(t, a.processTransaction(first.RemoteAddr(), requested))
+	assert.Equal(t, 6, a.memPool.Count())
+	assert.Equal(t, uint64(1), a.GossipMetrics().RateLimitedTxs)
+}
+
+func TestServer_processBlockDuplicate(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	privateKey := crypto.GeneratePrivateKey()
+	a, err := NewServer(&ServerOptions{
+		PrivateKey: &privateKey,
+		Logger:     log.NewNopLogger(),
+		Clock:      clock,
+	})
+	assert.Nil(t, err)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	b := testCompactBlock(t, newTestServer(t), nil)
+
+	assert.Nil(t, a.processBlock(conn.RemoteAddr(), b))
+	assert.Nil(t, a.processBlock(conn.RemoteAddr(), b))
+	assert.Nil(t, a.processCompactBlockMessage(conn.RemoteAddr(), newCompactBlock(b)))
+	assert.Equal(t, uint64(2), a.GossipMetrics().DuplicateBlocks)
+
+	// let the relay finish before the block goes away with the test
+	clock.Advance(0)
+}
+
+func TestServer_processTransactionExpired(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	privateKey := crypto.GeneratePrivateKey()
+	a, err := NewServer(&ServerOptions{
+		PrivateKey: &privateKey,
+		Logger:     log.NewNopLogger(),
+		Clock:      clock,
+		TxLifetime: time.Hour,
+	})
+	assert.Nil(t, err)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	tx := signedTx(t, fundedKey(t, a), 0, 1)
+	hash := tx.Hash(core.TxHasher{})
+
+	assert.Nil(t, a.addTransaction(nil, tx))
+	clock.Advance(2 * time.Hour)
+	assert.Equal(t, 1, a.memPool.Expire())
+
+	// an expired transaction is not remembered as known
+	assert.False(t, a.seen.Contains(hash))
+	assert.Nil(t, a.addTransaction(conn.RemoteAddr(), tx))
+	assert.True(t, a.memPool.Contains(hash))
+}
@@ -0,0 +1,183 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/Phanile/uretra_network/core"
+	"net"
+	"time"
+)
+
+const (
+	// ProtocolVersion is bumped on any incompatible change of the wire protocol.
+	ProtocolVersion  uint32 = 1
+	handshakeTimeout        = 10 * time.Second
+)
+
+var (
+	IncompatiblePeerError = errors.New("incompatible peer")
+	HandshakeMissingError = errors.New("message received before handshake")
+)
+
+func (s *Server) newHandshakeMessage() *HandshakeMessage {
+	genesis, _ := s.chain.GetHeader(0)
+	height := s.chain.Height()
+	best, _ := s.chain.GetHeader(height)
+
+	m := &HandshakeMessage{
+		ProtocolVersion: ProtocolVersion,
+		ChainID:         s.chain.ChainID(),
+		GenesisHash:     core.HeaderHasher{}.Hash(genesis),
+		NodeID:          s.so.ID,
+		ListenAddress:   s.so.ListenAddress,
+		Height:          height,
+	}
+
+	if best != nil {
+		m.BestHash = core.HeaderHasher{}.Hash(best)
+	}
+
+	return m
+}
+
+// startHandshake sends our handshake to a freshly connected peer and drops
+// the peer if it does not answer with a valid one in time.
+func (s *Server) startHandshake(peer *TCPPeer) {
+	buf := &bytes.Buffer{}
+
+	if err := gob.NewEncoder(buf).Encode(s.newHandshakeMessage()); err != nil {
+		_ = s.so.Logger.Log("error", "encode handshake message failed", "err", err)
+		return
+	}
+
+	data, err := NewMessage(MessageTypeHandshake, buf.Bytes()).Bytes()
+
+	if err != nil {
+		_ = s.so.Logger.Log("error", "encode handshake message failed", "err", err)
+		return
+	}
+
+	if errSend := peer.Send(data); errSend != nil {
+		s.removePeer(peer.conn.RemoteAddr())
+		return
+	}
+
+	s.clock.AfterFunc(handshakeTimeout, func() {
+		if !s.isHandshaked(peer.conn.RemoteAddr()) {
+			_ = s.so.Logger.Log("msg", "handshake timed out", "addr", peer.conn.RemoteAddr().String())
+			s.removePeer(peer.conn.RemoteAddr())
+		}
+	})
+}
+
+func (s *Server) checkHandshake(m *HandshakeMessage) error {
+	genesis, err := s.chain.GetHeader(0)
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case m.ProtocolVersion != ProtocolVersion:
+		return fmt.Errorf("%w: protocol version %d, want %d", IncompatiblePeerError, m.ProtocolVersion, ProtocolVersion)
+	case m.ChainID != s.chain.ChainID():
+		return fmt.Errorf("%w: chain id %d, want %d", IncompatiblePeerError, m.ChainID, s.chain.ChainID())
+	case m.GenesisHash != core.HeaderHasher{}.Hash(genesis):
+		return fmt.Errorf("%w: genesis %s", IncompatiblePeerError, m.GenesisHash)
+	case m.NodeID == s.so.ID:
+		return fmt.Errorf("%w: connected to self", IncompatiblePeerError)
+	}
+
+	return nil
+}
+
+func (s *Server) processHandshakeMessage(from net.Addr, m *HandshakeMessage) error {
+	if err := s.checkHandshake(m); err != nil {
+		s.removePeer(from)
+		return err
+	}
+
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[from]
+
+	if ok && peerInfo.Peer.ID.String() != m.NodeID {
+		s.mu.Unlock()
+		s.removePeer(from)
+
+		return fmt.Errorf("%w: node id %s does not match connection key %s", IncompatiblePeerError, m.NodeID, peerInfo.Peer.ID)
+	}
+
+	if ok && s.isDuplicateNoLock(from, peerInfo.Peer.ID) {
+		s.mu.Unlock()
+		s.removePeer(from)
+
+		return fmt.Errorf("%w: already connected to %s", IncompatiblePeerError, m.NodeID)
+	}
+
+	if ok {
+		peerInfo.Handshaked = true
+		peerInfo.ListenAddress = m.ListenAddress
+		peerInfo.ProtocolVersion = m.ProtocolVersion
+		peerInfo.BlockchainHeight = m.Height
+	}
+
+	s.mu.Unlock()
+
+	if !ok {
+		return errors.New("trying to process handshake - peer not found")
+	}
+
+	_ = s.so.Logger.Log("msg", "handshake completed", "peer", m.NodeID, "addr", from.String(), "height", m.Height)
+
+	s.connManager.Handshaked(peerInfo.Peer.dialAddr)
+
+	if len(m.ListenAddress) > 0 {
+		s.connManager.Handshaked(m.ListenAddress)
+		s.addrBook.Add(m.ListenAddress, AddressSourcePeer)
+
+		// only a connection we dialed proves which key answers at the address
+		if peerInfo.Peer.Outgoing && peerInfo.Peer.dialAddr == m.ListenAddress {
+			s.addrBook.MarkGood(m.ListenAddress, peerInfo.Peer.ID)
+		}
+
+		contact := Contact{ID: peerInfo.Peer.ID, Address: m.ListenAddress}
+		s.clock.Go(func() { s.dht.AddContact(contact) })
+	}
+
+	if err := s.sendMessage(peerInfo.Peer, MessageTypeGetPeers, &GetPeersMessage{}); err != nil {
+		return err
+	}
+
+	if err := s.requestMempool(from); err != nil {
+		return err
+	}
+
+	return s.processStatusMessage(from, &StatusMessage{
+		ID:           m.NodeID,
+		ActualHeight: m.Height,
+		Version:      m.ProtocolVersion,
+	})
+}
+
+// isDuplicateNoLock reports whether another handshaked connection to the same
+// node exists already.
+func (s *Server) isDuplicateNoLock(addr net.Addr, id PeerID) bool {
+	for other, peerInfo := range s.peerMap {
+		if other != addr && peerInfo.Handshaked && peerInfo.Peer.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) isHandshaked(addr net.Addr) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peerInfo, ok := s.peerMap[addr]
+
+	return ok && peerInfo.Handshaked
+}
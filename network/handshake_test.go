@@ -0,0 +1,140 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *Server {
+	privateKey := crypto.GeneratePrivateKey()
+
+	s, err := NewServer(&ServerOptions{
+		PrivateKey: &privateKey,
+		Logger:     log.NewNopLogger(),
+	})
+
+	assert.Nil(t, err)
+
+	return s
+}
+
+func testPeerID(s *Server) PeerID {
+	return PeerIDFromPublicKey(s.so.NodeKey.Public().(ed25519.PublicKey))
+}
+
+// testConn is one end of an in-memory pipe with a distinct remote address,
+// since all net.Pipe ends share the same one.
+type testConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *testConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// pipeConn returns a connection whose writes are discarded by the other end.
+func pipeConn(t *testing.T, port int) net.Conn {
+	return pipeConnFrom(t, net.IPv4(10, 0, 0, 1), port)
+}
+
+func pipeConnFrom(t *testing.T, ip net.IP, port int) net.Conn {
+	local, remote := net.Pipe()
+
+	go func() {
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+
+	t.Cleanup(func() {
+		_ = local.Close()
+		_ = remote.Close()
+	})
+
+	return &testConn{
+		Conn:   local,
+		remote: &net.TCPAddr{IP: ip, Port: port},
+	}
+}
+
+// recordConn returns a connection whose writes are decoded into the returned
+// channel.
+func recordConn(t *testing.T, port int) (net.Conn, <-chan *DecodedMessage) {
+	local, remote := net.Pipe()
+	conn := &testConn{
+		Conn:   local,
+		remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port},
+	}
+	messages := make(chan *DecodedMessage, 64)
+
+	go func() {
+		defer close(messages)
+
+		for {
+			msg, err := DefaultRPCDecodeFunc(RPC{From: conn.remote, Payload: remote})
+
+			if err != nil {
+				return
+			}
+
+			messages <- msg
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = local.Close()
+		_ = remote.Close()
+	})
+
+	return conn, messages
+}
+
+func TestServer_checkHandshake(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	assert.Nil(t, a.checkHandshake(b.newHandshakeMessage()))
+	assert.ErrorIs(t, a.checkHandshake(a.newHandshakeMessage()), IncompatiblePeerError)
+
+	wrongVersion := b.newHandshakeMessage()
+	wrongVersion.ProtocolVersion++
+	assert.ErrorIs(t, a.checkHandshake(wrongVersion), IncompatiblePeerError)
+
+	wrongChain := b.newHandshakeMessage()
+	wrongChain.ChainID++
+	assert.ErrorIs(t, a.checkHandshake(wrongChain), IncompatiblePeerError)
+
+	wrongGenesis := b.newHandshakeMessage()
+	wrongGenesis.GenesisHash = types.RandomHash()
+	assert.ErrorIs(t, a.checkHandshake(wrongGenesis), IncompatiblePeerError)
+}
+
+func TestServer_processHandshakeMessage(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	good := pipeConn(t, 1)
+	bad := pipeConn(t, 2)
+	impostor := pipeConn(t, 3)
+
+	a.peerMap[good.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: good, ID: testPeerID(b)}}
+	a.peerMap[bad.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: bad, ID: testPeerID(b)}}
+	a.peerMap[impostor.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: impostor, ID: PeerID{1}}}
+
+	assert.Nil(t, a.processHandshakeMessage(good.RemoteAddr(), b.newHandshakeMessage()))
+	assert.True(t, a.isHandshaked(good.RemoteAddr()))
+
+	assert.ErrorIs(t, a.processHandshakeMessage(impostor.RemoteAddr(), b.newHandshakeMessage()), IncompatiblePeerError)
+	assert.False(t, a.isHandshaked(impostor.RemoteAddr()))
+
+	foreign := b.newHandshakeMessage()
+	foreign.ChainID++
+
+	assert.ErrorIs(t, a.processHandshakeMessage(bad.RemoteAddr(), foreign), IncompatiblePeerError)
+	assert.False(t, a.isHandshaked(bad.RemoteAddr()))
+}
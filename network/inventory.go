@@ -0,0 +1,325 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/types"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	knownInventorySize = 4096
+	// getDataTimeout is how long an item asked from one peer is not asked
+	// from others that announce it.
+	getDataTimeout = 30 * time.Second
+)
+
+var TooManyItemsError = errors.New("too many inventory items")
+
+// knownInventory is a bounded set of the hashes a peer is known to have, so
+// they are neither announced nor sent back to it. The oldest hashes are
+// forgotten first. The zero value is ready to use.
+type knownInventory struct {
+	mu     sync.Mutex
+	hashes map[types.Hash]struct{}
+	order  []types.Hash
+}
+
+// Add records hash and reports whether it was new.
+func (k *knownInventory) Add(hash types.Hash) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.hashes == nil {
+		k.hashes = make(map[types.Hash]struct{})
+	}
+
+	if _, ok := k.hashes[hash]; ok {
+		return false
+	}
+
+	if len(k.order) >= knownInventorySize {
+		delete(k.hashes, k.order[0])
+		k.order = k.order[1:]
+	}
+
+	k.hashes[hash] = struct{}{}
+	k.order = append(k.order, hash)
+
+	return true
+}
+
+func (k *knownInventory) Contains(hash types.Hash) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	_, ok := k.hashes[hash]
+
+	return ok
+}
+
+// peersLacking returns the handshaked peers not known to have hash and
+// records that they are about to get it.
+func (s *Server) peersLacking(hash types.Hash) []*TCPPeer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var peers []*TCPPeer
+
+	for _, peerInfo := range s.peerMap {
+		if peerInfo.Handshaked && peerInfo.known.Add(hash) {
+			peers = append(peers, peerInfo.Peer)
+		}
+	}
+
+	return peers
+}
+
+// announce sends item to every handshaked peer not known to have it.
+func (s *Server) announce(item InvItem) {
+	for _, peer := range s.peersLacking(item.Hash) {
+		if err := s.sendMessage(peer, MessageTypeInv, &InvMessage{Items: []InvItem{item}}); err != nil {
+			_ = s.so.Logger.Log("error", "announce failed", "addr", peer.conn.RemoteAddr().String(), "err", err)
+		}
+	}
+}
+
+// received records that the peer at from has the item hash, which also
+// answers any request for it, and reports whether it was requested.
+func (s *Server) received(from net.Addr, hash types.Hash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, requested := s.inflight[hash]
+	delete(s.inflight, hash)
+
+	if peerInfo, ok := s.peerMap[from]; ok {
+		peerInfo.known.Add(hash)
+	}
+
+	return requested
+}
+
+func (s *Server) hasInventory(item InvItem) bool {
+	switch item.Type {
+	case InvTypeTx:
+		return s.seen.Contains(item.Hash) || s.memPool.Contains(item.Hash)
+	case InvTypeBlock:
+		return s.seen.Contains(item.Hash) || s.chain.HasBlockHash(item.Hash)
+	}
+
+	// nothing to ask for an unknown type
+	return true
+}
+
+func (s *Server) processInvMessage(from net.Addr, m *InvMessage) error {
+	if len(m.Items) > maxInvPerMessage {
+		return fmt.Errorf("%w: %d", TooManyItemsError, len(m.Items))
+	}
+
+	now := s.clock.Now()
+	var missing []InvItem
+
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[from]
+
+	if !ok {
+		s.mu.Unlock()
+		return errors.New("trying to process invMessage - peer not found")
+	}
+
+	for hash, deadline := range s.inflight {
+		if !now.Before(deadline) {
+			delete(s.inflight, hash)
+		}
+	}
+
+	for _, item := range m.Items {
+		peerInfo.known.Add(item.Hash)
+
+		if _, requested := s.inflight[item.Hash]; requested || s.hasInventory(item) {
+			continue
+		}
+
+		s.inflight[item.Hash] = now.Add(getDataTimeout)
+		missing = append(missing, item)
+	}
+
+	peer := peerInfo.Peer
+	s.mu.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return s.sendMessage(peer, MessageTypeGetData, &GetDataMessage{Items: missing})
+}
+
+func (s *Server) processGetDataMessage(from net.Addr, m *GetDataMessage) error {
+	if len(m.Items) > maxInvPerMessage {
+		return fmt.Errorf("%w: %d", TooManyItemsError, len(m.Items))
+	}
+
+	s.mu.RLock()
+	peerInfo, ok := s.peerMap[from]
+	s.mu.RUnlock()
+
+	if !ok {
+		return errors.New("trying to process getDataMessage - peer not found")
+	}
+
+	for _, item := range m.Items {
+		data, err := s.inventoryMessage(item)
+
+		if err != nil {
+			return err
+		}
+
+		if data == nil {
+			continue
+		}
+
+		peerInfo.known.Add(item.Hash)
+
+		if errSend := peerInfo.Peer.Send(data); errSend != nil {
+			return errSend
+		}
+	}
+
+	return nil
+}
+
+// inventoryMessage returns the Tx or Block message for item, or nil if we do
+// not have it.
+func (s *Server) inventoryMessage(item InvItem) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	switch item.Type {
+	case InvTypeTx:
+		tx := s.memPool.Get(item.Hash)
+
+		if tx == nil {
+			return nil, nil
+		}
+
+		if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
+			return nil, err
+		}
+
+		return NewMessage(MessageTypeTx, buf.Bytes()).Bytes()
+
+	case InvTypeBlock:
+		b, err := s.chain.GetBlockByHash(item.Hash)
+
+		if err != nil {
+			return nil, nil
+		}
+
+		if errEncode := b.Encode(core.NewBinaryBlockEncoder(buf)); errEncode != nil {
+			return nil, errEncode
+		}
+
+		return NewMessage(MessageTypeBlock, buf.Bytes()).Bytes()
+	}
+
+	return nil, nil
+}
+
+// requestMempool asks the peer at from for its pending transactions, so a
+// node that just joined can include them in its blocks.
+func (s *Server) requestMempool(from net.Addr) error {
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[from]
+
+	if ok {
+		peerInfo.mempoolRequested = true
+	}
+
+	s.mu.Unlock()
+
+	if !ok {
+		return errors.New("trying to request mempool - peer not found")
+	}
+
+	return s.sendMessage(peerInfo.Peer, MessageTypeGetMempool, &GetMempoolMessage{})
+}
+
+func (s *Server) processGetMempoolMessage(from net.Addr) error {
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[from]
+	sent := ok && peerInfo.mempoolSent
+
+	if ok {
+		peerInfo.mempoolSent = true
+	}
+
+	s.mu.Unlock()
+
+	if !ok {
+		return errors.New("trying to process getMempoolMessage - peer not found")
+	}
+
+	if sent {
+		return fmt.Errorf("%w: repeated mempool request", UnsolicitedMessageError)
+	}
+
+	var hashes []types.Hash
+
+	for _, tx := range s.memPool.ByFeePriority() {
+		hash := tx.Hash(core.TxHasher{})
+
+		if peerInfo.known.Add(hash) {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	for len(hashes) > 0 {
+		page := hashes[:min(len(hashes), maxInvPerMessage)]
+		hashes = hashes[len(page):]
+
+		if err := s.sendMessage(peerInfo.Peer, MessageTypeMempoolInv, &MempoolInvMessage{Hashes: page, More: len(hashes) > 0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// processMempoolInvMessage fetches the transactions of a requested mempool
+// listing as if they had been announced. Only one listing is accepted per
+// request, and it ends with the first page that has no More.
+func (s *Server) processMempoolInvMessage(from net.Addr, m *MempoolInvMessage) error {
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[from]
+	requested := ok && peerInfo.mempoolRequested
+
+	if requested && !m.More {
+		peerInfo.mempoolRequested = false
+	}
+
+	s.mu.Unlock()
+
+	if !requested {
+		return fmt.Errorf("%w: mempool listing", UnsolicitedMessageError)
+	}
+
+	if len(m.Hashes) > maxInvPerMessage {
+		return fmt.Errorf("%w: %d", TooManyItemsError, len(m.Hashes))
+	}
+
+	items := make([]InvItem, len(m.Hashes))
+
+	for i, hash := range m.Hashes {
+		items[i] = InvItem{Type: InvTypeTx, Hash: hash}
+	}
+
+	return s.processInvMessage(from, &InvMessage{Items: items})
+}
@@ -0,0 +1,213 @@
+package network
+
+import (
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestKnownInventory_Add(t *testing.T) {
+	var k knownInventory
+
+	first := types.Hash{1}
+
+	assert.True(t, k.Add(first))
+	assert.False(t, k.Add(first))
+	assert.True(t, k.Contains(first))
+
+	for i := 0; i < knownInventorySize; i++ {
+		k.Add(types.Hash{2, byte(i), byte(i >> 8)})
+	}
+
+	assert.False(t, k.Contains(first))
+	assert.Len(t, k.hashes, knownInventorySize)
+}
+
+func TestServer_processInvMessage(t *testing.T) {
+	a := newTestServer(t)
+
+	first := pipeConn(t, 1)
+	second := pipeConn(t, 2)
+	a.peerMap[first.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: first}, Handshaked: true}
+	a.peerMap[second.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: second}, Handshaked: true}
+
+	tx := signedTx(t, fundedKey(t, a), 0, 1)
+	hash := tx.Hash(core.TxHasher{})
+	inv := &InvMessage{Items: []InvItem{{Type: InvTypeTx, Hash: hash}}}
+
+	assert.Nil(t, a.processInvMessage(first.RemoteAddr(), inv))
+	assert.Contains(t, a.inflight, hash)
+	assert.True(t, a.peerMap[first.RemoteAddr()].known.Contains(hash))
+
+	// already asked from the first peer, so not asked again
+	deadline := a.inflight[hash]
+	assert.Nil(t, a.processInvMessage(second.RemoteAddr(), inv))
+	assert.Equal(t, deadline, a.inflight[hash])
+	assert.True(t, a.peerMap[second.RemoteAddr()].known.Contains(hash))
+
+	assert.Nil(t, a.processTransaction(first.RemoteAddr(), tx))
+	assert.NotContains(t, a.inflight, hash)
+	assert.True(t, a.memPool.Contains(hash))
+
+	tooMany := &InvMessage{Items: make([]InvItem, maxInvPerMessage+1)}
+	assert.ErrorIs(t, a.processInvMessage(first.RemoteAddr(), tooMany), TooManyItemsError)
+}
+
+func TestServer_processTransactionBalance(t *testing.T) {
+	a := newTestServer(t)
+
+	unpaid := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+
+	assert.Nil(t, a.processTransaction(nil, unpaid))
+	assert.False(t, a.memPool.Contains(unpaid.Hash(core.TxHasher{})))
+}
+
+func TestServer_addTransaction(t *testing.T) {
+	a := newTestServer(t)
+	key := fundedKey(t, a)
+	unfunded := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+
+	tx := signedTx(t, key, 0, 1)
+
+	assert.Nil(t, a.addTransaction(nil, tx))
+	assert.ErrorIs(t, a.addTransaction(nil, tx), TxKnownError)
+	assert.ErrorIs(t, a.addTransaction(nil, unfunded), core.AccountNotEnoughBalanceError)
+	assert.ErrorIs(t, a.addTransaction(nil, signedTx(t, key, 1, 0)), FeeTooLowError)
+
+	// the nonce may run ahead of the chain by less than MaxNonceGap
+	assert.Nil(t, a.addTransaction(nil, signedTx(t, key, defaultMaxNonceGap-1, 1)))
+	assert.ErrorIs(t, a.addTransaction(nil, signedTx(t, key, defaultMaxNonceGap, 1)), core.InvalidNonceError)
+
+	// a peer is not to blame for any of these
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+	assert.Nil(t, a.processTransaction(conn.RemoteAddr(), tx))
+	assert.Nil(t, a.processTransaction(conn.RemoteAddr(), unfunded))
+	assert.Nil(t, a.processTransaction(conn.RemoteAddr(), signedTx(t, key, 1, 0)))
+	assert.Nil(t, a.processTransaction(conn.RemoteAddr(), signedTx(t, key, defaultMaxNonceGap, 1)))
+}
+
+func TestServer_inventoryMessage(t *testing.T) {
+	a := newTestServer(t)
+
+	tx := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+	assert.Nil(t, a.memPool.Add(tx))
+
+	header, err := a.chain.GetHeader(0)
+	assert.Nil(t, err)
+
+	for _, item := range []InvItem{
+		{Type: InvTypeTx, Hash: tx.Hash(core.TxHasher{})},
+		{Type: InvTypeBlock, Hash: core.HeaderHasher{}.Hash(header)},
+	} {
+		data, errMessage := a.inventoryMessage(item)
+		assert.Nil(t, errMessage)
+		assert.NotNil(t, data)
+	}
+
+	missing, err := a.inventoryMessage(InvItem{Type: InvTypeBlock, Hash: types.Hash{1}})
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestServer_processGetMempoolMessage(t *testing.T) {
+	a := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	tx := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+	hash := tx.Hash(core.TxHasher{})
+	assert.Nil(t, a.memPool.Add(tx))
+
+	assert.Nil(t, a.processGetMempoolMessage(conn.RemoteAddr()))
+	assert.True(t, a.peerMap[conn.RemoteAddr()].known.Contains(hash))
+
+	assert.ErrorIs(t, a.processGetMempoolMessage(conn.RemoteAddr()), UnsolicitedMessageError)
+}
+
+func TestServer_processGetMempoolMessagePages(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn, sent := recordConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	bConn := pipeConn(t, 2)
+	b.peerMap[bConn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: bConn}, Handshaked: true}
+
+	pooled := maxInvPerMessage + 500
+
+	for i := 0; i < pooled; i++ {
+		assert.Nil(t, a.memPool.Add(signedTx(t, crypto.GeneratePrivateKey(), 0, 1)))
+	}
+
+	assert.Nil(t, a.processGetMempoolMessage(conn.RemoteAddr()))
+	assert.Nil(t, b.requestMempool(bConn.RemoteAddr()))
+
+	for _, size := range []int{maxInvPerMessage, pooled - maxInvPerMessage} {
+		msg := <-sent
+		assert.IsType(t, &MempoolInvMessage{}, msg.Data)
+
+		page := msg.Data.(*MempoolInvMessage)
+		assert.Len(t, page.Hashes, size)
+		assert.Nil(t, b.processMempoolInvMessage(bConn.RemoteAddr(), page))
+	}
+
+	// every pooled transaction is asked for, and the last page ends the listing
+	assert.Len(t, b.inflight, pooled)
+	assert.ErrorIs(t, b.processMempoolInvMessage(bConn.RemoteAddr(), &MempoolInvMessage{}), UnsolicitedMessageError)
+}
+
+func TestServer_processMempoolInvMessage(t *testing.T) {
+	a := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	pooled := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+	assert.Nil(t, a.memPool.Add(pooled))
+
+	missing := types.Hash{1}
+	m := &MempoolInvMessage{Hashes: []types.Hash{pooled.Hash(core.TxHasher{}), missing}}
+
+	assert.ErrorIs(t, a.processMempoolInvMessage(conn.RemoteAddr(), m), UnsolicitedMessageError)
+
+	assert.Nil(t, a.requestMempool(conn.RemoteAddr()))
+	assert.Nil(t, a.processMempoolInvMessage(conn.RemoteAddr(), m))
+	assert.Contains(t, a.inflight, missing)
+	assert.NotContains(t, a.inflight, pooled.Hash(core.TxHasher{}))
+
+	// one listing answers one request
+	assert.ErrorIs(t, a.processMempoolInvMessage(conn.RemoteAddr(), m), UnsolicitedMessageError)
+
+	assert.Nil(t, a.requestMempool(conn.RemoteAddr()))
+	tooMany := &MempoolInvMessage{Hashes: make([]types.Hash, maxInvPerMessage+1)}
+	assert.ErrorIs(t, a.processMempoolInvMessage(conn.RemoteAddr(), tooMany), TooManyItemsError)
+}
+
+func TestServer_addTransactionReplace(t *testing.T) {
+	a := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn}, Handshaked: true}
+
+	// the block reward pays the fees
+	produceBlock(t, a)
+	key := *a.so.PrivateKey
+
+	assert.Nil(t, a.addTransaction(nil, signedTx(t, key, 0, 100)))
+	assert.ErrorIs(t, a.addTransaction(nil, signedTx(t, key, 0, 105)), UnderpricedReplacementError)
+
+	replacement := signedTx(t, key, 0, 110)
+	hash := replacement.Hash(core.TxHasher{})
+	assert.Nil(t, a.addTransaction(nil, replacement))
+	assert.Equal(t, 1, a.memPool.Count())
+
+	assert.Eventually(t, func() bool {
+		return a.peerMap[conn.RemoteAddr()].known.Contains(hash)
+	}, time.Second, 10*time.Millisecond)
+}
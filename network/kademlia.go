@@ -0,0 +1,418 @@
+package network
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// kBucketSize is the number of contacts kept per bucket and returned by
+	// a FIND_NODE.
+	kBucketSize = 16
+	// kAlpha is the number of FIND_NODE requests a lookup keeps in flight.
+	kAlpha       = 3
+	kBucketCount = len(PeerID{}) * 8
+	// bucketRefreshInterval is how long a bucket may go without a lookup before
+	// it is refreshed with one for a random ID in its range.
+	bucketRefreshInterval = 15 * time.Minute
+)
+
+// Contact is a node in the DHT: its ID and the address it listens on.
+type Contact struct {
+	ID      PeerID
+	Address string
+}
+
+func xorDistance(a, b PeerID) PeerID {
+	var d PeerID
+
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+
+	return d
+}
+
+// commonPrefixLen returns the number of leading bits a and b share.
+func commonPrefixLen(a, b PeerID) int {
+	d := xorDistance(a, b)
+
+	for i, v := range d {
+		if v != 0 {
+			return i*8 + bits.LeadingZeros8(v)
+		}
+	}
+
+	return kBucketCount
+}
+
+// closerTo reports whether a is closer to target than b.
+func closerTo(target, a, b PeerID) bool {
+	da := xorDistance(target, a)
+	db := xorDistance(target, b)
+
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// randomIDInBucket returns a random ID that falls into bucket i of self: it
+// shares exactly i leading bits with self.
+func randomIDInBucket(self PeerID, i int, rng *rand.Rand) PeerID {
+	var id PeerID
+
+	for b := range id {
+		id[b] = byte(rng.Intn(256))
+	}
+
+	for b := 0; b <= i && b < kBucketCount; b++ {
+		mask := byte(0x80) >> (b % 8)
+		bit := self[b/8] & mask
+
+		if b == i {
+			bit ^= mask
+		}
+
+		id[b/8] = id[b/8]&^mask | bit
+	}
+
+	return id
+}
+
+// RoutingTable is a Kademlia routing table. Bucket i holds the contacts that
+// share exactly i leading bits with self, least recently seen first.
+type RoutingTable struct {
+	mu        sync.RWMutex
+	self      PeerID
+	buckets   [kBucketCount][]Contact
+	refreshed [kBucketCount]time.Time
+}
+
+func NewRoutingTable(self PeerID) *RoutingTable {
+	rt := &RoutingTable{
+		self: self,
+	}
+
+	now := time.Now()
+
+	for i := range rt.refreshed {
+		rt.refreshed[i] = now
+	}
+
+	return rt
+}
+
+func (rt *RoutingTable) bucketIndex(id PeerID) int {
+	return commonPrefixLen(rt.self, id)
+}
+
+// Update records c as just seen. When its bucket is full, c is not added and
+// the least recently seen contact of the bucket is returned so the caller can
+// check whether it is still alive.
+func (rt *RoutingTable) Update(c Contact) (Contact, bool) {
+	if c.ID == rt.self || c.ID.IsZero() {
+		return Contact{}, false
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	i := rt.bucketIndex(c.ID)
+	bucket := rt.buckets[i]
+
+	for j, known := range bucket {
+		if known.ID == c.ID {
+			rt.buckets[i] = append(append(bucket[:j:j], bucket[j+1:]...), c)
+			return Contact{}, false
+		}
+	}
+
+	if len(bucket) < kBucketSize {
+		rt.buckets[i] = append(bucket, c)
+		return Contact{}, false
+	}
+
+	return bucket[0], true
+}
+
+func (rt *RoutingTable) Remove(id PeerID) {
+	if id == rt.self {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	i := rt.bucketIndex(id)
+	bucket := rt.buckets[i]
+
+	for j, known := range bucket {
+		if known.ID == id {
+			rt.buckets[i] = append(bucket[:j:j], bucket[j+1:]...)
+			return
+		}
+	}
+}
+
+func (rt *RoutingTable) Contains(id PeerID) bool {
+	if id == rt.self {
+		return false
+	}
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	for _, known := range rt.buckets[rt.bucketIndex(id)] {
+		if known.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rt *RoutingTable) Size() int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	size := 0
+
+	for _, bucket := range rt.buckets {
+		size += len(bucket)
+	}
+
+	return size
+}
+
+// Closest returns up to n contacts ordered by XOR distance to target.
+func (rt *RoutingTable) Closest(target PeerID, n int) []Contact {
+	rt.mu.RLock()
+	contacts := make([]Contact, 0, n)
+
+	for _, bucket := range rt.buckets {
+		contacts = append(contacts, bucket...)
+	}
+
+	rt.mu.RUnlock()
+
+	sort.Slice(contacts, func(i, j int) bool {
+		return closerTo(target, contacts[i].ID, contacts[j].ID)
+	})
+
+	if len(contacts) > n {
+		contacts = contacts[:n]
+	}
+
+	return contacts
+}
+
+func (rt *RoutingTable) markRefreshed(target PeerID) {
+	if target == rt.self {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.refreshed[rt.bucketIndex(target)] = time.Now()
+}
+
+// staleBuckets returns the buckets that had no lookup within maxAge, up to
+// the deepest non-empty one; deeper buckets cover ranges too small to hold
+// other nodes.
+func (rt *RoutingTable) staleBuckets(maxAge time.Duration) []int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	deepest := -1
+
+	for i, bucket := range rt.buckets {
+		if len(bucket) > 0 {
+			deepest = i
+		}
+	}
+
+	var stale []int
+
+	for i := 0; i <= deepest; i++ {
+		if time.Since(rt.refreshed[i]) >= maxAge {
+			stale = append(stale, i)
+		}
+	}
+
+	return stale
+}
+
+// FindNodeFunc asks the node c for the contacts it knows closest to target.
+type FindNodeFunc func(c Contact, target PeerID) ([]Contact, error)
+
+// PingFunc checks that the node c is still alive.
+type PingFunc func(c Contact) error
+
+// DHT runs Kademlia lookups over a RoutingTable. It does not know about the
+// wire protocol: requests go through the FindNodeFunc and PingFunc it is
+// given, so any number of nodes can be wired together in process. Lookups
+// run their requests on the clock and pick refresh targets from rng.
+type DHT struct {
+	self     Contact
+	table    *RoutingTable
+	findNode FindNodeFunc
+	ping     PingFunc
+	clock    Clock
+	rand     *rand.Rand
+}
+
+func NewDHT(self Contact, findNode FindNodeFunc, ping PingFunc, clock Clock, rng *rand.Rand) *DHT {
+	return &DHT{
+		self:     self,
+		table:    NewRoutingTable(self.ID),
+		findNode: findNode,
+		ping:     ping,
+		clock:    clock,
+		rand:     rng,
+	}
+}
+
+func (d *DHT) Table() *RoutingTable {
+	return d.table
+}
+
+// AddContact records c. If its bucket is full, the least recently seen
+// contact is pinged and replaced by c only when it does not answer.
+func (d *DHT) AddContact(c Contact) {
+	if len(c.Address) == 0 {
+		return
+	}
+
+	oldest, full := d.table.Update(c)
+
+	if !full {
+		return
+	}
+
+	if err := d.ping(oldest); err == nil {
+		d.table.Update(oldest)
+		return
+	}
+
+	d.table.Remove(oldest.ID)
+	d.table.Update(c)
+}
+
+// Closest answers a FIND_NODE for target.
+func (d *DHT) Closest(target PeerID) []Contact {
+	return d.table.Closest(target, kBucketSize)
+}
+
+// Lookup runs an iterative FIND_NODE for target and returns the closest live
+// contacts found. Nodes that answer are added to the routing table, nodes that
+// fail are dropped from it.
+func (d *DHT) Lookup(target PeerID) []Contact {
+	d.table.markRefreshed(target)
+
+	candidates := d.table.Closest(target, kBucketSize)
+	seen := make(map[PeerID]bool, len(candidates))
+	queried := make(map[PeerID]bool)
+	failed := make(map[PeerID]bool)
+
+	seen[d.self.ID] = true
+
+	for _, c := range candidates {
+		seen[c.ID] = true
+	}
+
+	type reply struct {
+		from     Contact
+		contacts []Contact
+		err      error
+	}
+
+	for {
+		// the lookup is done once the kBucketSize closest live candidates
+		// have all answered
+		var closest, batch []Contact
+
+		for _, c := range candidates {
+			if len(closest) == kBucketSize {
+				break
+			}
+
+			if failed[c.ID] {
+				continue
+			}
+
+			closest = append(closest, c)
+
+			if !queried[c.ID] && len(batch) < kAlpha {
+				batch = append(batch, c)
+			}
+		}
+
+		if len(batch) == 0 {
+			return closest
+		}
+
+		replies := make(chan reply, len(batch))
+
+		for _, c := range batch {
+			queried[c.ID] = true
+
+			d.clock.Go(func() {
+				contacts, err := d.findNode(c, target)
+				d.clock.Hold()
+				replies <- reply{from: c, contacts: contacts, err: err}
+			})
+		}
+
+		for range batch {
+			d.clock.Release()
+			r := <-replies
+
+			if r.err != nil {
+				failed[r.from.ID] = true
+				d.table.Remove(r.from.ID)
+				continue
+			}
+
+			d.AddContact(r.from)
+
+			for i, c := range r.contacts {
+				if i >= kBucketSize {
+					break
+				}
+
+				if seen[c.ID] || c.ID.IsZero() || len(c.Address) == 0 {
+					continue
+				}
+
+				seen[c.ID] = true
+				candidates = append(candidates, c)
+			}
+		}
+
+		sort.Slice(candidates, func(i, j int) bool {
+			return closerTo(target, candidates[i].ID, candidates[j].ID)
+		})
+	}
+}
+
+// Bootstrap joins the network through the given contacts: it looks up its own
+// ID to find its neighbours and then refreshes every bucket.
+func (d *DHT) Bootstrap(seeds []Contact) {
+	for _, c := range seeds {
+		d.AddContact(c)
+	}
+
+	d.Lookup(d.self.ID)
+	d.Refresh(0)
+}
+
+// Refresh runs a lookup for a random ID in every bucket that had none within
+// maxAge.
+func (d *DHT) Refresh(maxAge time.Duration) {
+	for _, i := range d.table.staleBuckets(maxAge) {
+		d.Lookup(randomIDInBucket(d.self.ID, i, d.rand))
+	}
+}
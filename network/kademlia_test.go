@@ -0,0 +1,233 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+var testRand = rand.New(rand.NewSource(1))
+
+func randomPeerID() PeerID {
+	var id PeerID
+	_, _ = testRand.Read(id[:])
+
+	return id
+}
+
+// dhtNetwork wires DHT nodes together in process. Every request also teaches
+// the callee about the caller, as a connection would.
+type dhtNetwork struct {
+	mu    sync.RWMutex
+	nodes map[PeerID]*DHT
+	down  map[PeerID]bool
+}
+
+var nodeDownError = errors.New("node down")
+
+func newDHTNetwork() *dhtNetwork {
+	return &dhtNetwork{
+		nodes: make(map[PeerID]*DHT),
+		down:  make(map[PeerID]bool),
+	}
+}
+
+func (n *dhtNetwork) remote(id PeerID) (*DHT, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	d, ok := n.nodes[id]
+
+	if !ok || n.down[id] {
+		return nil, nodeDownError
+	}
+
+	return d, nil
+}
+
+func (n *dhtNetwork) addNode() *DHT {
+	self := Contact{ID: randomPeerID()}
+	self.Address = fmt.Sprintf("%x", self.ID[:4])
+
+	findNode := func(c Contact, target PeerID) ([]Contact, error) {
+		remote, err := n.remote(c.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		remote.AddContact(self)
+
+		return remote.Closest(target), nil
+	}
+
+	ping := func(c Contact) error {
+		_, err := n.remote(c.ID)
+		return err
+	}
+
+	d := NewDHT(self, findNode, ping, realClock{}, rand.New(rand.NewSource(testRand.Int63())))
+
+	n.mu.Lock()
+	n.nodes[self.ID] = d
+	n.mu.Unlock()
+
+	return d
+}
+
+// closest returns the count live nodes closest to target other than from.
+func (n *dhtNetwork) closest(target PeerID, count int, from *DHT) []PeerID {
+	ids := make([]PeerID, 0, len(n.nodes))
+
+	for id := range n.nodes {
+		if !n.down[id] && id != from.self.ID {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return closerTo(target, ids[i], ids[j])
+	})
+
+	return ids[:count]
+}
+
+func contactIDs(contacts []Contact) []PeerID {
+	ids := make([]PeerID, len(contacts))
+
+	for i, c := range contacts {
+		ids[i] = c.ID
+	}
+
+	return ids
+}
+
+func TestRoutingTable_Update(t *testing.T) {
+	self := PeerID{}
+	rt := NewRoutingTable(self)
+
+	var first Contact
+
+	for i := 0; i < kBucketSize; i++ {
+		id := randomIDInBucket(self, 0, testRand)
+		c := Contact{ID: id, Address: id.String()}
+
+		if i == 0 {
+			first = c
+		}
+
+		_, full := rt.Update(c)
+		assert.False(t, full)
+	}
+
+	assert.Equal(t, kBucketSize, rt.Size())
+
+	extra := randomIDInBucket(self, 0, testRand)
+	oldest, full := rt.Update(Contact{ID: extra})
+	assert.True(t, full)
+	assert.Equal(t, first, oldest)
+	assert.False(t, rt.Contains(extra))
+
+	rt.Update(first)
+	oldest, _ = rt.Update(Contact{ID: extra})
+	assert.NotEqual(t, first, oldest)
+
+	_, full = rt.Update(Contact{ID: randomIDInBucket(self, 1, testRand)})
+	assert.False(t, full)
+	assert.Equal(t, kBucketSize+1, rt.Size())
+
+	rt.Update(Contact{ID: self})
+	assert.Equal(t, kBucketSize+1, rt.Size())
+}
+
+func TestRoutingTable_Closest(t *testing.T) {
+	rt := NewRoutingTable(randomPeerID())
+
+	for i := 0; i < 100; i++ {
+		rt.Update(Contact{ID: randomPeerID()})
+	}
+
+	target := randomPeerID()
+	closest := rt.Closest(target, 10)
+
+	assert.Len(t, closest, 10)
+
+	for i := 1; i < len(closest); i++ {
+		assert.True(t, closerTo(target, closest[i-1].ID, closest[i].ID))
+	}
+}
+
+func TestRandomIDInBucket(t *testing.T) {
+	self := randomPeerID()
+
+	for _, i := range []int{0, 1, 7, 8, 100, kBucketCount - 1} {
+		assert.Equal(t, i, commonPrefixLen(self, randomIDInBucket(self, i, testRand)))
+	}
+}
+
+func TestDHT_AddContactEvictsDeadNode(t *testing.T) {
+	n := newDHTNetwork()
+	d := n.addNode()
+
+	for d.Table().Size() < kBucketSize {
+		c := n.addNode().self
+
+		if commonPrefixLen(d.self.ID, c.ID) == 0 {
+			d.AddContact(c)
+		}
+	}
+
+	oldest := d.Table().Closest(d.self.ID, kBucketSize)
+	newcomer := Contact{ID: randomIDInBucket(d.self.ID, 0, testRand), Address: "newcomer"}
+
+	d.AddContact(newcomer)
+	assert.False(t, d.Table().Contains(newcomer.ID))
+
+	for _, c := range oldest {
+		n.down[c.ID] = true
+	}
+
+	d.AddContact(newcomer)
+	assert.True(t, d.Table().Contains(newcomer.ID))
+	assert.Equal(t, kBucketSize, d.Table().Size())
+}
+
+func TestDHT_Lookup(t *testing.T) {
+	n := newDHTNetwork()
+	seed := n.addNode()
+
+	nodes := []*DHT{seed}
+
+	for i := 0; i < 200; i++ {
+		d := n.addNode()
+		d.Bootstrap([]Contact{seed.self})
+		nodes = append(nodes, d)
+	}
+
+	for i := 0; i < 20; i++ {
+		target := randomPeerID()
+		from := nodes[i*7%len(nodes)]
+
+		assert.Equal(t, n.closest(target, kBucketSize, from), contactIDs(from.Lookup(target)))
+	}
+
+	for _, d := range nodes[1:] {
+		assert.Greater(t, d.Table().Size(), kBucketSize)
+	}
+
+	for _, d := range nodes[:50] {
+		n.down[d.self.ID] = true
+	}
+
+	// refreshing drops the dead nodes from the routing tables they are found in
+	for _, d := range nodes[50:] {
+		d.Refresh(0)
+	}
+
+	target := randomPeerID()
+	assert.Equal(t, n.closest(target, kBucketSize, nodes[100]), contactIDs(nodes[100].Lookup(target)))
+}
@@ -0,0 +1,327 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// firstLocalPort is where the ports of dialing ends of local connections
+// start, like the ephemeral ports of TCP.
+const firstLocalPort = 40000
+
+var UnreachableError = errors.New("address unreachable")
+
+// LocalNetwork connects LocalTransports within one process. Every Send is a
+// single write, so the network delays, drops and partitions whole messages.
+// Messages are delivered by timers of the network's SimClock, in the order
+// they were sent on each connection, and drops come from a seeded source, so
+// delivery is reproducible. A reader blocked on a connection is released on
+// the clock until data or EOF wakes it.
+type LocalNetwork struct {
+	mu         sync.Mutex
+	clock      *SimClock
+	rand       *rand.Rand
+	transports map[string]*LocalTransport
+	latency    time.Duration
+	dropRate   float64
+	// partition maps listen addresses to groups; nodes in different groups
+	// cannot reach each other.
+	partition map[string]int
+	nextPort  int
+}
+
+func NewLocalNetwork(clock *SimClock, seed int64) *LocalNetwork {
+	return &LocalNetwork{
+		clock:      clock,
+		rand:       rand.New(rand.NewSource(seed)),
+		transports: make(map[string]*LocalTransport),
+		partition:  make(map[string]int),
+		nextPort:   firstLocalPort,
+	}
+}
+
+// SetLatency delays every message by d.
+func (n *LocalNetwork) SetLatency(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.latency = d
+}
+
+// SetDropRate drops each message with probability rate.
+func (n *LocalNetwork) SetDropRate(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.dropRate = rate
+}
+
+// Partition splits the nodes at the given listen addresses into groups that
+// cannot reach each other. Nodes in no group reach everyone.
+func (n *LocalNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.partition = make(map[string]int)
+
+	for i, group := range groups {
+		for _, addr := range group {
+			n.partition[addr] = i
+		}
+	}
+}
+
+func (n *LocalNetwork) Heal() {
+	n.Partition()
+}
+
+func (n *LocalNetwork) reachableNoLock(from, to string) bool {
+	fromGroup, fromOk := n.partition[from]
+	toGroup, toOk := n.partition[to]
+
+	return !fromOk || !toOk || fromGroup == toGroup
+}
+
+func (n *LocalNetwork) deliver(c *localConn, data []byte) {
+	n.mu.Lock()
+	drop := !n.reachableNoLock(c.from, c.to) || n.dropRate > 0 && n.rand.Float64() < n.dropRate
+	latency := n.latency
+	n.mu.Unlock()
+
+	if drop {
+		return
+	}
+
+	n.clock.For(c.local.String()+">"+c.remote.String()).AfterFunc(latency, func() {
+		c.peer.push(data)
+	})
+}
+
+// LocalTransport is a Transport on a LocalNetwork. Connections carry the node
+// key's peer ID without a TLS handshake.
+type LocalTransport struct {
+	network *LocalNetwork
+	addr    string
+	id      PeerID
+	peerCh  chan *TCPPeer
+	mu      sync.Mutex
+	filter  func(addr net.Addr) bool
+}
+
+func NewLocalTransport(network *LocalNetwork, addr string, nodeKey ed25519.PrivateKey) *LocalTransport {
+	return &LocalTransport{
+		network: network,
+		addr:    addr,
+		id:      PeerIDFromPublicKey(nodeKey.Public().(ed25519.PublicKey)),
+		peerCh:  make(chan *TCPPeer),
+	}
+}
+
+func (t *LocalTransport) Peers() chan *TCPPeer {
+	return t.peerCh
+}
+
+func (t *LocalTransport) SetAcceptFilter(filter func(addr net.Addr) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.filter = filter
+}
+
+func (t *LocalTransport) Start() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if _, ok := t.network.transports[t.addr]; ok {
+		return fmt.Errorf("address %s already in use", t.addr)
+	}
+
+	t.network.transports[t.addr] = t
+
+	return nil
+}
+
+func (t *LocalTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if t.network.transports[t.addr] == t {
+		delete(t.network.transports, t.addr)
+	}
+
+	return nil
+}
+
+func (t *LocalTransport) Dial(addr string, id PeerID) (*TCPPeer, error) {
+	n := t.network
+
+	n.mu.Lock()
+	remote, ok := n.transports[addr]
+	reachable := n.reachableNoLock(t.addr, addr)
+	port := n.nextPort
+	n.nextPort++
+	n.mu.Unlock()
+
+	if !ok || !reachable {
+		return nil, fmt.Errorf("%w: %s", UnreachableError, addr)
+	}
+
+	if !id.IsZero() && remote.id != id {
+		return nil, fmt.Errorf("%w: expected %s, got %s", IncompatiblePeerError, id, remote.id)
+	}
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, errSplit := net.SplitHostPort(t.addr)
+
+	if errSplit != nil {
+		return nil, errSplit
+	}
+
+	localAddr := &net.TCPAddr{IP: net.ParseIP(host), Port: port}
+
+	remote.mu.Lock()
+	filter := remote.filter
+	remote.mu.Unlock()
+
+	if filter != nil && !filter(localAddr) {
+		return nil, fmt.Errorf("connection to %s refused", addr)
+	}
+
+	outbound, inbound := newLocalConnPair(n, t.addr, addr, localAddr, remoteAddr)
+
+	n.clock.Hold()
+
+	go func() {
+		remote.peerCh <- &TCPPeer{conn: inbound, ID: t.id}
+	}()
+
+	return &TCPPeer{conn: outbound, ID: remote.id, Outgoing: true}, nil
+}
+
+// localConn is one end of a LocalNetwork connection. Writes go through the
+// network; reads take what it delivered.
+type localConn struct {
+	network *LocalNetwork
+	// from and to are the listen addresses of the nodes at this end and at
+	// the other.
+	from, to      string
+	local, remote net.Addr
+	peer          *localConn
+	mu            sync.Mutex
+	cond          *sync.Cond
+	buf           bytes.Buffer
+	closed        bool
+	// waiting is set while a reader is blocked and released on the clock.
+	waiting bool
+}
+
+func newLocalConnPair(n *LocalNetwork, from, to string, fromAddr, toAddr net.Addr) (*localConn, *localConn) {
+	a := &localConn{network: n, from: from, to: to, local: fromAddr, remote: toAddr}
+	b := &localConn{network: n, from: to, to: from, local: toAddr, remote: fromAddr}
+	a.cond = sync.NewCond(&a.mu)
+	b.cond = sync.NewCond(&b.mu)
+	a.peer, b.peer = b, a
+
+	return a, b
+}
+
+func (c *localConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.buf.Len() == 0 && !c.closed {
+		if !c.waiting {
+			c.waiting = true
+			c.network.clock.Release()
+		}
+
+		c.cond.Wait()
+	}
+
+	if c.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return c.buf.Read(p)
+}
+
+func (c *localConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		return 0, net.ErrClosed
+	}
+
+	c.network.deliver(c, bytes.Clone(p))
+
+	return len(p), nil
+}
+
+func (c *localConn) push(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.buf.Write(data)
+		c.wakeNoLock()
+	}
+}
+
+func (c *localConn) wakeNoLock() {
+	if c.waiting {
+		c.waiting = false
+		c.network.clock.Hold()
+	}
+
+	c.cond.Broadcast()
+}
+
+func (c *localConn) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.wakeNoLock()
+}
+
+// Close closes both ends, as a reset TCP connection would.
+func (c *localConn) Close() error {
+	c.shutdown()
+	c.peer.shutdown()
+
+	return nil
+}
+
+func (c *localConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *localConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *localConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *localConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *localConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
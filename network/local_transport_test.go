@@ -0,0 +1,103 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newLocalTestTransport(t *testing.T, n *LocalNetwork, addr string) *LocalTransport {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	tr := NewLocalTransport(n, addr, key)
+	assert.Nil(t, tr.Start())
+
+	return tr
+}
+
+func TestLocalTransport_Dial(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	n := NewLocalNetwork(clock, 1)
+
+	a := newLocalTestTransport(t, n, "127.0.0.1:3000")
+	b := newLocalTestTransport(t, n, "127.0.0.2:3000")
+
+	_, errImpostor := a.Dial("127.0.0.2:3000", a.id)
+	assert.ErrorIs(t, errImpostor, IncompatiblePeerError)
+
+	outbound, err := a.Dial("127.0.0.2:3000", b.id)
+	assert.Nil(t, err)
+	assert.Equal(t, b.id, outbound.ID)
+	assert.True(t, outbound.Outgoing)
+
+	// the test stands in for the server loops, which release what they are
+	// handed
+	inbound := <-b.Peers()
+	clock.Release()
+	assert.Equal(t, a.id, inbound.ID)
+	assert.Equal(t, outbound.conn.LocalAddr(), inbound.conn.RemoteAddr())
+
+	n.SetLatency(time.Second)
+	assert.Nil(t, outbound.Send([]byte("hello")))
+
+	buf := make([]byte, 5)
+	read := make(chan struct{})
+
+	clock.Hold()
+
+	go func() {
+		defer clock.Release()
+		_, _ = io.ReadFull(inbound.conn, buf)
+		close(read)
+	}()
+
+	clock.Advance(time.Second)
+	<-read
+	assert.Equal(t, "hello", string(buf))
+
+	assert.Nil(t, outbound.conn.Close())
+	_, errRead := inbound.conn.Read(buf)
+	assert.ErrorIs(t, errRead, io.EOF)
+
+	_, errDial := a.Dial("127.0.0.3:3000", PeerID{})
+	assert.ErrorIs(t, errDial, UnreachableError)
+}
+
+func TestLocalNetwork_Partition(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	n := NewLocalNetwork(clock, 1)
+
+	a := newLocalTestTransport(t, n, "127.0.0.1:3000")
+	b := newLocalTestTransport(t, n, "127.0.0.2:3000")
+	b.SetAcceptFilter(func(net.Addr) bool { return true })
+
+	outbound, err := a.Dial("127.0.0.2:3000", PeerID{})
+	assert.Nil(t, err)
+	inbound := <-b.Peers()
+	clock.Release()
+
+	n.Partition([]string{"127.0.0.1:3000"}, []string{"127.0.0.2:3000"})
+
+	_, errDial := a.Dial("127.0.0.2:3000", PeerID{})
+	assert.ErrorIs(t, errDial, UnreachableError)
+
+	// messages across the partition are lost
+	assert.Nil(t, outbound.Send([]byte("lost")))
+	n.Heal()
+	assert.Nil(t, outbound.Send([]byte("kept")))
+	clock.Advance(0)
+
+	buf := make([]byte, 4)
+	_, errRead := io.ReadFull(inbound.conn, buf)
+	assert.Nil(t, errRead)
+	assert.Equal(t, "kept", string(buf))
+
+	b.SetAcceptFilter(func(net.Addr) bool { return false })
+	_, errRefused := a.Dial("127.0.0.2:3000", PeerID{})
+	assert.NotNil(t, errRefused)
+}
@@ -1,14 +1,221 @@
 package network
 
 import (
+	"encoding/binary"
+	"fmt"
 	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/types"
+	"io"
 	"time"
 )
 
+const (
+	maxBlocksPerMessage  = 1000
+	maxHeadersPerMessage = 2000
+	maxInvPerMessage     = 1000
+)
+
 type BlocksMessage struct {
 	Blocks []*core.Block
 }
 
+// Encode writes the block count followed by the canonical encoding of each
+// block.
+func (m *BlocksMessage) Encode(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(m.Blocks))); err != nil {
+		return err
+	}
+
+	for _, b := range m.Blocks {
+		if err := b.Encode(core.NewBinaryBlockEncoder(w)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *BlocksMessage) Decode(r io.Reader) error {
+	var count uint32
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if count > maxBlocksPerMessage {
+		return fmt.Errorf("too many blocks in message: %d", count)
+	}
+
+	m.Blocks = make([]*core.Block, count)
+
+	for i := range m.Blocks {
+		m.Blocks[i] = &core.Block{}
+
+		if err := m.Blocks[i].Decode(core.NewBinaryBlockDecoder(r)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type GetHeadersMessage struct {
+	From uint32
+	To   uint32
+}
+
+type HeadersMessage struct {
+	Headers []*core.SignedHeader
+}
+
+// Encode writes the header count followed by the canonical encoding of each
+// signed header.
+func (m *HeadersMessage) Encode(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(m.Headers))); err != nil {
+		return err
+	}
+
+	for _, h := range m.Headers {
+		if err := h.Encode(core.NewBinarySignedHeaderEncoder(w)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *HeadersMessage) Decode(r io.Reader) error {
+	var count uint32
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if count > maxHeadersPerMessage {
+		return fmt.Errorf("too many headers in message: %d", count)
+	}
+
+	m.Headers = make([]*core.SignedHeader, count)
+
+	for i := range m.Headers {
+		m.Headers[i] = &core.SignedHeader{}
+
+		if err := m.Headers[i].Decode(core.NewBinarySignedHeaderDecoder(r)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ShortTxID identifies a transaction within one compact block; see
+// shortTxID.
+type ShortTxID [6]byte
+
+// CompactBlockMessage relays a block as its signed header and the short IDs
+// of its transactions, which the receiver looks up in its mempool.
+type CompactBlockMessage struct {
+	Header   *core.SignedHeader
+	ShortIDs []ShortTxID
+}
+
+// Encode writes the signed header, the short ID count and the short IDs.
+func (m *CompactBlockMessage) Encode(w io.Writer) error {
+	if err := m.Header.Encode(core.NewBinarySignedHeaderEncoder(w)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(m.ShortIDs))); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, m.ShortIDs)
+}
+
+func (m *CompactBlockMessage) Decode(r io.Reader) error {
+	m.Header = &core.SignedHeader{}
+
+	if err := m.Header.Decode(core.NewBinarySignedHeaderDecoder(r)); err != nil {
+		return err
+	}
+
+	var count uint32
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if count > core.MaxTxsPerBlock {
+		return fmt.Errorf("%w: %d short ids", core.MalformedEncodingError, count)
+	}
+
+	m.ShortIDs = make([]ShortTxID, count)
+
+	return binary.Read(r, binary.BigEndian, m.ShortIDs)
+}
+
+// GetBlockTxnMessage asks for the transactions of a compact block that were
+// not in the mempool, by their index in the block.
+type GetBlockTxnMessage struct {
+	BlockHash types.Hash
+	Indexes   []uint32
+}
+
+type BlockTxnMessage struct {
+	BlockHash    types.Hash
+	Transactions []*core.Transaction
+}
+
+// Encode writes the block hash, the transaction count and the canonical
+// encoding of each transaction.
+func (m *BlockTxnMessage) Encode(w io.Writer) error {
+	if _, err := w.Write(m.BlockHash[:]); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(m.Transactions))); err != nil {
+		return err
+	}
+
+	for _, tx := range m.Transactions {
+		if err := tx.Encode(core.NewBinaryTxEncoder(w)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *BlockTxnMessage) Decode(r io.Reader) error {
+	if _, err := io.ReadFull(r, m.BlockHash[:]); err != nil {
+		return err
+	}
+
+	var count uint32
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	if count > core.MaxTxsPerBlock {
+		return fmt.Errorf("%w: %d transactions", core.MalformedEncodingError, count)
+	}
+
+	m.Transactions = make([]*core.Transaction, count)
+
+	for i := range m.Transactions {
+		m.Transactions[i] = &core.Transaction{}
+
+		if err := m.Transactions[i].Decode(core.NewBinaryTxDecoder(r)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetBlocksMessage asks for the canonical blocks From to To, both inclusive.
+// To 0 means up to the tip.
 type GetBlocksMessage struct {
 	From uint32
 	To   uint32
@@ -26,6 +233,72 @@ type PongMessage struct {
 	RequestTime      time.Time
 }
 
+// HandshakeMessage is the first message each side sends on a new connection;
+// no other traffic is accepted from a peer until its handshake is valid.
+type HandshakeMessage struct {
+	ProtocolVersion uint32
+	ChainID         uint32
+	GenesisHash     types.Hash
+	NodeID          string
+	ListenAddress   string
+	Height          uint32
+	BestHash        types.Hash
+}
+
+type GetPeersMessage struct{}
+
+type PeersMessage struct {
+	Addresses []string
+}
+
+// FindNodeMessage asks a peer for the DHT contacts it knows closest to
+// Target; the answer is a NodesMessage carrying the same RequestID.
+type FindNodeMessage struct {
+	RequestID uint64
+	Target    PeerID
+}
+
+type NodesMessage struct {
+	RequestID uint64
+	Nodes     []Contact
+}
+
+type InvType byte
+
+const (
+	InvTypeTx InvType = iota + 0x1
+	InvTypeBlock
+)
+
+type InvItem struct {
+	Type InvType
+	Hash types.Hash
+}
+
+// InvMessage announces transactions and blocks by hash; a peer that lacks any
+// of them asks for them with a GetDataMessage.
+type InvMessage struct {
+	Items []InvItem
+}
+
+// GetDataMessage asks for announced items. Each is answered with a Tx or
+// Block message; unknown items are skipped.
+type GetDataMessage struct {
+	Items []InvItem
+}
+
+// GetMempoolMessage asks a peer, once per connection, for the hashes of its
+// pending transactions.
+type GetMempoolMessage struct{}
+
+// MempoolInvMessage is one page of the answer to GetMempoolMessage: the
+// hashes of up to maxInvPerMessage transactions, highest fee first. More is
+// set on every page but the last.
+type MempoolInvMessage struct {
+	Hashes []types.Hash
+	More   bool
+}
+
 type StatusMessage struct {
 	ID           string
 	ActualHeight uint32
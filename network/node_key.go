@@ -0,0 +1,203 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const nodeKeyFileName = "node.key"
+
+var (
+	InvalidNodeKeyError         = errors.New("invalid node key")
+	InvalidPeerCertificateError = errors.New("invalid peer certificate")
+)
+
+// PeerID identifies a node by the sha256 of its ed25519 node public key. It is
+// proven by the TLS handshake, so it cannot be claimed without the key.
+type PeerID [32]byte
+
+func PeerIDFromPublicKey(pub ed25519.PublicKey) PeerID {
+	return sha256.Sum256(pub)
+}
+
+func (id PeerID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id PeerID) IsZero() bool {
+	return id == PeerID{}
+}
+
+func (id PeerID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *PeerID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(id) {
+		return fmt.Errorf("invalid peer id length %d", len(text))
+	}
+
+	_, err := hex.Decode(id[:], text)
+
+	return err
+}
+
+// LoadOrCreateNodeKey reads the node key from dataDir, generating and storing
+// a new one on first start.
+func LoadOrCreateNodeKey(dataDir string) (ed25519.PrivateKey, error) {
+	path := filepath.Join(dataDir, nodeKeyFileName)
+	data, err := os.ReadFile(path)
+
+	if err == nil {
+		return parseNodeKey(data)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, key, errGenerate := ed25519.GenerateKey(rand.Reader)
+
+	if errGenerate != nil {
+		return nil, errGenerate
+	}
+
+	der, errMarshal := x509.MarshalPKCS8PrivateKey(key)
+
+	if errMarshal != nil {
+		return nil, errMarshal
+	}
+
+	if errMkdir := os.MkdirAll(dataDir, 0755); errMkdir != nil {
+		return nil, errMkdir
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if errWrite := os.WriteFile(path, encoded, 0600); errWrite != nil {
+		return nil, errWrite
+	}
+
+	return key, nil
+}
+
+func newNodeKey(dataDir string) (ed25519.PrivateKey, error) {
+	if len(dataDir) > 0 {
+		return LoadOrCreateNodeKey(dataDir)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	return key, err
+}
+
+func parseNodeKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, InvalidNodeKeyError
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", InvalidNodeKeyError, err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+
+	if !ok {
+		return nil, InvalidNodeKeyError
+	}
+
+	return edKey, nil
+}
+
+// newTLSConfig returns a TLS 1.3 config that presents a certificate
+// self-signed by the node key and requires the same from the other side.
+// There is no CA: a peer is authenticated by the key it proves to hold.
+func newTLSConfig(key ed25519.PrivateKey) (*tls.Config, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:            tls.VersionTLS13,
+		Certificates:          []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPeerCertificate,
+	}, nil
+}
+
+func verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	_, err := peerIDFromCertificates(rawCerts)
+
+	return err
+}
+
+// expectPeerID returns a copy of config that only accepts the peer proving
+// the key of id.
+func expectPeerID(config *tls.Config, id PeerID) *tls.Config {
+	config = config.Clone()
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		proven, err := peerIDFromCertificates(rawCerts)
+
+		if err != nil {
+			return err
+		}
+
+		if proven != id {
+			return fmt.Errorf("%w: expected %s, got %s", IncompatiblePeerError, id, proven)
+		}
+
+		return nil
+	}
+
+	return config
+}
+
+func peerIDFromCertificates(rawCerts [][]byte) (PeerID, error) {
+	if len(rawCerts) != 1 {
+		return PeerID{}, InvalidPeerCertificateError
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+
+	if err != nil {
+		return PeerID{}, fmt.Errorf("%w: %w", InvalidPeerCertificateError, err)
+	}
+
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+
+	if !ok {
+		return PeerID{}, InvalidPeerCertificateError
+	}
+
+	if errSig := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); errSig != nil {
+		return PeerID{}, fmt.Errorf("%w: %w", InvalidPeerCertificateError, errSig)
+	}
+
+	return PeerIDFromPublicKey(pub), nil
+}
@@ -0,0 +1,17 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoadOrCreateNodeKey(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := LoadOrCreateNodeKey(dir)
+	assert.Nil(t, err)
+
+	reloaded, errReload := LoadOrCreateNodeKey(dir)
+	assert.Nil(t, errReload)
+	assert.Equal(t, key, reloaded)
+}
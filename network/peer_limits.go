@@ -0,0 +1,160 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	defaultMaxInboundPeers   = 64
+	defaultMaxOutboundPeers  = 16
+	defaultMaxPeersPerIP     = 2
+	defaultMaxPeersPerSubnet = 8
+	// maxPendingHandshakes bounds the inbound connections still in the TLS
+	// handshake.
+	maxPendingHandshakes = 64
+)
+
+var PeerLimitError = errors.New("peer limit reached")
+
+// subnetOf returns the network group of addr that per-subnet limits apply to:
+// the /16 for IPv4 and the /32 for IPv6. Loopback and unparsable addresses
+// have none, so local test networks are not limited.
+func subnetOf(addr string) (ip string, subnet string, ok bool) {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return "", "", false
+	}
+
+	parsed := net.ParseIP(host)
+
+	if parsed == nil || parsed.IsLoopback() {
+		return "", "", false
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String(), v4.Mask(net.CIDRMask(16, 32)).String() + "/16", true
+	}
+
+	return parsed.String(), parsed.Mask(net.CIDRMask(32, 128)).String() + "/32", true
+}
+
+// checkAddrLimitsNoLock reports whether another peer from the IP and subnet
+// of addr fits in the per-IP and per-subnet limits.
+func (s *Server) checkAddrLimitsNoLock(addr net.Addr) error {
+	ip, subnet, ok := subnetOf(addr.String())
+
+	if !ok {
+		return nil
+	}
+
+	sameIP, sameSubnet := 0, 0
+
+	for other := range s.peerMap {
+		otherIP, otherSubnet, otherOk := subnetOf(other.String())
+
+		if !otherOk {
+			continue
+		}
+
+		if otherIP == ip {
+			sameIP++
+		}
+
+		if otherSubnet == subnet {
+			sameSubnet++
+		}
+	}
+
+	switch {
+	case sameIP >= s.so.MaxPeersPerIP:
+		return fmt.Errorf("%w: %d peers from %s", PeerLimitError, sameIP, ip)
+	case sameSubnet >= s.so.MaxPeersPerSubnet:
+		return fmt.Errorf("%w: %d peers from %s", PeerLimitError, sameSubnet, subnet)
+	}
+
+	return nil
+}
+
+// acceptConn is the transport's first filter for inbound connections, run
+// before the TLS handshake.
+func (s *Server) acceptConn(addr net.Addr) bool {
+	if s.isBanned(addr.String(), PeerID{}) {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.checkAddrLimitsNoLock(addr) == nil
+}
+
+// addPeer admits a connected peer into the peer map. A full inbound side makes
+// room by evicting its worst inbound peer.
+func (s *Server) addPeer(peerInfo *PeerInfo) error {
+	addr := peerInfo.Peer.conn.RemoteAddr()
+
+	s.mu.Lock()
+
+	if err := s.checkAddrLimitsNoLock(addr); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	var evict net.Addr
+
+	if !peerInfo.Outbound && s.countNoLock(false) >= s.so.MaxInboundPeers {
+		evict = s.worstInboundNoLock()
+
+		if evict == nil {
+			s.mu.Unlock()
+			return fmt.Errorf("%w: %d inbound peers", PeerLimitError, s.so.MaxInboundPeers)
+		}
+	}
+
+	s.peerMap[addr] = peerInfo
+	s.mu.Unlock()
+
+	if evict != nil {
+		_ = s.so.Logger.Log("msg", "evicting inbound peer", "addr", evict.String(), "for", addr.String())
+		s.removePeer(evict)
+	}
+
+	return nil
+}
+
+func (s *Server) countNoLock(outbound bool) int {
+	count := 0
+
+	for _, peerInfo := range s.peerMap {
+		if peerInfo.Outbound == outbound {
+			count++
+		}
+	}
+
+	return count
+}
+
+// worstInboundNoLock picks the inbound peer with the lowest score; among equal
+// scores the most recently connected one goes, so long-lived peers are kept.
+func (s *Server) worstInboundNoLock() net.Addr {
+	var (
+		worst     net.Addr
+		worstInfo *PeerInfo
+	)
+
+	for addr, peerInfo := range s.peerMap {
+		if peerInfo.Outbound {
+			continue
+		}
+
+		if worstInfo == nil || peerInfo.Score < worstInfo.Score ||
+			peerInfo.Score == worstInfo.Score && peerInfo.ConnectedAt.After(worstInfo.ConnectedAt) {
+			worst, worstInfo = addr, peerInfo
+		}
+	}
+
+	return worst
+}
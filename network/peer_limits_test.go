@@ -0,0 +1,81 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubnetOf(t *testing.T) {
+	ip, subnet, ok := subnetOf("10.1.2.3:3228")
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.2.3", ip)
+	assert.Equal(t, "10.1.0.0/16", subnet)
+
+	_, subnet, ok = subnetOf("[2001:db8:1:2::1]:3228")
+	assert.True(t, ok)
+	assert.Equal(t, "2001:db8::/32", subnet)
+
+	_, _, ok = subnetOf("127.0.0.1:3228")
+	assert.False(t, ok)
+}
+
+func TestServer_addPeerAddrLimits(t *testing.T) {
+	s := newTestServer(t)
+
+	for i := 1; i <= s.so.MaxPeersPerIP; i++ {
+		assert.Nil(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: pipeConn(t, i)}}))
+	}
+
+	assert.ErrorIs(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: pipeConn(t, 100)}}), PeerLimitError)
+	assert.False(t, s.acceptConn(pipeConn(t, 101).RemoteAddr()))
+
+	for i := 2; i < s.so.MaxPeersPerSubnet; i++ {
+		conn := pipeConnFrom(t, net.IPv4(10, 0, 0, byte(i)), 1)
+		assert.Nil(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: conn}}))
+	}
+
+	assert.True(t, s.acceptConn(pipeConnFrom(t, net.IPv4(10, 1, 0, 1), 1).RemoteAddr()))
+	assert.False(t, s.acceptConn(pipeConnFrom(t, net.IPv4(10, 0, 1, 1), 1).RemoteAddr()))
+
+	for i := 1; i <= 10; i++ {
+		conn := pipeConnFrom(t, net.IPv4(127, 0, 0, 1), i)
+		assert.Nil(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: conn}}))
+	}
+}
+
+func TestServer_addPeerEvictsWorstInbound(t *testing.T) {
+	s := newTestServer(t)
+	s.so.MaxInboundPeers = 3
+
+	now := time.Now()
+	conns := make([]net.Conn, 3)
+
+	for i := range conns {
+		conns[i] = pipeConnFrom(t, net.IPv4(10, byte(i), 0, 1), 1)
+
+		assert.Nil(t, s.addPeer(&PeerInfo{
+			Peer:        &TCPPeer{conn: conns[i]},
+			Score:       -i % 2 * 10,
+			ConnectedAt: now.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	outbound := pipeConnFrom(t, net.IPv4(10, 10, 0, 1), 1)
+	assert.Nil(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: outbound}, Outbound: true}))
+	assert.Len(t, s.peerMap, 4)
+
+	// conns[1] has the lowest score
+	newcomer := pipeConnFrom(t, net.IPv4(10, 20, 0, 1), 1)
+	assert.Nil(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: newcomer}, ConnectedAt: now.Add(time.Minute)}))
+	assert.Len(t, s.peerMap, 4)
+	assert.NotContains(t, s.peerMap, conns[1].RemoteAddr())
+
+	// scores are equal now, so the most recent connection goes
+	last := pipeConnFrom(t, net.IPv4(10, 30, 0, 1), 1)
+	assert.Nil(t, s.addPeer(&PeerInfo{Peer: &TCPPeer{conn: last}, ConnectedAt: now.Add(2 * time.Minute)}))
+	assert.NotContains(t, s.peerMap, newcomer.RemoteAddr())
+	assert.Contains(t, s.peerMap, conns[0].RemoteAddr())
+	assert.Contains(t, s.peerMap, outbound.RemoteAddr())
+}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"errors"
+	"github.com/Phanile/uretra_network/core"
+	"net"
+	"time"
+)
+
+// A peer starts with a score of zero and is banned once misbehavior takes it
+// to banScore or below.
+const (
+	banScore    = -100
+	banDuration = 24 * time.Hour
+)
+
+const (
+	penaltyInvalidBlock       = 100
+	penaltyOversizedMessage   = 100
+	penaltyProtocolViolation  = 50
+	penaltyDecodeFailure      = 25
+	penaltyInvalidTransaction = 20
+	penaltyUnsolicitedMessage = 20
+)
+
+var (
+	InvalidTransactionError = errors.New("invalid transaction")
+	UnsolicitedMessageError = errors.New("unsolicited message")
+)
+
+// penaltyFor maps an error caused by a peer's message to the penalty the peer
+// gets for it; errors that are no fault of the peer cost nothing.
+func penaltyFor(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, core.InvalidBlockError), errors.Is(err, InvalidHeaderError):
+		return penaltyInvalidBlock
+	case errors.Is(err, FrameTooLargeError):
+		return penaltyOversizedMessage
+	case errors.Is(err, HandshakeMissingError), errors.Is(err, TooManyItemsError), errors.Is(err, InvalidTxIndexError):
+		return penaltyProtocolViolation
+	case errors.Is(err, InvalidMagicError), errors.Is(err, ChecksumMismatchError), errors.Is(err, core.MalformedEncodingError):
+		return penaltyDecodeFailure
+	case errors.Is(err, InvalidTransactionError):
+		return penaltyInvalidTransaction
+	case errors.Is(err, UnsolicitedMessageError):
+		return penaltyUnsolicitedMessage
+	}
+
+	return 0
+}
+
+// penalize lowers the score of the peer at addr, banning and disconnecting it
+// once the score reaches banScore.
+func (s *Server) penalize(addr net.Addr, penalty int, reason error) {
+	if penalty <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[addr]
+
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	peerInfo.Score -= penalty
+	score := peerInfo.Score
+	id := peerInfo.Peer.ID
+	s.mu.Unlock()
+
+	_ = s.so.Logger.Log("msg", "peer misbehaved", "addr", addr.String(), "penalty", penalty, "score", score, "reason", reason)
+
+	if score > banScore {
+		return
+	}
+
+	s.banPeer(addr, id, reason)
+}
+
+// banPeer bans the peer's ID and IP and disconnects it. Loopback peers are
+// banned by ID only, so one bad local node does not cut off the others.
+func (s *Server) banPeer(addr net.Addr, id PeerID, reason error) {
+	if !id.IsZero() {
+		s.banList.Ban(id.String(), banDuration, reason.Error())
+	}
+
+	if host, ok := banHost(addr.String()); ok {
+		s.banList.Ban(host, banDuration, reason.Error())
+	}
+
+	if err := s.banList.Save(); err != nil {
+		_ = s.so.Logger.Log("error", "save ban list failed", "err", err)
+	}
+
+	_ = s.so.Logger.Log("msg", "peer banned", "addr", addr.String(), "id", id, "reason", reason)
+
+	s.removePeer(addr)
+}
+
+func (s *Server) isBanned(addr string, id PeerID) bool {
+	if !id.IsZero() && s.banList.IsBanned(id.String()) {
+		return true
+	}
+
+	host, ok := banHost(addr)
+
+	return ok && s.banList.IsBanned(host)
+}
+
+// banHost returns the IP of addr that bans apply to, if any.
+func banHost(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return "", false
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || ip.IsLoopback() {
+		return "", false
+	}
+
+	return ip.String(), true
+}
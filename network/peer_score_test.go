@@ -0,0 +1,54 @@
+package network
+
+import (
+	"fmt"
+	"github.com/Phanile/uretra_network/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPenaltyFor(t *testing.T) {
+	assert.Equal(t, 0, penaltyFor(nil))
+	assert.Equal(t, 0, penaltyFor(core.ParentUnknownError))
+	assert.Equal(t, penaltyInvalidBlock, penaltyFor(fmt.Errorf("%w: %w", core.InvalidBlockError, core.StateRootMismatchError)))
+	assert.Equal(t, penaltyOversizedMessage, penaltyFor(fmt.Errorf("%w: 1 bytes", FrameTooLargeError)))
+	assert.Equal(t, penaltyDecodeFailure, penaltyFor(ChecksumMismatchError))
+	assert.Equal(t, penaltyInvalidTransaction, penaltyFor(fmt.Errorf("%w: bad signature", InvalidTransactionError)))
+}
+
+func TestServer_penalize(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn, ID: testPeerID(b)}}
+
+	a.penalize(conn.RemoteAddr(), penaltyInvalidTransaction, InvalidTransactionError)
+	assert.Equal(t, -penaltyInvalidTransaction, a.peerMap[conn.RemoteAddr()].Score)
+	assert.False(t, a.isBanned(conn.RemoteAddr().String(), testPeerID(b)))
+
+	a.penalize(conn.RemoteAddr(), penaltyInvalidBlock, core.InvalidBlockError)
+
+	_, connected := a.peerMap[conn.RemoteAddr()]
+	assert.False(t, connected)
+	assert.True(t, a.isBanned(conn.RemoteAddr().String(), PeerID{}))
+	assert.True(t, a.isBanned("127.0.0.1:3000", testPeerID(b)))
+	assert.False(t, a.isBanned("127.0.0.1:3000", PeerID{}))
+}
+
+func TestServer_processBlocksMessageUnsolicited(t *testing.T) {
+	a := newTestServer(t)
+	b := newTestServer(t)
+
+	conn := pipeConn(t, 1)
+	a.peerMap[conn.RemoteAddr()] = &PeerInfo{Peer: &TCPPeer{conn: conn, ID: testPeerID(b)}}
+
+	assert.ErrorIs(t, a.processBlocksMessage(conn.RemoteAddr(), &BlocksMessage{}), UnsolicitedMessageError)
+
+	// a peer ahead of us is asked for headers first, so its blocks are still
+	// unsolicited
+	assert.Nil(t, a.processStatusMessage(conn.RemoteAddr(), &StatusMessage{ActualHeight: 10}))
+	assert.ErrorIs(t, a.processBlocksMessage(conn.RemoteAddr(), &BlocksMessage{}), UnsolicitedMessageError)
+	assert.Nil(t, a.processHeadersMessage(conn.RemoteAddr(), &HeadersMessage{}))
+	assert.ErrorIs(t, a.processHeadersMessage(conn.RemoteAddr(), &HeadersMessage{}), UnsolicitedMessageError)
+}
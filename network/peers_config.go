@@ -6,10 +6,17 @@ import (
 	"os"
 )
 
-var configPath string = getConfigPath()
+var (
+	configPath string
+
+	configPathFlag = flag.String("config", "", "config file path")
+	dataDirFlag    = flag.String("datadir", "", "blockchain data directory")
+)
 
 type PeersConfig struct {
 	Peers []string `json:"peers"`
+	// PersistentPeers are kept connected like seeds, see ConnManager.
+	PersistentPeers []string `json:"persistentPeers,omitempty"`
 }
 
 func AddPeerToConfig(peerAddr string) {
@@ -56,7 +63,7 @@ func RemovePeerFromConfig(peerAddr string) {
 }
 
 func GetConfig() (*PeersConfig, error) {
-	data, err := os.ReadFile(configPath)
+	data, err := os.ReadFile(getConfigPath())
 
 	if err != nil {
 		panic(err)
@@ -74,18 +81,43 @@ func GetConfig() (*PeersConfig, error) {
 }
 
 func getConfigPath() string {
-	var result string
+	if configPath != "" {
+		return configPath
+	}
+
+	parseFlags()
+
+	configPath = *configPathFlag
+
+	if configPath == "" {
+		configPath = os.Getenv("CONFIG_PATH")
+	}
+
+	return configPath
+}
+
+func getDataDir() string {
+	parseFlags()
 
-	flag.StringVar(&result, "config", "", "config file path")
-	flag.Parse()
+	result := *dataDirFlag
+
+	if result == "" {
+		result = os.Getenv("DATA_DIR")
+	}
 
 	if result == "" {
-		result = os.Getenv("CONFIG_PATH")
+		result = defaultDataDir
 	}
 
 	return result
 }
 
+func parseFlags() {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+}
+
 func SaveConfig(conf *PeersConfig) {
 	data, err := json.MarshalIndent(conf, "", "  ")
 
@@ -93,7 +125,7 @@ func SaveConfig(conf *PeersConfig) {
 		panic(err)
 	}
 
-	errWrite := os.WriteFile(configPath, data, 0644)
+	errWrite := os.WriteFile(getConfigPath(), data, 0644)
 
 	if errWrite != nil {
 		panic(errWrite)
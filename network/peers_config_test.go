@@ -3,10 +3,14 @@ package network
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestPeersConfig_Get(t *testing.T) {
+	useTempConfig(t)
+
 	config, err := GetConfig()
 	fmt.Println(config)
 	assert.Nil(t, err)
@@ -14,6 +18,8 @@ func TestPeersConfig_Get(t *testing.T) {
 }
 
 func TestPeersConfig_Save(t *testing.T) {
+	useTempConfig(t)
+
 	config, err := GetConfig()
 	fmt.Println(config)
 	assert.Nil(t, err)
@@ -25,3 +31,15 @@ func TestPeersConfig_Save(t *testing.T) {
 
 	SaveConfig(&updatedConfig)
 }
+
+func useTempConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"peers": ["192.168.3.2:3228"]}`), 0644))
+
+	prev := configPath
+	configPath = path
+
+	t.Cleanup(func() {
+		configPath = prev
+	})
+}
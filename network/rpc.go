@@ -20,6 +20,20 @@ const (
 	MessageTypeBlocks
 	MessageTypePing
 	MessageTypePong
+	MessageTypeHandshake
+	MessageTypeGetPeers
+	MessageTypePeers
+	MessageTypeFindNode
+	MessageTypeNodes
+	MessageTypeGetHeaders
+	MessageTypeHeaders
+	MessageTypeInv
+	MessageTypeGetData
+	MessageTypeCompactBlock
+	MessageTypeGetBlockTxn
+	MessageTypeBlockTxn
+	MessageTypeGetMempool
+	MessageTypeMempoolInv
 )
 
 type RPC struct {
@@ -46,29 +60,22 @@ func NewMessage(t MessageType, data []byte) *Message {
 	}
 }
 
+// Bytes returns the message as a wire frame.
 func (m *Message) Bytes() ([]byte, error) {
-	buf := &bytes.Buffer{}
-	err := gob.NewEncoder(buf).Encode(m)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
+	return encodeFrame(m.Header, m.Data)
 }
 
 func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
-	msg := &Message{}
-	err := gob.NewDecoder(rpc.Payload).Decode(msg)
+	msg, err := ReadMessage(rpc.Payload)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode RPC payload: %s", rpc.Payload)
+		return nil, fmt.Errorf("failed to decode RPC payload: %w", err)
 	}
 
 	switch msg.Header {
 	case MessageTypeTx:
 		tx := &core.Transaction{}
-		err := tx.Decode(core.NewGobTxDecoder(bytes.NewReader(msg.Data)))
+		err := tx.Decode(core.NewBinaryTxDecoder(bytes.NewReader(msg.Data)))
 
 		if err != nil {
 			return nil, err
@@ -81,7 +88,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeBlock:
 		b := &core.Block{}
-		err := b.Decode(core.NewGobBlockDecoder(bytes.NewReader(msg.Data)))
+		err := b.Decode(core.NewBinaryBlockDecoder(bytes.NewReader(msg.Data)))
 
 		if err != nil {
 			return nil, err
@@ -128,7 +135,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeBlocks:
 		blocksMsg := &BlocksMessage{}
-		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(blocksMsg)
+		err := blocksMsg.Decode(bytes.NewReader(msg.Data))
 
 		if err != nil {
 			return nil, err
@@ -167,6 +174,183 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: pongMsg,
 		}, nil
 
+	case MessageTypeHandshake:
+		handshakeMsg := &HandshakeMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(handshakeMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: handshakeMsg,
+		}, nil
+
+	case MessageTypeGetPeers:
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: &GetPeersMessage{},
+		}, nil
+
+	case MessageTypePeers:
+		peersMsg := &PeersMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(peersMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: peersMsg,
+		}, nil
+
+	case MessageTypeFindNode:
+		findNodeMsg := &FindNodeMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(findNodeMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: findNodeMsg,
+		}, nil
+
+	case MessageTypeNodes:
+		nodesMsg := &NodesMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(nodesMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: nodesMsg,
+		}, nil
+
+	case MessageTypeGetHeaders:
+		getHeadersMsg := &GetHeadersMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getHeadersMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getHeadersMsg,
+		}, nil
+
+	case MessageTypeHeaders:
+		headersMsg := &HeadersMessage{}
+		err := headersMsg.Decode(bytes.NewReader(msg.Data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: headersMsg,
+		}, nil
+
+	case MessageTypeInv:
+		invMsg := &InvMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(invMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: invMsg,
+		}, nil
+
+	case MessageTypeGetData:
+		getDataMsg := &GetDataMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getDataMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getDataMsg,
+		}, nil
+
+	case MessageTypeCompactBlock:
+		compactMsg := &CompactBlockMessage{}
+		err := compactMsg.Decode(bytes.NewReader(msg.Data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: compactMsg,
+		}, nil
+
+	case MessageTypeGetBlockTxn:
+		getBlockTxnMsg := &GetBlockTxnMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlockTxnMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getBlockTxnMsg,
+		}, nil
+
+	case MessageTypeBlockTxn:
+		blockTxnMsg := &BlockTxnMessage{}
+		err := blockTxnMsg.Decode(bytes.NewReader(msg.Data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: blockTxnMsg,
+		}, nil
+
+	case MessageTypeGetMempool:
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: &GetMempoolMessage{},
+		}, nil
+
+	case MessageTypeMempoolInv:
+		mempoolInvMsg := &MempoolInvMessage{}
+
+		err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(mempoolInvMsg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: mempoolInvMsg,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("invalid message type %x", msg.Header)
 	}
@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -10,8 +11,11 @@ import (
 	"github.com/Phanile/uretra_network/crypto"
 	"github.com/Phanile/uretra_network/types"
 	"github.com/go-kit/log"
+	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
@@ -19,40 +23,100 @@ import (
 const (
 	defaultListenPort    = ":3228"
 	defaultAPIListenPort = ":3229"
+	defaultDataDir       = "./chaindata"
 )
 
 const (
-	defaultBlockTime              = 10
-	defaultPingPeersTime          = 10
-	maxTransactionsCountInMemPool = 5
+	defaultBlockTime     = 10
+	defaultPingPeersTime = 10
+	// txExpiryInterval is how often transactions past their lifetime are
+	// dropped from the mempool.
+	txExpiryInterval = time.Minute
+	// defaultMaxNonceGap matches defaultMaxSenderTxs, as the pool holds no
+	// more of a sender's transactions anyway.
+	defaultMaxNonceGap = 64
 )
 
-const blockReduction = 210000
-
 type ServerOptions struct {
 	SeedNodes        []string
 	ListenAddress    string
 	APIListenAddress string
-	ID               string
-	Logger           log.Logger
-	RPCDecodeFunc    RPCDecodeFunc
-	RPCProcessor     RPCProcessor
-	PrivateKey       *crypto.PrivateKey
-	PeersConfig      *PeersConfig
+	// ID is the peer ID of NodeKey, set by NewServer.
+	ID            string
+	Logger        log.Logger
+	RPCDecodeFunc RPCDecodeFunc
+	RPCProcessor  RPCProcessor
+	PrivateKey    *crypto.PrivateKey
+	PeersConfig   *PeersConfig
+	DataDir       string
+	ChainID       uint32
+	// NodeKey authenticates peer connections. It is loaded from or created in
+	// DataDir when unset, or generated for this run without a DataDir.
+	NodeKey ed25519.PrivateKey
+	// TargetOutboundPeers is the number of outbound connections the discovery
+	// loop maintains.
+	TargetOutboundPeers int
+	MaxInboundPeers     int
+	MaxOutboundPeers    int
+	// MaxPeersPerIP and MaxPeersPerSubnet limit how many peers may share an
+	// IP or a /16 (IPv4) or /32 (IPv6) subnet. Loopback peers are exempt.
+	MaxPeersPerIP     int
+	MaxPeersPerSubnet int
+	// PersistentPeers are, like SeedNodes, redialed whenever they drop.
+	PersistentPeers []string
+	// Transport defaults to TLS over TCP on ListenAddress.
+	Transport Transport
+	// Clock drives the server's loops and timeouts; the real clock by default.
+	Clock Clock
+	// Rand drives the server's random choices, such as redial jitter and
+	// which peers to ask for addresses. It is seeded from the time by
+	// default.
+	Rand rand.Source
+	// TxRelayRate is how many transactions per second, after a burst of
+	// TxRelayBurst, a peer may send before the rest are dropped.
+	TxRelayRate  float64
+	TxRelayBurst int
+	// The mempool limits and transaction lifetime; see TxPoolOptions.
+	MaxPoolTxs     int
+	MaxPoolBytes   int
+	MaxSenderTxs   int
+	MaxSenderBytes int
+	TxLifetime     time.Duration
+	MinFeeBump     uint64
+	MinRelayFee    uint64
+	// MaxNonceGap is how far ahead of the sender's next nonce on the chain a
+	// transaction's nonce may be for it to be pooled.
+	MaxNonceGap uint64
 }
 
 type Server struct {
-	TCPTransport *TCPTransport
-	peerCh       chan *TCPPeer
-	mu           sync.RWMutex
-	peerMap      map[net.Addr]*PeerInfo
-	so           *ServerOptions
-	memPool      *TxSortedMap
-	isValidator  bool
-	chain        *core.Blockchain
-	rpcChannel   chan RPC
-	quitChannel  chan struct{}
-	txChannel    chan *core.Transaction
+	Transport   Transport
+	clock       Clock
+	rand        *rand.Rand
+	peerCh      chan *TCPPeer
+	delPeerCh   chan *TCPPeer
+	mu          sync.RWMutex
+	peerMap     map[net.Addr]*PeerInfo
+	addrBook    *AddressBook
+	banList     *BanList
+	dialing     map[string]bool
+	connManager *ConnManager
+	dht         *DHT
+	sync        *SyncManager
+	inflight    map[types.Hash]time.Time
+	partials    map[types.Hash]*partialBlock
+	seen        *seenCache
+	gossip      gossipCounters
+	requests    map[uint64]*findNodeRequest
+	nextRequest uint64
+	so          *ServerOptions
+	memPool     *TxPool
+	isValidator bool
+	chain       *core.Blockchain
+	rpcChannel  chan RPC
+	quitChannel chan struct{}
+	stopOnce    sync.Once
+	txChannel   chan api.TxRequest
 }
 
 func MakeServer() *Server {
@@ -68,18 +132,16 @@ func MakeServer() *Server {
 		panic("node is out network")
 	}
 
-	nodeId := "node_" + ip + defaultListenPort
-	AddPeerToConfig(ip + defaultListenPort)
-
 	privateKey := crypto.GeneratePrivateKey()
 
 	opts := ServerOptions{
 		APIListenAddress: ip + defaultAPIListenPort,
 		PrivateKey:       &privateKey,
-		ID:               nodeId,
 		SeedNodes:        conf.Peers,
 		ListenAddress:    ip + defaultListenPort,
 		PeersConfig:      conf,
+		PersistentPeers:  conf.PersistentPeers,
+		DataDir:          getDataDir(),
 	}
 
 	s, err := NewServer(&opts)
@@ -96,37 +158,190 @@ func NewServer(opts *ServerOptions) (*Server, error) {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
 
+	if opts.NodeKey == nil {
+		nodeKey, err := newNodeKey(opts.DataDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.NodeKey = nodeKey
+	}
+
+	opts.ID = PeerIDFromPublicKey(opts.NodeKey.Public().(ed25519.PublicKey)).String()
+
 	if opts.Logger == nil {
 		opts.Logger = log.NewLogfmtLogger(os.Stderr)
 		opts.Logger = log.With(opts.Logger, "ID", opts.ID)
 	}
 
-	chain := core.NewBlockchain(opts.Logger, genesisBlock(*opts.PrivateKey))
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
 
-	peerCh := make(chan *TCPPeer)
-	tr := NewTCPTransport(opts.ListenAddress, peerCh)
+	if opts.Rand == nil {
+		opts.Rand = rand.NewSource(time.Now().UnixNano())
+	}
 
-	s := &Server{
-		TCPTransport: tr,
-		peerCh:       peerCh,
-		peerMap:      make(map[net.Addr]*PeerInfo),
-		so:           opts,
-		memPool:      NewTxSortedMap(),
-		chain:        chain,
-		isValidator:  opts.PrivateKey != nil,
-		rpcChannel:   make(chan RPC),
-		quitChannel:  make(chan struct{}, 1),
-		txChannel:    make(chan *core.Transaction),
+	if opts.MaxInboundPeers == 0 {
+		opts.MaxInboundPeers = defaultMaxInboundPeers
 	}
 
-	s.TCPTransport.peerCh = peerCh
+	if opts.MaxOutboundPeers == 0 {
+		opts.MaxOutboundPeers = defaultMaxOutboundPeers
+	}
 
-	if opts.RPCProcessor == nil {
-		opts.RPCProcessor = s
+	if opts.MaxPeersPerIP == 0 {
+		opts.MaxPeersPerIP = defaultMaxPeersPerIP
 	}
 
-	if s.isValidator {
-		go s.createBlockLoop()
+	if opts.MaxPeersPerSubnet == 0 {
+		opts.MaxPeersPerSubnet = defaultMaxPeersPerSubnet
+	}
+
+	if opts.TargetOutboundPeers == 0 {
+		opts.TargetOutboundPeers = defaultTargetOutboundPeers
+	}
+
+	if opts.TxRelayRate == 0 {
+		opts.TxRelayRate = defaultTxRelayRate
+	}
+
+	if opts.TxRelayBurst == 0 {
+		opts.TxRelayBurst = defaultTxRelayBurst
+	}
+
+	if opts.MaxNonceGap == 0 {
+		opts.MaxNonceGap = defaultMaxNonceGap
+	}
+
+	opts.TargetOutboundPeers = min(opts.TargetOutboundPeers, opts.MaxOutboundPeers)
+
+	addrBookPath, banListPath := "", ""
+
+	if len(opts.DataDir) > 0 {
+		addrBookPath = filepath.Join(opts.DataDir, addressBookFileName)
+		banListPath = filepath.Join(opts.DataDir, banListFileName)
+	}
+
+	rng := rand.New(&lockedSource{src: opts.Rand})
+	addrBook, errAddrBook := NewAddressBook(addrBookPath, opts.Clock, rng)
+
+	if errAddrBook != nil {
+		return nil, errAddrBook
+	}
+
+	banList, errBanList := NewBanList(banListPath, opts.Clock)
+
+	if errBanList != nil {
+		return nil, errBanList
+	}
+
+	var persistent []string
+
+	for _, addr := range slices.Concat(opts.SeedNodes, opts.PersistentPeers) {
+		if addr != opts.ListenAddress {
+			addrBook.Add(addr, AddressSourceSeed)
+			persistent = append(persistent, addr)
+		}
+	}
+
+	var store core.Storage = core.NewMemoryStorage()
+
+	if len(opts.DataDir) > 0 {
+		diskStore, err := core.NewDiskStorage(opts.DataDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		store = diskStore
+	}
+
+	tr := opts.Transport
+
+	if tr == nil {
+		secure, errTransport := NewSecureTCPTransport(opts.ListenAddress, make(chan *TCPPeer), opts.NodeKey)
+
+		if errTransport != nil {
+			_ = store.Close()
+			return nil, errTransport
+		}
+
+		tr = secure
+	}
+
+	// an evicted or expired transaction may be sent again once there is room
+	// for it
+	seen := newSeenCache(seenCacheSize)
+
+	s := &Server{
+		Transport:   tr,
+		clock:       opts.Clock,
+		rand:        rng,
+		peerCh:      tr.Peers(),
+		delPeerCh:   make(chan *TCPPeer),
+		peerMap:     make(map[net.Addr]*PeerInfo),
+		addrBook:    addrBook,
+		banList:     banList,
+		dialing:     make(map[string]bool),
+		connManager: NewConnManager(persistent, rng),
+		requests:    make(map[uint64]*findNodeRequest),
+		inflight:    make(map[types.Hash]time.Time),
+		partials:    make(map[types.Hash]*partialBlock),
+		seen:        seen,
+		so:          opts,
+		memPool: NewTxPool(TxPoolOptions{
+			MaxTxs:         opts.MaxPoolTxs,
+			MaxBytes:       opts.MaxPoolBytes,
+			MaxSenderTxs:   opts.MaxSenderTxs,
+			MaxSenderBytes: opts.MaxSenderBytes,
+			Lifetime:       opts.TxLifetime,
+			MinFeeBump:     opts.MinFeeBump,
+			MinRelayFee:    opts.MinRelayFee,
+			Now:            opts.Clock.Now,
+			OnEvict:        seen.Remove,
+		}),
+		isValidator: opts.PrivateKey != nil,
+		rpcChannel:  make(chan RPC),
+		quitChannel: make(chan struct{}),
+		txChannel:   make(chan api.TxRequest),
+	}
+
+	chain, err := core.NewBlockchainWithOptions(core.BlockchainOptions{
+		Logger:  opts.Logger,
+		ChainID: opts.ChainID,
+		Store:   store,
+		OnReorg: s.returnOrphanedTransactions,
+	}, genesisBlock(*opts.PrivateKey))
+
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	s.chain = chain
+	s.dht = NewDHT(Contact{
+		ID:      PeerIDFromPublicKey(opts.NodeKey.Public().(ed25519.PublicKey)),
+		Address: opts.ListenAddress,
+	}, s.findNode, s.pingContact, s.clock, s.rand)
+
+	s.sync = NewSyncManager(SyncOptions{
+		Chain:          chain,
+		Logger:         opts.Logger,
+		Now:            s.clock.Now,
+		RequestHeaders: s.requestHeaders,
+		RequestBlocks:  s.requestBlocks,
+		OnBlock:        s.removeIncludedTransactions,
+		OnInvalidBlock: func(peer net.Addr, err error) {
+			s.penalize(peer, penaltyFor(err), err)
+		},
+	})
+
+	s.Transport.SetAcceptFilter(s.acceptConn)
+
+	if opts.RPCProcessor == nil {
+		opts.RPCProcessor = s
 	}
 
 	if len(s.so.APIListenAddress) > 0 {
@@ -145,97 +360,155 @@ func NewServer(opts *ServerOptions) (*Server, error) {
 	return s, nil
 }
 
+// Start runs the server until Stop. It is started held on the clock, and the
+// loop releases before it waits for the next event.
 func (s *Server) Start() {
-	_ = s.TCPTransport.Start()
-	s.boostrapPeers()
-	go s.sendPingMessages()
+	_ = s.Transport.Start()
+
+	// the tickers are made before the loops run, so the clock counts the
+	// loops as busy until they wait for their first tick
+	go s.reconnectLoop(s.clock.NewTicker(reconnectCheckInterval))
+	go s.sendPingMessages(s.clock.NewTicker(time.Second * defaultPingPeersTime))
+	go s.discoveryLoop(s.clock.NewTicker(discoveryInterval))
+	go s.dhtLoop(s.clock.NewTicker(dhtCheckInterval))
+	go s.syncLoop(s.clock.NewTicker(syncTickInterval))
+	go s.mempoolLoop(s.clock.NewTicker(txExpiryInterval))
+
+	if s.isValidator {
+		go s.createBlockLoop(s.clock.NewTicker(time.Second * defaultBlockTime))
+	}
 
 free:
 	for {
+		s.clock.Release()
+
 		select {
 		case peer := <-s.peerCh:
+			if s.isBanned(peer.conn.RemoteAddr().String(), peer.ID) {
+				_ = s.so.Logger.Log("msg", "rejected banned peer", "addr", peer.conn.RemoteAddr().String())
+				_ = peer.conn.Close()
+				continue
+			}
+
 			peerInfo := &PeerInfo{
-				Peer: peer,
+				Peer:        peer,
+				Outbound:    peer.Outgoing,
+				ConnectedAt: s.clock.Now(),
 			}
-			s.peerMap[peer.conn.RemoteAddr()] = peerInfo
 
-			AddPeerToConfig(peer.conn.RemoteAddr().String())
+			if err := s.addPeer(peerInfo); err != nil {
+				_ = s.so.Logger.Log("msg", "rejected peer", "addr", peer.conn.RemoteAddr().String(), "err", err)
+				_ = peer.conn.Close()
+				s.connManager.Disconnected(peer.dialAddr, s.clock.Now())
+				continue
+			}
 
 			_ = s.so.Logger.Log("msg", "added new peer: ", peer.conn.RemoteAddr().String())
 
-			go peer.readLoop(s.rpcChannel)
+			s.clock.Hold()
+			go peer.readLoop(s.clock, s.rpcChannel, s.delPeerCh)
+
+			s.startHandshake(peer)
+
+		case peer := <-s.delPeerCh:
+			s.penalize(peer.conn.RemoteAddr(), penaltyFor(peer.readErr), peer.readErr)
+			s.removePeer(peer.conn.RemoteAddr())
 
-			s.sendGetStatusMessage(peer)
+			_ = s.so.Logger.Log("msg", "peer disconnected", "addr", peer.conn.RemoteAddr().String())
 
 		case rpc := <-s.rpcChannel:
 			msg, err := s.so.RPCDecodeFunc(rpc)
 
 			if err != nil {
-				_ = s.so.Logger.Log("error", "cannot decode rpc")
+				_ = s.so.Logger.Log("error", "cannot decode rpc", "err", err)
+				s.penalize(rpc.From, penaltyDecodeFailure, err)
+				continue
+			}
+
+			if _, ok := msg.Data.(*HandshakeMessage); !ok && !s.isHandshaked(msg.From) {
+				_ = s.so.Logger.Log("error", HandshakeMissingError, "addr", msg.From.String())
+				s.penalize(msg.From, penaltyProtocolViolation, HandshakeMissingError)
+				s.removePeer(msg.From)
 				continue
 			}
 
 			errMessage := s.so.RPCProcessor.ProcessMessage(msg)
 
 			if errMessage != nil {
-				_ = s.so.Logger.Log("error", "cannot process message from rpc channel")
+				_ = s.so.Logger.Log("error", "cannot process message from rpc channel", "err", errMessage)
+				s.penalize(msg.From, penaltyFor(errMessage), errMessage)
 			}
 
-		case tx := <-s.txChannel:
-			err := s.processTransaction(tx)
-
-			if err != nil {
-				fmt.Println("process transaction error")
-			}
+		case req := <-s.txChannel:
+			req.Err <- s.addTransaction(nil, req.Tx)
 
 		case <-s.quitChannel:
 			break free
 		}
 	}
 
-	_ = s.so.Logger.Log("msg", "Server shutdown")
-}
-
-func (s *Server) boostrapPeers() {
-	for _, addr := range s.so.SeedNodes {
-		if addr == s.so.ListenAddress {
-			continue
-		}
+	_ = s.Transport.Close()
 
-		go func(addr string) {
-			dial, err := net.Dial("tcp", addr)
+	if err := s.addrBook.Save(); err != nil {
+		_ = s.so.Logger.Log("error", "save address book failed", "err", err)
+	}
 
-			if err != nil {
-				fmt.Println("peer ", addr, " is dead")
-				RemovePeerFromConfig(addr)
-				return
-			}
+	if err := s.banList.Save(); err != nil {
+		_ = s.so.Logger.Log("error", "save ban list failed", "err", err)
+	}
 
-			s.peerCh <- &TCPPeer{
-				conn: dial,
-			}
-		}(addr)
+	if err := s.chain.Store.Close(); err != nil {
+		_ = s.so.Logger.Log("error", "close storage failed", "err", err)
 	}
+
+	_ = s.so.Logger.Log("msg", "Server shutdown")
+}
+
+// Stop shuts the server loops down.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.quitChannel)
+	})
 }
 
 func (s *Server) removePeer(addr net.Addr) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	peerInfo, ok := s.peerMap[addr]
 
 	if !ok {
+		s.mu.Unlock()
 		return
 	}
 
 	_ = peerInfo.Peer.conn.Close()
 	delete(s.peerMap, addr)
-	RemovePeerFromConfig(peerInfo.Peer.conn.RemoteAddr().String())
+	s.mu.Unlock()
+
+	s.connManager.Disconnected(peerInfo.Peer.dialAddr, s.clock.Now())
+
+	if len(peerInfo.ListenAddress) > 0 {
+		s.connManager.Disconnected(peerInfo.ListenAddress, s.clock.Now())
+	}
+
+	s.sync.RemovePeer(addr)
 }
 
-func (s *Server) createBlockLoop() {
-	ticker := time.NewTicker(time.Second * defaultBlockTime)
+func (s *Server) mempoolLoop(ticker Ticker) {
+	defer ticker.Stop()
 
+	for {
+		select {
+		case <-ticker.C():
+			if expired := s.memPool.Expire(); expired > 0 {
+				_ = s.so.Logger.Log("msg", "expired mempool transactions", "count", expired)
+			}
+		case <-s.quitChannel:
+			return
+		}
+	}
+}
+
+func (s *Server) createBlockLoop(ticker Ticker) {
 	for {
 		if s.memPool.Count() >= 1 {
 			err := s.createNewBlock()
@@ -247,36 +520,33 @@ func (s *Server) createBlockLoop() {
 			s.so.Logger.Log("msg", "no transactions in memPool to create new block")
 		}
 
-		<-ticker.C
-	}
-}
-
-func (s *Server) sendGetStatusMessage(peer *TCPPeer) {
-	getStatusMsg := &GetStatusMessage{}
-
-	buf := &bytes.Buffer{}
-	err := gob.NewEncoder(buf).Encode(getStatusMsg)
-
-	if err != nil {
-		_ = s.so.Logger.Log("error", "encode get status message failed")
-		return
+		select {
+		case <-ticker.C():
+		case <-s.quitChannel:
+			ticker.Stop()
+			return
+		}
 	}
-
-	msg := NewMessage(MessageTypeGetStatus, buf.Bytes())
-	msgData, _ := msg.Bytes()
-
-	_ = peer.Send(msgData)
 }
 
-func (s *Server) sendPingMessages() {
-	ticker := time.NewTicker(time.Second * defaultPingPeersTime)
-
+func (s *Server) sendPingMessages(ticker Ticker) {
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+			s.mu.RLock()
+			peers := make([]*PeerInfo, 0, len(s.peerMap))
+
 			for _, peerInfo := range s.peerMap {
+				if peerInfo.Handshaked {
+					peers = append(peers, peerInfo)
+				}
+			}
+
+			s.mu.RUnlock()
+
+			for _, peerInfo := range peers {
 				pingMsg := &PingMessage{
-					RequestTime: time.Now(),
+					RequestTime: s.clock.Now(),
 				}
 
 				buf := &bytes.Buffer{}
@@ -290,34 +560,22 @@ func (s *Server) sendPingMessages() {
 				if err != nil {
 					s.removePeer(peerInfo.Peer.conn.RemoteAddr())
 					fmt.Println("peer", peerInfo.Peer.conn.RemoteAddr().String(), "is dead")
-					return
 				}
 			}
-		}
-	}
-}
 
-func (s *Server) broadcast(payload []byte) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	for _, peerInfo := range s.peerMap {
-		err := peerInfo.Peer.Send(payload)
-
-		if err != nil {
-			return err
+		case <-s.quitChannel:
+			ticker.Stop()
+			return
 		}
 	}
-
-	return nil
 }
 
 func (s *Server) ProcessMessage(m *DecodedMessage) error {
 	switch data := m.Data.(type) {
 	case *core.Transaction:
-		return s.processTransaction(data)
+		return s.processTransaction(m.From, data)
 	case *core.Block:
-		return s.processBlock(data)
+		return s.processBlock(m.From, data)
 	case *GetStatusMessage:
 		return s.processGetStatusMessage(m.From)
 	case *StatusMessage:
@@ -326,43 +584,168 @@ func (s *Server) ProcessMessage(m *DecodedMessage) error {
 		return s.processGetBlocksMessage(m.From, data)
 	case *BlocksMessage:
 		return s.processBlocksMessage(m.From, data)
+	case *GetHeadersMessage:
+		return s.processGetHeadersMessage(m.From, data)
+	case *HeadersMessage:
+		return s.processHeadersMessage(m.From, data)
+	case *InvMessage:
+		return s.processInvMessage(m.From, data)
+	case *GetDataMessage:
+		return s.processGetDataMessage(m.From, data)
+	case *GetMempoolMessage:
+		return s.processGetMempoolMessage(m.From)
+	case *MempoolInvMessage:
+		return s.processMempoolInvMessage(m.From, data)
+	case *CompactBlockMessage:
+		return s.processCompactBlockMessage(m.From, data)
+	case *GetBlockTxnMessage:
+		return s.processGetBlockTxnMessage(m.From, data)
+	case *BlockTxnMessage:
+		return s.processBlockTxnMessage(m.From, data)
 	case *PingMessage:
 		return s.processPingMessage(m.From, data)
 	case *PongMessage:
 		return s.processPongMessage(m.From, data)
+	case *HandshakeMessage:
+		return s.processHandshakeMessage(m.From, data)
+	case *GetPeersMessage:
+		return s.processGetPeersMessage(m.From)
+	case *PeersMessage:
+		return s.processPeersMessage(m.From, data)
+	case *FindNodeMessage:
+		return s.processFindNodeMessage(m.From, data)
+	case *NodesMessage:
+		return s.processNodesMessage(m.From, data)
 	}
 	return nil
 }
 
-func (s *Server) processTransaction(transaction *core.Transaction) error {
+// processTransaction handles a transaction relayed by the peer at from. A
+// transaction dropped for no fault of the peer is not an error.
+func (s *Server) processTransaction(from net.Addr, transaction *core.Transaction) error {
+	err := s.addTransaction(from, transaction)
+
+	if err != nil && penaltyFor(err) == 0 {
+		_ = s.so.Logger.Log("msg", "dropping transaction", "hash", transaction.Hash(core.TxHasher{}), "reason", err)
+		return nil
+	}
+
+	return err
+}
+
+// addTransaction checks a transaction against the chain state before it
+// enters the mempool and is announced, and returns why it did not otherwise.
+// from is nil for local transactions.
+func (s *Server) addTransaction(from net.Addr, transaction *core.Transaction) error {
 	hash := transaction.Hash(core.TxHasher{})
 	_ = s.so.Logger.Log("msg", "receive new transaction", "hash", hash, "current mempool length", s.memPool.Count())
 
-	if s.memPool.Contains(hash) {
-		return nil
+	requested := s.received(from, hash)
+
+	if s.seen.Contains(hash) || s.memPool.Contains(hash) {
+		s.gossip.duplicateTxs.Add(1)
+		return fmt.Errorf("%w: %s", TxKnownError, hash)
+	}
+
+	// the relay limit is for transactions pushed to us, not ones we asked for
+	if !requested && !s.allowTx(from) {
+		s.gossip.rateLimitedTxs.Add(1)
+		return RateLimitedError
+	}
+
+	if transaction.ChainID != s.chain.ChainID() {
+		s.seen.Add(hash)
+		return fmt.Errorf("%w: %w: %d", InvalidTransactionError, core.WrongChainIDError, transaction.ChainID)
+	}
+
+	nonce := s.chain.GetNonce(transaction.From.Address())
+
+	if transaction.Nonce < nonce {
+		return fmt.Errorf("%w: %d is used, next is %d", core.InvalidNonceError, transaction.Nonce, nonce)
+	}
+
+	if transaction.Nonce-nonce >= s.so.MaxNonceGap {
+		return fmt.Errorf("%w: %d is too far ahead, next is %d", core.InvalidNonceError, transaction.Nonce, nonce)
 	}
 
-	if transaction.Verify() {
-		go s.broadcastTx(transaction)
+	if !transaction.Verify() {
+		s.seen.Add(hash)
+		return fmt.Errorf("%w: bad signature %s", InvalidTransactionError, hash)
+	}
+
+	// the balance may differ in the sender's view of the chain, so this is no
+	// fault of the peer
+	balance, _ := s.chain.GetBalance(transaction.From.Address())
 
-		s.memPool.Add(transaction)
+	if transaction.Value > balance || transaction.Fee > balance-transaction.Value {
+		return fmt.Errorf("%w: balance %d", core.AccountNotEnoughBalanceError, balance)
+	}
+
+	if err := s.memPool.Add(transaction); err != nil {
+		return err
 	}
 
+	s.seen.Add(hash)
+	s.clock.Go(func() { s.announce(InvItem{Type: InvTypeTx, Hash: hash}) })
+
 	return nil
 }
 
-func (s *Server) processBlock(b *core.Block) error {
-	if s.chain.AddBlock(b) {
-		go s.broadcastBlock(b)
+func (s *Server) processBlock(from net.Addr, b *core.Block) error {
+	hash := b.Hash(core.HeaderHasher{})
+	s.received(from, hash)
+
+	if s.seen.Contains(hash) {
+		s.gossip.duplicateBlocks.Add(1)
+		return nil
 	}
 
+	err := s.chain.AddBlock(b)
+
+	if errors.Is(err, core.BlockKnownError) {
+		s.seen.Add(hash)
+		s.gossip.duplicateBlocks.Add(1)
+		return nil
+	}
+
+	// a block we cannot attach means the peer is ahead of us
+	if errors.Is(err, core.ParentUnknownError) {
+		s.sync.UpdatePeer(from, b.Header.Height)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.seen.Add(hash)
+	s.removeIncludedTransactions(b)
+	s.clock.Go(func() { s.relayBlock(b) })
+
 	return nil
 }
 
+func (s *Server) removeIncludedTransactions(b *core.Block) {
+	for _, tx := range b.Transactions {
+		s.memPool.Remove(tx.Hash(core.TxHasher{}))
+	}
+
+	s.memPool.RemoveStale(s.chain.GetNonce)
+}
+
+func (s *Server) returnOrphanedTransactions(txs []*core.Transaction) {
+	for _, tx := range txs {
+		_ = s.memPool.Add(tx)
+	}
+
+	_ = s.so.Logger.Log("msg", "returned orphaned transactions to mempool", "count", len(txs))
+}
+
 func (s *Server) processGetStatusMessage(from net.Addr) error {
 	statusMessage := &StatusMessage{
 		ActualHeight: s.chain.Height(),
 		ID:           s.so.ID,
+		Version:      ProtocolVersion,
 	}
 
 	buf := &bytes.Buffer{}
@@ -389,47 +772,29 @@ func (s *Server) processGetStatusMessage(from net.Addr) error {
 }
 
 func (s *Server) processStatusMessage(addr net.Addr, m *StatusMessage) error {
-	if s.chain.Height() >= m.ActualHeight {
-		return nil
-	}
+	s.mu.Lock()
+	peerInfo, ok := s.peerMap[addr]
 
-	getBlocksMessage := &GetBlocksMessage{
-		From: s.chain.Height(),
-		To:   0,
+	if ok {
+		peerInfo.BlockchainHeight = m.ActualHeight
 	}
 
-	buf := &bytes.Buffer{}
-	_ = gob.NewEncoder(buf).Encode(getBlocksMessage)
-
-	msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
-	data, _ := msg.Bytes()
-
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	peerInfo, ok := s.peerMap[addr]
+	s.mu.Unlock()
 
 	if !ok {
 		return errors.New("peer not found")
 	}
 
-	return peerInfo.Peer.Send(data)
+	s.sync.UpdatePeer(addr, m.ActualHeight)
+
+	return nil
 }
 
 func (s *Server) processGetBlocksMessage(from net.Addr, m *GetBlocksMessage) error {
-	var blocks []*core.Block
-	bcHeight := s.chain.Height()
-
-	if m.To == 0 {
-		for i := m.From; i <= bcHeight; i++ {
-			block, err := s.chain.Store.Get(i)
-
-			if err != nil {
-				return err
-			}
+	blocks, errBlocks := s.blocksInRange(m.From, m.To)
 
-			blocks = append(blocks, block)
-		}
+	if errBlocks != nil {
+		return errBlocks
 	}
 
 	blocksMsg := BlocksMessage{
@@ -437,7 +802,7 @@ func (s *Server) processGetBlocksMessage(from net.Addr, m *GetBlocksMessage) err
 	}
 
 	buf := &bytes.Buffer{}
-	err := gob.NewEncoder(buf).Encode(blocksMsg)
+	err := blocksMsg.Encode(buf)
 
 	if err != nil {
 		return err
@@ -467,20 +832,44 @@ func (s *Server) processGetBlocksMessage(from net.Addr, m *GetBlocksMessage) err
 	return nil
 }
 
-func (s *Server) processBlocksMessage(from net.Addr, m *BlocksMessage) error {
-	_ = s.so.Logger.Log("msg", "received ", len(m.Blocks), " blocks from ", from)
+// blocksInRange returns the canonical blocks from..to, to 0 meaning the tip,
+// cut short so the reply fits in one message.
+func (s *Server) blocksInRange(from, to uint32) ([]*core.Block, error) {
+	var blocks []*core.Block
+	size := 0
 
-	for i := 0; i < len(m.Blocks); i++ {
-		s.chain.AddBlock(m.Blocks[i])
+	if height := s.chain.Height(); to == 0 || to > height {
+		to = height
 	}
 
-	return nil
+	for i := from; i <= to && len(blocks) < maxBlocksPerMessage; i++ {
+		block, err := s.chain.Store.Get(i)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// leave room for headers and signatures so the frame stays under MaxMessageSize
+		if size += block.Size(); len(blocks) > 0 && size > MaxMessageSize/2 {
+			break
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+func (s *Server) processBlocksMessage(from net.Addr, m *BlocksMessage) error {
+	_ = s.so.Logger.Log("msg", "received ", len(m.Blocks), " blocks from ", from)
+
+	return s.sync.HandleBlocks(from, m.Blocks)
 }
 
 func (s *Server) processPingMessage(from net.Addr, pingMsg *PingMessage) error {
 	pongMsg := &PongMessage{
 		BlockchainHeight: s.chain.Height(),
-		ResponseTime:     time.Now(),
+		ResponseTime:     s.clock.Now(),
 		RequestTime:      pingMsg.RequestTime,
 	}
 
@@ -511,109 +900,70 @@ func (s *Server) processPingMessage(from net.Addr, pingMsg *PingMessage) error {
 
 func (s *Server) processPongMessage(from net.Addr, pongMsg *PongMessage) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	peerInfo, ok := s.peerMap[from]
 
 	if !ok {
+		s.mu.Unlock()
 		return errors.New("trying to process pongMessage - peer not found")
 	}
 
 	peerInfo.BlockchainHeight = pongMsg.BlockchainHeight
 	peerInfo.PingTime = pongMsg.RequestTime.Sub(pongMsg.RequestTime) * time.Millisecond
+	pingTime := peerInfo.PingTime
+	s.mu.Unlock()
 
-	return s.so.Logger.Log("msg", from, "send pong message", "from data: ", peerInfo.BlockchainHeight, " - height blockchain ", peerInfo.PingTime, " - ping time")
-}
-
-func (s *Server) broadcastTx(tx *core.Transaction) error {
-	buf := &bytes.Buffer{}
-	err := tx.Encode(core.NewGobTxEncoder(buf))
-
-	if err != nil {
-		return err
-	}
-
-	msg := NewMessage(MessageTypeTx, buf.Bytes())
-	encMsg, errBytes := msg.Bytes()
-
-	if errBytes != nil {
-		return errBytes
-	}
-
-	return s.broadcast(encMsg)
-}
-
-func (s *Server) broadcastBlock(b *core.Block) error {
-	buf := &bytes.Buffer{}
-	err := b.Encode(core.NewGobBlockEncoder(buf))
-
-	if err != nil {
-		return err
-	}
-
-	msg := NewMessage(MessageTypeBlock, buf.Bytes())
-	encMsg, errBytes := msg.Bytes()
-
-	if errBytes != nil {
-		return errBytes
-	}
+	s.sync.UpdatePeer(from, pongMsg.BlockchainHeight)
 
-	return s.broadcast(encMsg)
+	return s.so.Logger.Log("msg", from, "send pong message", "from data: ", pongMsg.BlockchainHeight, " - height blockchain ", pingTime, " - ping time")
 }
 
 func (s *Server) createNewBlock() error {
-	header, err := s.chain.GetHeader(s.chain.Height())
+	block, e := s.chain.NewBlockTemplate(s.memPool.Select(s.chain.GetNonce, core.MaxBlockSize), s.so.PrivateKey.PublicKey())
 
-	if err != nil {
-		return err
+	if e != nil {
+		return e
 	}
 
-	txs := s.memPool.txs.Data
-
-	block, e := core.NewBlockFromPrevHeader(header, txs)
-
 	signErr := block.Sign(*s.so.PrivateKey)
 
 	if signErr != nil {
 		return signErr
 	}
 
-	if e != nil {
-		return e
+	if errAdd := s.chain.AddBlock(block); errAdd != nil {
+		return errAdd
 	}
 
-	if s.chain.AddBlock(block) {
-		s.rewardValidator()
-		go s.broadcastBlock(block)
+	_ = s.so.Logger.Log(
+		"msg", "block produced",
+		"hash", block.Hash(core.HeaderHasher{}),
+		"address", s.getValidatorAddress(),
+		"reward", core.BlockReward(block.Header.Height),
+		"fees", core.BlockFees(block),
+		"txs", len(block.Transactions),
+	)
 
-		s.memPool.Clear()
-	}
+	s.clock.Go(func() { s.relayBlock(block) })
+
+	s.removeIncludedTransactions(block)
 
 	return nil
 }
 
-func (s *Server) rewardValidator() {
-	reward := s.getBlockReward()
-	address := s.getValidatorAddress()
-
-	err := s.chain.GetAccounts().AddBalance(address, reward)
+func (s *Server) sendMessage(peer *TCPPeer, t MessageType, v any) error {
+	buf := &bytes.Buffer{}
 
-	if err != nil {
-		_ = s.so.Logger.Log("err", err)
-	} else {
-		_ = s.so.Logger.Log("msg", "reward validator success", "address", address, "reward", reward, "$SCMN")
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return err
 	}
-}
 
-func (s *Server) getBlockReward() uint64 {
-	halving := s.chain.Height() / blockReduction
+	data, err := NewMessage(t, buf.Bytes()).Bytes()
 
-	if halving >= 64 {
-		return 0
+	if err != nil {
+		return err
 	}
 
-	reward := 500
-	return uint64(reward >> halving)
+	return peer.Send(data)
 }
 
 func (s *Server) getValidatorAddress() types.Address {
@@ -621,20 +971,17 @@ func (s *Server) getValidatorAddress() types.Address {
 }
 
 func genesisBlock(key crypto.PrivateKey) *core.Block {
-	h := &core.Header{
-		Version:   1,
-		DataHash:  types.Hash{},
-		Timestamp: 0,
-		Height:    0,
-	}
-
-	b := core.NewBlock(h, nil)
-	_ = b.Sign(key)
-
 	coinbase := crypto.ZeroPublicKey()
 
 	tx := core.NewTransaction(nil, coinbase, coinbase.Address(), 1000000, 0)
-	b.Transactions = append(b.Transactions, tx)
+
+	b, err := core.NewGenesisBlock([]*core.Transaction{tx})
+
+	if err != nil {
+		panic(err)
+	}
+
+	_ = b.Sign(key)
 
 	return b
 }
@@ -0,0 +1,133 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/go-kit/log"
+	"math/rand"
+	"time"
+)
+
+// simStep is how far RunUntil moves the virtual clock between checks.
+const simStep = 10 * time.Millisecond
+
+type SimulatorOptions struct {
+	Nodes int
+	// Seed drives the node keys, the servers' random choices and message
+	// drops.
+	Seed   int64
+	Logger log.Logger
+}
+
+// Simulator runs Servers on a LocalNetwork under a SimClock. Node 0 is the
+// seed of all others. Timeouts, tickers and message delays follow the
+// virtual clock, so a test controls how much time passes, and every message
+// is handled before the next one is delivered, so a seed always gives the
+// same run.
+type Simulator struct {
+	Clock   *SimClock
+	Network *LocalNetwork
+	Servers []*Server
+}
+
+func simAddress(i int) string {
+	return fmt.Sprintf("127.0.0.%d:3000", i+1)
+}
+
+func NewSimulator(opts SimulatorOptions) (*Simulator, error) {
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+
+	clock := NewSimClock(time.Unix(0, 0))
+
+	sim := &Simulator{
+		Clock:   clock,
+		Network: NewLocalNetwork(clock, opts.Seed),
+	}
+
+	seeds := rand.New(rand.NewSource(opts.Seed))
+
+	for i := 0; i < opts.Nodes; i++ {
+		seed := make([]byte, ed25519.SeedSize)
+		_, _ = seeds.Read(seed)
+
+		nodeKey := ed25519.NewKeyFromSeed(seed)
+		privateKey := crypto.GeneratePrivateKey()
+		addr := simAddress(i)
+
+		var seedNodes []string
+
+		if i > 0 {
+			seedNodes = []string{simAddress(0)}
+		}
+
+		s, err := NewServer(&ServerOptions{
+			ListenAddress: addr,
+			SeedNodes:     seedNodes,
+			PrivateKey:    &privateKey,
+			NodeKey:       nodeKey,
+			Logger:        log.With(opts.Logger, "node", i),
+			Transport:     NewLocalTransport(sim.Network, addr, nodeKey),
+			Clock:         clock.For(addr),
+			Rand:          rand.NewSource(seeds.Int63()),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		sim.Servers = append(sim.Servers, s)
+	}
+
+	return sim, nil
+}
+
+// Start starts the servers and returns once they wait for the clock.
+func (sim *Simulator) Start() {
+	for _, s := range sim.Servers {
+		sim.Clock.Hold()
+		go s.Start()
+	}
+
+	sim.Clock.Advance(0)
+}
+
+func (sim *Simulator) Stop() {
+	for _, s := range sim.Servers {
+		s.Stop()
+	}
+}
+
+// RunUntil advances the virtual clock until cond holds, for at most limit of
+// virtual time, and reports whether cond was met.
+func (sim *Simulator) RunUntil(cond func() bool, limit time.Duration) bool {
+	for elapsed := time.Duration(0); elapsed <= limit; elapsed += simStep {
+		if cond() {
+			return true
+		}
+
+		sim.Clock.Advance(simStep)
+	}
+
+	return cond()
+}
+
+// Partition splits the nodes with the given indexes into groups that cannot
+// reach each other.
+func (sim *Simulator) Partition(groups ...[]int) {
+	addrGroups := make([][]string, len(groups))
+
+	for i, group := range groups {
+		for _, node := range group {
+			addrGroups[i] = append(addrGroups[i], simAddress(node))
+		}
+	}
+
+	sim.Network.Partition(addrGroups...)
+}
+
+func (sim *Simulator) Heal() {
+	sim.Network.Heal()
+}
@@ -0,0 +1,143 @@
+package network
+
+import (
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestSimulator(t *testing.T, nodes int) *Simulator {
+	sim, err := NewSimulator(SimulatorOptions{Nodes: nodes, Seed: 1})
+	assert.Nil(t, err)
+
+	sim.Start()
+	t.Cleanup(sim.Stop)
+
+	return sim
+}
+
+func produceBlock(t *testing.T, s *Server) {
+	b, err := s.chain.NewBlockTemplate(nil, s.so.PrivateKey.PublicKey())
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(*s.so.PrivateKey))
+	assert.Nil(t, s.processBlock(nil, b))
+}
+
+// fundedKey produces a block on s and returns the validator key, which the
+// block reward funds.
+func fundedKey(t *testing.T, s *Server) crypto.PrivateKey {
+	produceBlock(t, s)
+
+	return *s.so.PrivateKey
+}
+
+func handshakedPeers(s *Server) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+
+	for _, peerInfo := range s.peerMap {
+		if peerInfo.Handshaked {
+			count++
+		}
+	}
+
+	return count
+}
+
+func atHeight(servers []*Server, height uint32) func() bool {
+	return func() bool {
+		for _, s := range servers {
+			if s.chain.Height() != height {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func fullyConnected(servers []*Server) func() bool {
+	return func() bool {
+		for _, s := range servers {
+			if handshakedPeers(s) < len(servers)-1 {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func TestSimulator_Discovery(t *testing.T) {
+	sim := newTestSimulator(t, 4)
+
+	assert.True(t, sim.RunUntil(fullyConnected(sim.Servers), 2*time.Minute))
+}
+
+func TestSimulator_Sync(t *testing.T) {
+	sim, err := NewSimulator(SimulatorOptions{Nodes: 3, Seed: 2})
+	assert.Nil(t, err)
+
+	for sim.Servers[0].chain.Height() < 40 {
+		produceBlock(t, sim.Servers[0])
+	}
+
+	sim.Network.SetLatency(50 * time.Millisecond)
+	sim.Start()
+	t.Cleanup(sim.Stop)
+
+	assert.True(t, sim.RunUntil(atHeight(sim.Servers, 40), time.Minute))
+}
+
+func TestSimulator_Partition(t *testing.T) {
+	sim := newTestSimulator(t, 4)
+
+	assert.True(t, sim.RunUntil(fullyConnected(sim.Servers), 2*time.Minute))
+
+	sim.Partition([]int{0, 1}, []int{2, 3})
+	produceBlock(t, sim.Servers[2])
+
+	assert.True(t, sim.RunUntil(atHeight(sim.Servers[2:], 1), time.Minute))
+	assert.False(t, sim.RunUntil(atHeight(sim.Servers, 1), 30*time.Second))
+
+	sim.Heal()
+
+	assert.True(t, sim.RunUntil(atHeight(sim.Servers, 1), time.Minute))
+}
+
+func peerAddresses(s *Server) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addrs []string
+
+	for addr := range s.peerMap {
+		addrs = append(addrs, addr.String())
+	}
+
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+func TestSimulator_Deterministic(t *testing.T) {
+	run := func() []string {
+		sim := newTestSimulator(t, 4)
+		assert.True(t, sim.RunUntil(fullyConnected(sim.Servers), 2*time.Minute))
+
+		// the ports of dialed connections give away the order of the dials
+		trace := []string{sim.Clock.Now().String()}
+
+		for _, s := range sim.Servers {
+			trace = append(trace, peerAddresses(s)...)
+		}
+
+		return trace
+	}
+
+	assert.Equal(t, run(), run())
+}
@@ -0,0 +1,752 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/go-kit/log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// blocksPerRequest is the number of bodies asked from one peer at a time.
+	blocksPerRequest   = 16
+	maxRequestsPerPeer = 2
+	// downloadWindow bounds how far past the chain tip bodies are fetched,
+	// which bounds the blocks buffered out of order.
+	downloadWindow     = 512
+	syncRequestTimeout = 15 * time.Second
+	syncTickInterval   = time.Second
+	syncLogInterval    = 10 * time.Second
+	// maxSyncTimeouts is how many requests a peer may let time out before it
+	// is no longer used for sync.
+	maxSyncTimeouts = 3
+	maxForkDepth    = 1 << 16
+	// lateReplyWindow is how long after a request timed out its reply is
+	// still expected, and dropped without holding it against the peer.
+	lateReplyWindow = 2 * syncRequestTimeout
+)
+
+var InvalidHeaderError = errors.New("invalid header")
+
+type SyncProgress struct {
+	Syncing bool
+	// Height is the chain height, HeadersHeight the best validated header and
+	// TargetHeight the best height announced by a peer.
+	Height        uint32
+	HeadersHeight uint32
+	TargetHeight  uint32
+	InFlight      int
+}
+
+type SyncOptions struct {
+	Chain  *core.Blockchain
+	Logger log.Logger
+	// Now defaults to time.Now.
+	Now            func() time.Time
+	RequestHeaders func(peer net.Addr, from, to uint32) error
+	RequestBlocks  func(peer net.Addr, from, to uint32) error
+	// OnBlock is called for every block sync adds to the chain.
+	OnBlock func(b *core.Block)
+	// OnInvalidBlock is called with the peer that sent a block the chain
+	// rejected.
+	OnInvalidBlock func(peer net.Addr, err error)
+}
+
+type syncPeer struct {
+	addr     net.Addr
+	height   uint32
+	requests int
+	timeouts int
+}
+
+type syncBlock struct {
+	block *core.Block
+	from  net.Addr
+}
+
+type syncRequest struct {
+	peer     *syncPeer
+	headers  bool
+	from, to uint32
+	deadline time.Time
+}
+
+// SyncManager downloads the chain headers first: it builds a header chain
+// from the best peer, verifying links and signatures, and then fetches the
+// bodies in batches from every peer that has them, applying them in order.
+type SyncManager struct {
+	mu    sync.Mutex
+	opts  SyncOptions
+	peers map[string]*syncPeer
+	// headers[i] is at height base+1+i; headers[0] links to baseHash.
+	base      uint32
+	baseHash  types.Hash
+	headers   []*core.Header
+	hashes    []types.Hash
+	forkDepth uint32
+	requests  []*syncRequest
+	expired   []*syncRequest
+	blocks    map[uint32]*syncBlock
+	lastLog   time.Time
+}
+
+func NewSyncManager(opts SyncOptions) *SyncManager {
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	if opts.OnBlock == nil {
+		opts.OnBlock = func(*core.Block) {}
+	}
+
+	if opts.OnInvalidBlock == nil {
+		opts.OnInvalidBlock = func(net.Addr, error) {}
+	}
+
+	sm := &SyncManager{
+		opts:   opts,
+		peers:  make(map[string]*syncPeer),
+		blocks: make(map[uint32]*syncBlock),
+	}
+
+	sm.resetNoLock()
+
+	return sm
+}
+
+// UpdatePeer records the height a peer announced and starts syncing from it
+// if it is ahead.
+func (sm *SyncManager) UpdatePeer(addr net.Addr, height uint32) {
+	sm.mu.Lock()
+
+	p, ok := sm.peers[addr.String()]
+
+	if !ok {
+		p = &syncPeer{addr: addr}
+		sm.peers[addr.String()] = p
+	}
+
+	p.height = max(p.height, height)
+	requests := sm.scheduleNoLock(sm.opts.Now())
+	sm.mu.Unlock()
+
+	sm.send(requests)
+}
+
+func (sm *SyncManager) RemovePeer(addr net.Addr) {
+	sm.mu.Lock()
+
+	if p, ok := sm.peers[addr.String()]; ok {
+		sm.dropPeerNoLock(p)
+	}
+
+	requests := sm.scheduleNoLock(sm.opts.Now())
+	sm.mu.Unlock()
+
+	sm.send(requests)
+}
+
+// HandleHeaders processes the answer to a GetHeaders request. A peer whose
+// headers are rejected is no longer used for sync.
+func (sm *SyncManager) HandleHeaders(from net.Addr, headers []*core.SignedHeader) error {
+	sm.mu.Lock()
+	err := sm.handleHeadersNoLock(from, headers)
+
+	if p, ok := sm.peers[from.String()]; ok && err != nil {
+		sm.dropPeerNoLock(p)
+	}
+
+	requests := sm.scheduleNoLock(sm.opts.Now())
+	sm.mu.Unlock()
+
+	sm.send(requests)
+
+	return err
+}
+
+// HandleBlocks processes the answer to a GetBlocks request and adds every
+// block that completes the chain in order. A block the chain rejects is
+// reported to OnInvalidBlock with the peer it came from, which need not be
+// the sender of this message.
+func (sm *SyncManager) HandleBlocks(from net.Addr, blocks []*core.Block) error {
+	sm.mu.Lock()
+	err := sm.handleBlocksNoLock(from, blocks)
+	invalid, errApply := sm.applyNoLock()
+	requests := sm.scheduleNoLock(sm.opts.Now())
+	sm.mu.Unlock()
+
+	if errApply != nil {
+		sm.opts.OnInvalidBlock(invalid, errApply)
+	}
+
+	sm.send(requests)
+
+	return err
+}
+
+// Tick expires timed out requests, moving their work to other peers, and logs
+// progress while syncing.
+func (sm *SyncManager) Tick(now time.Time) {
+	sm.mu.Lock()
+
+	expired := sm.expired[:0]
+
+	for _, r := range sm.expired {
+		if now.Before(r.deadline.Add(lateReplyWindow)) {
+			expired = append(expired, r)
+		}
+	}
+
+	pending := sm.requests[:0]
+
+	for _, r := range sm.requests {
+		if now.Before(r.deadline) {
+			pending = append(pending, r)
+			continue
+		}
+
+		_ = sm.opts.Logger.Log("msg", "sync request timed out", "peer", r.peer.addr.String(), "from", r.from, "to", r.to)
+
+		r.peer.requests--
+		r.peer.timeouts++
+		expired = append(expired, r)
+	}
+
+	sm.requests = pending
+	sm.expired = expired
+
+	for _, p := range sm.peers {
+		if p.timeouts >= maxSyncTimeouts {
+			sm.dropPeerNoLock(p)
+		}
+	}
+
+	requests := sm.scheduleNoLock(now)
+	progress := sm.progressNoLock()
+
+	if progress.Syncing && now.Sub(sm.lastLog) >= syncLogInterval {
+		sm.lastLog = now
+
+		_ = sm.opts.Logger.Log(
+			"msg", "syncing",
+			"height", progress.Height,
+			"headers", progress.HeadersHeight,
+			"target", progress.TargetHeight,
+			"inFlight", progress.InFlight,
+		)
+	}
+
+	sm.mu.Unlock()
+
+	sm.send(requests)
+}
+
+func (sm *SyncManager) Progress() SyncProgress {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.progressNoLock()
+}
+
+func (sm *SyncManager) progressNoLock() SyncProgress {
+	progress := SyncProgress{
+		Height:        sm.opts.Chain.Height(),
+		HeadersHeight: sm.topNoLock(),
+	}
+
+	for _, p := range sm.peers {
+		progress.TargetHeight = max(progress.TargetHeight, p.height)
+	}
+
+	for _, r := range sm.requests {
+		if !r.headers {
+			progress.InFlight += int(r.to - r.from + 1)
+		}
+	}
+
+	progress.Syncing = progress.TargetHeight > progress.Height
+
+	return progress
+}
+
+func (sm *SyncManager) topNoLock() uint32 {
+	return sm.base + uint32(len(sm.headers))
+}
+
+func (sm *SyncManager) topHashNoLock() types.Hash {
+	if len(sm.hashes) == 0 {
+		return sm.baseHash
+	}
+
+	return sm.hashes[len(sm.hashes)-1]
+}
+
+// resetNoLock drops the header chain and restarts it from the chain tip.
+func (sm *SyncManager) resetNoLock() {
+	sm.base = sm.opts.Chain.Height()
+	sm.headers = nil
+	sm.hashes = nil
+	sm.blocks = make(map[uint32]*syncBlock)
+
+	if tip, err := sm.opts.Chain.GetHeader(sm.base); err == nil {
+		sm.baseHash = core.HeaderHasher{}.Hash(tip)
+	}
+}
+
+func (sm *SyncManager) dropPeerNoLock(p *syncPeer) {
+	delete(sm.peers, p.addr.String())
+
+	pending := sm.requests[:0]
+
+	for _, r := range sm.requests {
+		if r.peer != p {
+			pending = append(pending, r)
+		}
+	}
+
+	sm.requests = pending
+}
+
+// takeRequestNoLock removes and returns the oldest request to the peer at
+// addr of the given kind that is still waiting for its reply. Peers answer in
+// order, so one that timed out recently comes first; late reports whether it
+// did.
+func (sm *SyncManager) takeRequestNoLock(addr net.Addr, headers bool) (r *syncRequest, late bool) {
+	for i, r := range sm.expired {
+		if r.headers == headers && r.peer.addr.String() == addr.String() {
+			sm.expired = append(sm.expired[:i:i], sm.expired[i+1:]...)
+
+			return r, true
+		}
+	}
+
+	for i, r := range sm.requests {
+		if r.headers == headers && r.peer.addr.String() == addr.String() {
+			sm.requests = append(sm.requests[:i:i], sm.requests[i+1:]...)
+			r.peer.requests--
+
+			return r, false
+		}
+	}
+
+	return nil, false
+}
+
+func (sm *SyncManager) handleHeadersNoLock(from net.Addr, signed []*core.SignedHeader) error {
+	r, late := sm.takeRequestNoLock(from, true)
+
+	if r == nil {
+		return fmt.Errorf("%w: %d headers", UnsolicitedMessageError, len(signed))
+	}
+
+	// the range was handed to another request when this one timed out
+	if late {
+		return nil
+	}
+
+	if len(signed) == 0 {
+		// the peer has nothing past r.from after all
+		r.peer.height = min(r.peer.height, r.from-1)
+		return nil
+	}
+
+	if len(signed) > int(r.to-r.from+1) || signed[0].Header.Height != r.from {
+		return fmt.Errorf("%w: headers %d-%d do not match request %d-%d", UnsolicitedMessageError,
+			signed[0].Header.Height, signed[len(signed)-1].Header.Height, r.from, r.to)
+	}
+
+	// skip what we have already; the last known header is where the new
+	// headers attach
+	known := 0
+
+	for known < len(signed) && sm.opts.Chain.HasBlockHash(core.HeaderHasher{}.Hash(signed[known].Header)) {
+		known++
+	}
+
+	if known > 0 {
+		last := signed[known-1].Header
+		lastHash := core.HeaderHasher{}.Hash(last)
+
+		if sm.topHashNoLock() != lastHash {
+			sm.resetNoLock()
+			sm.base = last.Height
+			sm.baseHash = lastHash
+		}
+	}
+
+	signed = signed[known:]
+	forkDepth := sm.forkDepth
+	sm.forkDepth = 0
+
+	if len(signed) == 0 {
+		return nil
+	}
+
+	first := signed[0].Header
+
+	if first.Height != sm.topNoLock()+1 || first.PrevBlockHash != sm.topHashNoLock() {
+		if !sm.opts.Chain.HasBlockHash(first.PrevBlockHash) {
+			// the peer is on a fork that split off below what we asked for:
+			// look further back next time
+			sm.forkDepth = min(max(1, forkDepth)*2, maxForkDepth)
+			sm.resetNoLock()
+
+			return nil
+		}
+
+		sm.resetNoLock()
+		sm.base = first.Height - 1
+		sm.baseHash = first.PrevBlockHash
+	}
+
+	prevHeight, prevHash := sm.topNoLock(), sm.topHashNoLock()
+
+	for _, sh := range signed {
+		h := sh.Header
+
+		if h.Height != prevHeight+1 || h.PrevBlockHash != prevHash {
+			return fmt.Errorf("%w: header %d does not link to %d", InvalidHeaderError, h.Height, prevHeight)
+		}
+
+		if !sh.Verify() {
+			return fmt.Errorf("%w: bad signature on header %d", InvalidHeaderError, h.Height)
+		}
+
+		prevHeight, prevHash = h.Height, core.HeaderHasher{}.Hash(h)
+	}
+
+	for _, sh := range signed {
+		sm.headers = append(sm.headers, sh.Header)
+		sm.hashes = append(sm.hashes, core.HeaderHasher{}.Hash(sh.Header))
+	}
+
+	r.peer.height = max(r.peer.height, prevHeight)
+
+	return nil
+}
+
+func (sm *SyncManager) handleBlocksNoLock(from net.Addr, blocks []*core.Block) error {
+	r, late := sm.takeRequestNoLock(from, false)
+
+	if r == nil {
+		return fmt.Errorf("%w: %d blocks", UnsolicitedMessageError, len(blocks))
+	}
+
+	if late {
+		return nil
+	}
+
+	for _, b := range blocks {
+		height := b.Header.Height
+
+		if height < r.from || height > r.to {
+			return fmt.Errorf("%w: block %d outside request %d-%d", UnsolicitedMessageError, height, r.from, r.to)
+		}
+
+		if height <= sm.base || height > sm.topNoLock() {
+			continue
+		}
+
+		if b.Hash(core.HeaderHasher{}) != sm.hashes[height-sm.base-1] {
+			// the peer is on another fork than our header chain
+			r.peer.timeouts++
+			continue
+		}
+
+		sm.blocks[height] = &syncBlock{block: b, from: from}
+	}
+
+	return nil
+}
+
+// applyNoLock adds the downloaded blocks that follow the chain in order.
+func (sm *SyncManager) applyNoLock() (net.Addr, error) {
+	for len(sm.headers) > 0 {
+		sb, ok := sm.blocks[sm.base+1]
+
+		if !ok {
+			return nil, nil
+		}
+
+		err := sm.opts.Chain.AddBlock(sb.block)
+
+		if err != nil && !errors.Is(err, core.BlockKnownError) {
+			sm.resetNoLock()
+
+			if p, ok := sm.peers[sb.from.String()]; ok {
+				sm.dropPeerNoLock(p)
+			}
+
+			return sb.from, err
+		}
+
+		if err == nil {
+			sm.opts.OnBlock(sb.block)
+		}
+
+		sm.popNoLock()
+	}
+
+	return nil, nil
+}
+
+// popNoLock moves the base of the header chain up by one added block.
+func (sm *SyncManager) popNoLock() {
+	delete(sm.blocks, sm.base+1)
+	sm.base++
+	sm.baseHash = sm.hashes[0]
+	sm.headers = sm.headers[1:]
+	sm.hashes = sm.hashes[1:]
+}
+
+// scheduleNoLock creates the requests that keep the download going: one
+// header request to the best peer ahead of the header chain and body
+// requests spread over all peers that have the blocks.
+func (sm *SyncManager) scheduleNoLock(now time.Time) []*syncRequest {
+	// blocks that reached the chain another way need no download
+	for len(sm.headers) > 0 && sm.opts.Chain.HasBlockHash(sm.hashes[0]) {
+		sm.popNoLock()
+	}
+
+	if len(sm.headers) == 0 && sm.opts.Chain.Height() > sm.base {
+		sm.resetNoLock()
+	}
+
+	var created []*syncRequest
+
+	if r := sm.scheduleHeadersNoLock(now); r != nil {
+		created = append(created, r)
+	}
+
+	limit := min(sm.topNoLock(), sm.opts.Chain.Height()+downloadWindow)
+
+	for from := sm.base + 1; from <= limit; {
+		if sm.blocks[from] != nil || sm.requestedNoLock(from) {
+			from++
+			continue
+		}
+
+		to := from
+
+		for to < limit && to-from+1 < blocksPerRequest && sm.blocks[to+1] == nil && !sm.requestedNoLock(to+1) {
+			to++
+		}
+
+		p := sm.idlePeerNoLock(to)
+
+		if p == nil {
+			break
+		}
+
+		created = append(created, sm.addRequestNoLock(p, false, from, to, now))
+		from = to + 1
+	}
+
+	return created
+}
+
+func (sm *SyncManager) scheduleHeadersNoLock(now time.Time) *syncRequest {
+	for _, r := range sm.requests {
+		if r.headers {
+			return nil
+		}
+	}
+
+	var best *syncPeer
+
+	for _, p := range sm.peers {
+		if p.requests >= maxRequestsPerPeer {
+			continue
+		}
+
+		if best == nil || p.height > best.height || p.height == best.height && p.addr.String() < best.addr.String() {
+			best = p
+		}
+	}
+
+	top := sm.topNoLock()
+
+	if best == nil || best.height <= top {
+		return nil
+	}
+
+	from := top + 1
+
+	if sm.forkDepth > 0 {
+		from = max(1, from-min(sm.forkDepth, from-1))
+	}
+
+	to := min(best.height, from+maxHeadersPerMessage-1)
+
+	return sm.addRequestNoLock(best, true, from, to, now)
+}
+
+func (sm *SyncManager) requestedNoLock(height uint32) bool {
+	for _, r := range sm.requests {
+		if !r.headers && height >= r.from && height <= r.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// idlePeerNoLock returns the least busy peer that has the block at height and
+// can take another request, the lowest address among equals.
+func (sm *SyncManager) idlePeerNoLock(height uint32) *syncPeer {
+	var idle *syncPeer
+
+	for _, p := range sm.peers {
+		if p.height < height || p.requests >= maxRequestsPerPeer {
+			continue
+		}
+
+		if idle == nil || p.requests < idle.requests || p.requests == idle.requests && p.addr.String() < idle.addr.String() {
+			idle = p
+		}
+	}
+
+	return idle
+}
+
+func (sm *SyncManager) addRequestNoLock(p *syncPeer, headers bool, from, to uint32, now time.Time) *syncRequest {
+	r := &syncRequest{
+		peer:     p,
+		headers:  headers,
+		from:     from,
+		to:       to,
+		deadline: now.Add(syncRequestTimeout),
+	}
+
+	p.requests++
+	sm.requests = append(sm.requests, r)
+
+	return r
+}
+
+// send issues the requests created under the lock. A request that cannot be
+// sent is left to time out.
+func (sm *SyncManager) send(requests []*syncRequest) {
+	for _, r := range requests {
+		var err error
+
+		if r.headers {
+			err = sm.opts.RequestHeaders(r.peer.addr, r.from, r.to)
+		} else {
+			err = sm.opts.RequestBlocks(r.peer.addr, r.from, r.to)
+		}
+
+		if err != nil {
+			_ = sm.opts.Logger.Log("msg", "sync request failed", "peer", r.peer.addr.String(), "err", err)
+		}
+	}
+}
+
+func (s *Server) syncLoop(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C():
+			s.sync.Tick(now)
+		case <-s.quitChannel:
+			return
+		}
+	}
+}
+
+func (s *Server) requestHeaders(addr net.Addr, from, to uint32) error {
+	peer, err := s.peerAt(addr)
+
+	if err != nil {
+		return err
+	}
+
+	return s.sendMessage(peer, MessageTypeGetHeaders, &GetHeadersMessage{From: from, To: to})
+}
+
+func (s *Server) requestBlocks(addr net.Addr, from, to uint32) error {
+	peer, err := s.peerAt(addr)
+
+	if err != nil {
+		return err
+	}
+
+	return s.sendMessage(peer, MessageTypeGetBlocks, &GetBlocksMessage{From: from, To: to})
+}
+
+func (s *Server) peerAt(addr net.Addr) (*TCPPeer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peerInfo, ok := s.peerMap[addr]
+
+	if !ok {
+		return nil, fmt.Errorf("peer %s not found", addr)
+	}
+
+	return peerInfo.Peer, nil
+}
+
+// headersInRange returns the signed canonical headers from..to, to 0 meaning
+// the tip.
+func (s *Server) headersInRange(from, to uint32) ([]*core.SignedHeader, error) {
+	var headers []*core.SignedHeader
+
+	if height := s.chain.Height(); to == 0 || to > height {
+		to = height
+	}
+
+	for i := from; i <= to && len(headers) < maxHeadersPerMessage; i++ {
+		header, err := s.chain.GetSignedHeader(i)
+
+		if err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
+
+func (s *Server) processGetHeadersMessage(from net.Addr, m *GetHeadersMessage) error {
+	headers, err := s.headersInRange(m.From, m.To)
+
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+
+	if errEncode := (&HeadersMessage{Headers: headers}).Encode(buf); errEncode != nil {
+		return errEncode
+	}
+
+	data, errBytes := NewMessage(MessageTypeHeaders, buf.Bytes()).Bytes()
+
+	if errBytes != nil {
+		return errBytes
+	}
+
+	peer, errPeer := s.peerAt(from)
+
+	if errPeer != nil {
+		return errPeer
+	}
+
+	return peer.Send(data)
+}
+
+func (s *Server) processHeadersMessage(from net.Addr, m *HeadersMessage) error {
+	return s.sync.HandleHeaders(from, m.Headers)
+}
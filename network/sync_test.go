@@ -0,0 +1,247 @@
+package network
+
+import (
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func buildChain(t *testing.T, key crypto.PrivateKey, height uint32) *core.Blockchain {
+	bc := core.NewBlockchain(log.NewNopLogger(), genesisBlock(key))
+
+	for bc.Height() < height {
+		b, err := bc.NewBlockTemplate(nil, key.PublicKey())
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(key))
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	return bc
+}
+
+type syncCall struct {
+	peer     net.Addr
+	headers  bool
+	from, to uint32
+}
+
+// syncNetwork serves the requests of a SyncManager from a source chain, as
+// peers would. Requests to a silent peer go unanswered.
+type syncNetwork struct {
+	source  *core.Blockchain
+	calls   []syncCall
+	silent  map[string]bool
+	served  map[string]int
+	tamper  func(headers []*core.SignedHeader)
+	lastErr error
+}
+
+func newSyncNetwork(source *core.Blockchain) *syncNetwork {
+	return &syncNetwork{
+		source: source,
+		silent: make(map[string]bool),
+		served: make(map[string]int),
+	}
+}
+
+func (n *syncNetwork) manager(chain *core.Blockchain) *SyncManager {
+	return NewSyncManager(SyncOptions{
+		Chain: chain,
+		RequestHeaders: func(peer net.Addr, from, to uint32) error {
+			n.calls = append(n.calls, syncCall{peer: peer, headers: true, from: from, to: to})
+			return nil
+		},
+		RequestBlocks: func(peer net.Addr, from, to uint32) error {
+			n.calls = append(n.calls, syncCall{peer: peer, from: from, to: to})
+			return nil
+		},
+	})
+}
+
+// run answers requests until none are left.
+func (n *syncNetwork) run(t *testing.T, sm *SyncManager) {
+	for len(n.calls) > 0 {
+		c := n.calls[0]
+		n.calls = n.calls[1:]
+
+		if n.silent[c.peer.String()] {
+			continue
+		}
+
+		to := min(c.to, n.source.Height())
+
+		if c.headers {
+			var headers []*core.SignedHeader
+
+			for i := c.from; i <= to; i++ {
+				b, err := n.source.Store.Get(i)
+				assert.Nil(t, err)
+				headers = append(headers, b.SignedHeader())
+			}
+
+			if n.tamper != nil {
+				n.tamper(headers)
+			}
+
+			n.lastErr = sm.HandleHeaders(c.peer, headers)
+			continue
+		}
+
+		var blocks []*core.Block
+
+		for i := c.from; i <= to; i++ {
+			b, err := n.source.Store.Get(i)
+			assert.Nil(t, err)
+			blocks = append(blocks, b)
+		}
+
+		n.served[c.peer.String()]++
+		n.lastErr = sm.HandleBlocks(c.peer, blocks)
+	}
+}
+
+func syncPeerAddr(port int) net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}
+}
+
+func TestSyncManager_Sync(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	source := buildChain(t, key, 100)
+	chain := buildChain(t, key, 0)
+
+	n := newSyncNetwork(source)
+	sm := n.manager(chain)
+
+	for port := 1; port <= 3; port++ {
+		sm.UpdatePeer(syncPeerAddr(port), source.Height())
+	}
+
+	assert.True(t, sm.Progress().Syncing)
+
+	n.run(t, sm)
+
+	assert.Nil(t, n.lastErr)
+	assert.Equal(t, source.Height(), chain.Height())
+	assert.Equal(t, len(n.served), 3)
+	assert.Equal(t, SyncProgress{Height: 100, HeadersHeight: 100, TargetHeight: 100}, sm.Progress())
+}
+
+func TestSyncManager_TickRetries(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	source := buildChain(t, key, 60)
+	chain := buildChain(t, key, 0)
+
+	n := newSyncNetwork(source)
+	sm := n.manager(chain)
+
+	n.silent[syncPeerAddr(1).String()] = true
+	sm.UpdatePeer(syncPeerAddr(1), source.Height())
+	sm.UpdatePeer(syncPeerAddr(2), source.Height())
+
+	now := time.Now()
+
+	for i := 0; i < 2*maxSyncTimeouts && chain.Height() < source.Height(); i++ {
+		n.run(t, sm)
+		now = now.Add(syncRequestTimeout)
+		sm.Tick(now)
+	}
+
+	assert.Equal(t, source.Height(), chain.Height())
+	assert.Zero(t, n.served[syncPeerAddr(1).String()])
+}
+
+func TestSyncManager_LateReply(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	source := buildChain(t, key, 20)
+	chain := buildChain(t, key, 0)
+
+	n := newSyncNetwork(source)
+	sm := n.manager(chain)
+	sm.UpdatePeer(syncPeerAddr(1), source.Height())
+
+	// the first request times out and is sent again
+	late := n.calls
+	n.calls = nil
+	sm.Tick(time.Now().Add(syncRequestTimeout))
+	assert.Len(t, n.calls, 1)
+
+	retry := n.calls
+	n.calls = late
+	n.run(t, sm)
+	assert.Nil(t, n.lastErr)
+	assert.Equal(t, uint32(0), chain.Height())
+
+	n.calls = retry
+	n.run(t, sm)
+	assert.Nil(t, n.lastErr)
+	assert.Equal(t, source.Height(), chain.Height())
+	assert.Contains(t, sm.peers, syncPeerAddr(1).String())
+}
+
+func TestSyncManager_HandleHeadersInvalid(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	source := buildChain(t, key, 20)
+	chain := buildChain(t, key, 0)
+
+	n := newSyncNetwork(source)
+	n.tamper = func(headers []*core.SignedHeader) {
+		headers[5].Validator = crypto.GeneratePrivateKey().PublicKey()
+	}
+
+	sm := n.manager(chain)
+	sm.UpdatePeer(syncPeerAddr(1), source.Height())
+
+	n.run(t, sm)
+
+	assert.ErrorIs(t, n.lastErr, InvalidHeaderError)
+	assert.Equal(t, uint32(0), chain.Height())
+}
+
+func TestSyncManager_HandleBlocksUnsolicited(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	source := buildChain(t, key, 2)
+
+	sm := newSyncNetwork(source).manager(buildChain(t, key, 0))
+
+	b, err := source.Store.Get(1)
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, sm.HandleBlocks(syncPeerAddr(1), []*core.Block{b}), UnsolicitedMessageError)
+}
+
+func TestServer_blocksInRange(t *testing.T) {
+	s := newTestServer(t)
+
+	for s.chain.Height() < 5 {
+		b, err := s.chain.NewBlockTemplate(nil, s.so.PrivateKey.PublicKey())
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(*s.so.PrivateKey))
+		assert.Nil(t, s.chain.AddBlock(b))
+	}
+
+	for _, tc := range []struct {
+		from, to uint32
+		want     int
+	}{
+		{1, 3, 3},
+		{2, 0, 4},
+		{4, 100, 2},
+		{6, 0, 0},
+	} {
+		blocks, err := s.blocksInRange(tc.from, tc.to)
+		assert.Nil(t, err)
+		assert.Len(t, blocks, tc.want)
+
+		if tc.want > 0 {
+			assert.Equal(t, tc.from, blocks[0].Header.Height)
+		}
+
+		headers, errHeaders := s.headersInRange(tc.from, tc.to)
+		assert.Nil(t, errHeaders)
+		assert.Len(t, headers, tc.want)
+	}
+}
@@ -1,47 +1,89 @@
 package network
 
 import (
+	"bufio"
 	"bytes"
-	"io"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
 	"net"
+	"sync"
 	"time"
 )
 
+const readBufferSize = 64 << 10
+
 type PeerInfo struct {
 	Peer             *TCPPeer
 	BlockchainHeight uint32
 	PingTime         time.Duration
+	Handshaked       bool
+	ListenAddress    string
+	ProtocolVersion  uint32
+	Outbound         bool
+	ConnectedAt      time.Time
+	// Score drops as the peer misbehaves; see penalize.
+	Score int
+	// known holds the transactions and blocks the peer has.
+	known knownInventory
+	// txBucket limits the transactions the peer relays to us.
+	txBucket *tokenBucket
+	// mempoolRequested and mempoolSent record the mempool exchange, which
+	// happens once per connection.
+	mempoolRequested bool
+	mempoolSent      bool
 }
 
+// TCPPeer is a connection to a peer, whichever Transport made it.
 type TCPPeer struct {
 	conn     net.Conn
+	ID       PeerID
 	Outgoing bool
+	// dialAddr is the address an outgoing peer was dialed at.
+	dialAddr string
+	writeMu  sync.Mutex
+	// readErr is why readLoop stopped, set before the peer is reported.
+	readErr error
 }
 
+// Send writes one encoded message; concurrent senders never interleave frames.
 func (peer *TCPPeer) Send(data []byte) error {
+	peer.writeMu.Lock()
+	defer peer.writeMu.Unlock()
+
 	_, err := peer.conn.Write(data)
 	return err
 }
 
-func (peer *TCPPeer) readLoop(rpcCh chan RPC) {
-	buf := make([]byte, 4096)
+// readLoop reassembles frames from the connection and hands each one to rpcCh.
+// Until the first frame, which must be the handshake, only frames of a
+// handshake's size are read. On EOF or a broken stream it closes the
+// connection and reports the peer on delPeerCh. It is started held on clock.
+func (peer *TCPPeer) readLoop(clock Clock, rpcCh chan RPC, delPeerCh chan *TCPPeer) {
+	defer func() {
+		_ = peer.conn.Close()
+		clock.Hold()
+		delPeerCh <- peer
+		clock.Release()
+	}()
+
+	reader := bufio.NewReaderSize(peer.conn, readBufferSize)
+	maxSize := preHandshakeSize
 
 	for {
-		n, err := peer.conn.Read(buf)
-
-		if err == io.EOF {
-			continue
-		}
+		frame, err := readFrame(reader, maxSize)
 
 		if err != nil {
-			continue
+			peer.readErr = err
+			return
 		}
 
-		msg := buf[:n]
+		maxSize = maxPayloadSize
 
+		clock.Hold()
 		rpcCh <- RPC{
 			From:    peer.conn.RemoteAddr(),
-			Payload: bytes.NewReader(msg),
+			Payload: bytes.NewReader(frame),
 		}
 	}
 }
@@ -50,15 +92,43 @@ type TCPTransport struct {
 	peerCh     chan *TCPPeer
 	ListenAddr string
 	listener   net.Listener
+	tlsConfig  *tls.Config
+	pending    chan struct{}
+	// AcceptFilter, if set, drops inbound connections before the handshake.
+	AcceptFilter func(addr net.Addr) bool
 }
 
 func NewTCPTransport(addr string, peerCh chan *TCPPeer) *TCPTransport {
 	return &TCPTransport{
 		ListenAddr: addr,
 		peerCh:     peerCh,
+		pending:    make(chan struct{}, maxPendingHandshakes),
 	}
 }
 
+// NewSecureTCPTransport wraps every connection in TLS 1.3 authenticated by
+// the node key, so each peer gets a cryptographic ID.
+func NewSecureTCPTransport(addr string, peerCh chan *TCPPeer, nodeKey ed25519.PrivateKey) (*TCPTransport, error) {
+	tlsConfig, err := newTLSConfig(nodeKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := NewTCPTransport(addr, peerCh)
+	t.tlsConfig = tlsConfig
+
+	return t, nil
+}
+
+func (t *TCPTransport) Peers() chan *TCPPeer {
+	return t.peerCh
+}
+
+func (t *TCPTransport) SetAcceptFilter(filter func(addr net.Addr) bool) {
+	t.AcceptFilter = filter
+}
+
 func (t *TCPTransport) Start() error {
 	ln, err := net.Listen("tcp", t.ListenAddr)
 
@@ -73,18 +143,113 @@ func (t *TCPTransport) Start() error {
 	return nil
 }
 
+func (t *TCPTransport) Close() error {
+	if t.listener == nil {
+		return nil
+	}
+
+	return t.listener.Close()
+}
+
+// Dial connects to addr and completes the secure handshake, if any, which
+// fails if a non-zero id is not the key the peer proves.
+func (t *TCPTransport) Dial(addr string, id PeerID) (*TCPPeer, error) {
+	conn, err := net.DialTimeout("tcp", addr, handshakeTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t.newPeer(conn, id, true)
+}
+
 func (t *TCPTransport) acceptLoop() {
 	for {
 		connection, err := t.listener.Accept()
 
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
 		if err != nil {
 			continue
 		}
 
-		peer := &TCPPeer{
-			conn: connection,
+		if t.AcceptFilter != nil && !t.AcceptFilter(connection.RemoteAddr()) {
+			_ = connection.Close()
+			continue
 		}
 
-		t.peerCh <- peer
+		select {
+		case t.pending <- struct{}{}:
+		default:
+			_ = connection.Close()
+			continue
+		}
+
+		go func() {
+			defer func() { <-t.pending }()
+
+			peer, errPeer := t.newPeer(connection, PeerID{}, false)
+
+			if errPeer != nil {
+				return
+			}
+
+			t.peerCh <- peer
+		}()
 	}
 }
+
+func (t *TCPTransport) newPeer(conn net.Conn, id PeerID, outgoing bool) (*TCPPeer, error) {
+	peer := &TCPPeer{
+		conn:     conn,
+		Outgoing: outgoing,
+	}
+
+	if t.tlsConfig == nil {
+		return peer, nil
+	}
+
+	var tlsConn *tls.Conn
+
+	switch {
+	case outgoing && !id.IsZero():
+		tlsConn = tls.Client(conn, expectPeerID(t.tlsConfig, id))
+	case outgoing:
+		tlsConn = tls.Client(conn, t.tlsConfig)
+	default:
+		tlsConn = tls.Server(conn, t.tlsConfig)
+	}
+
+	_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	proven, err := peerIDFromCertificates(rawCertificates(tlsConn.ConnectionState()))
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	peer.conn = tlsConn
+	peer.ID = proven
+
+	return peer, nil
+}
+
+func rawCertificates(state tls.ConnectionState) [][]byte {
+	raw := make([][]byte, len(state.PeerCertificates))
+
+	for i, cert := range state.PeerCertificates {
+		raw[i] = cert.Raw
+	}
+
+	return raw
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net"
@@ -26,3 +27,89 @@ func TestTCPTransport_acceptLoop(t *testing.T) {
 		conn.Write([]byte("Hello world"))
 	}
 }
+
+func TestTCPPeer_readLoop(t *testing.T) {
+	local, remote := net.Pipe()
+	peer := &TCPPeer{conn: local}
+	rpcCh := make(chan RPC)
+	delPeerCh := make(chan *TCPPeer, 1)
+
+	go peer.readLoop(realClock{}, rpcCh, delPeerCh)
+
+	frame, err := NewMessage(MessageTypeGetStatus, nil).Bytes()
+	assert.Nil(t, err)
+
+	go func() {
+		_, _ = remote.Write(frame[:5])
+		_, _ = remote.Write(frame[5:])
+		_ = remote.Close()
+	}()
+
+	rpc := <-rpcCh
+	msg, errDecode := DefaultRPCDecodeFunc(rpc)
+	assert.Nil(t, errDecode)
+	assert.IsType(t, &GetStatusMessage{}, msg.Data)
+
+	select {
+	case p := <-delPeerCh:
+		assert.Equal(t, peer, p)
+	case <-time.After(time.Second):
+		t.Fatal("peer was not torn down on EOF")
+	}
+}
+
+func TestSecureTCPTransport_Dial(t *testing.T) {
+	serverKey, _ := LoadOrCreateNodeKey(t.TempDir())
+	clientKey, _ := LoadOrCreateNodeKey(t.TempDir())
+
+	peerCh := make(chan *TCPPeer, 1)
+	server, err := NewSecureTCPTransport("127.0.0.1:0", peerCh, serverKey)
+	assert.Nil(t, err)
+	assert.Nil(t, server.Start())
+	defer server.Close()
+
+	client, errClient := NewSecureTCPTransport("", nil, clientKey)
+	assert.Nil(t, errClient)
+
+	// a peer proving another key than the expected one is rejected
+	_, errImpostor := client.Dial(server.listener.Addr().String(), PeerIDFromPublicKey(clientKey.Public().(ed25519.PublicKey)))
+	assert.ErrorIs(t, errImpostor, IncompatiblePeerError)
+
+	outgoing, errDial := client.Dial(server.listener.Addr().String(), PeerIDFromPublicKey(serverKey.Public().(ed25519.PublicKey)))
+	assert.Nil(t, errDial)
+	assert.True(t, outgoing.Outgoing)
+	assert.Equal(t, PeerIDFromPublicKey(serverKey.Public().(ed25519.PublicKey)), outgoing.ID)
+
+	incoming := <-peerCh
+	assert.False(t, incoming.Outgoing)
+	assert.Equal(t, PeerIDFromPublicKey(clientKey.Public().(ed25519.PublicKey)), incoming.ID)
+
+	frame, _ := NewMessage(MessageTypeGetStatus, []byte("over tls")).Bytes()
+	assert.Nil(t, outgoing.Send(frame))
+
+	msg, errRead := ReadMessage(incoming.conn)
+	assert.Nil(t, errRead)
+	assert.Equal(t, []byte("over tls"), msg.Data)
+}
+
+func TestSecureTCPTransport_RejectsPlaintext(t *testing.T) {
+	key, _ := LoadOrCreateNodeKey(t.TempDir())
+	peerCh := make(chan *TCPPeer, 1)
+
+	server, err := NewSecureTCPTransport("127.0.0.1:0", peerCh, key)
+	assert.Nil(t, err)
+	assert.Nil(t, server.Start())
+	defer server.Close()
+
+	plain, errDial := NewTCPTransport("", nil).Dial(server.listener.Addr().String(), PeerID{})
+	assert.Nil(t, errDial)
+
+	frame, _ := NewMessage(MessageTypeGetStatus, nil).Bytes()
+	assert.Nil(t, plain.Send(frame))
+
+	select {
+	case <-peerCh:
+		t.Fatal("plaintext connection was accepted")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,17 @@
+package network
+
+import "net"
+
+// Transport connects a Server to its peers. Every inbound connection is
+// delivered on Peers; dialed ones are returned to the caller.
+type Transport interface {
+	Start() error
+	Close() error
+	// Dial connects to addr. A non-zero id is the peer ID addr is expected to
+	// answer as, and a peer proving another one is rejected.
+	Dial(addr string, id PeerID) (*TCPPeer, error)
+	Peers() chan *TCPPeer
+	// SetAcceptFilter installs a check that drops inbound connections before
+	// any handshake.
+	SetAcceptFilter(filter func(addr net.Addr) bool)
+}
@@ -1,87 +1,624 @@
 package network
 
 import (
+	"container/heap"
+	"errors"
+	"fmt"
 	"github.com/Phanile/uretra_network/core"
 	"github.com/Phanile/uretra_network/types"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 )
 
-type TxSortedMap struct {
-	lock   sync.RWMutex
-	lookup map[types.Hash]*core.Transaction
-	txs    *types.List[*core.Transaction]
+const (
+	defaultMaxPoolTxs     = 4096
+	defaultMaxPoolBytes   = 8 << 20
+	defaultMaxSenderTxs   = 64
+	defaultMaxSenderBytes = 1 << 20
+	defaultTxLifetime     = 3 * time.Hour
+	defaultMinFeeBump     = 10
+	defaultMinRelayFee    = 1
+)
+
+var (
+	TxKnownError                = errors.New("transaction already known")
+	UnderpricedReplacementError = errors.New("replacement fee too low")
+	SenderLimitError            = errors.New("sender has too many pooled transactions")
+	MempoolFullError            = errors.New("mempool full")
+	FeeTooLowError              = errors.New("fee below the minimum relay fee")
+)
+
+type TxPoolOptions struct {
+	// MaxTxs and MaxBytes bound the whole pool; once either is reached a new
+	// transaction gets in only by paying a higher fee than those it evicts.
+	MaxTxs   int
+	MaxBytes int
+	// MaxSenderTxs and MaxSenderBytes bound the transactions of one sender.
+	MaxSenderTxs   int
+	MaxSenderBytes int
+	// Lifetime is how long a transaction may wait for a block before Expire
+	// drops it.
+	Lifetime time.Duration
+	// MinFeeBump is the percentage by which the fee of a transaction must
+	// exceed that of the pooled one with the same sender and nonce to
+	// replace it.
+	MinFeeBump uint64
+	// MinRelayFee is the least fee a transaction must pay to be pooled, so
+	// filling the pool is never free.
+	MinRelayFee uint64
+	Now         func() time.Time
+	// OnEvict is called with the hash of each transaction that is evicted or
+	// expires, while the pool's lock is held.
+	OnEvict func(hash types.Hash)
 }
 
-func NewTxSortedMap() *TxSortedMap {
-	return &TxSortedMap{
-		lookup: make(map[types.Hash]*core.Transaction),
-		txs:    types.NewList[*core.Transaction](),
-	}
+// poolTx is a pooled transaction with what the pool's indexes need.
+type poolTx struct {
+	tx    *core.Transaction
+	hash  types.Hash
+	from  types.Address
+	size  int
+	added time.Time
+	// index is the position in the fee index.
+	index int
+}
+
+// TxPool holds the pending transactions. Each sender has a queue ordered by
+// nonce, holding at most one transaction per nonce, and all transactions are
+// indexed by fee, so adding and removing take O(log n) plus a sender's queue.
+type TxPool struct {
+	opts    TxPoolOptions
+	lock    sync.RWMutex
+	lookup  map[types.Hash]*poolTx
+	senders map[types.Address][]*poolTx
+	byFee   feeIndex
+	bytes   int
 }
 
-func (m *TxSortedMap) First() *core.Transaction {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+func NewTxPool(opts TxPoolOptions) *TxPool {
+	if opts.MaxTxs == 0 {
+		opts.MaxTxs = defaultMaxPoolTxs
+	}
+
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = defaultMaxPoolBytes
+	}
+
+	if opts.MaxSenderTxs == 0 {
+		opts.MaxSenderTxs = defaultMaxSenderTxs
+	}
+
+	if opts.MaxSenderBytes == 0 {
+		opts.MaxSenderBytes = defaultMaxSenderBytes
+	}
+
+	if opts.Lifetime == 0 {
+		opts.Lifetime = defaultTxLifetime
+	}
+
+	if opts.MinFeeBump == 0 {
+		opts.MinFeeBump = defaultMinFeeBump
+	}
+
+	if opts.MinRelayFee == 0 {
+		opts.MinRelayFee = defaultMinRelayFee
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	if opts.OnEvict == nil {
+		opts.OnEvict = func(types.Hash) {}
+	}
 
-	f := m.txs.Get(0)
-	return m.lookup[f.Hash(core.TxHasher{})]
+	return &TxPool{
+		opts:    opts,
+		lookup:  make(map[types.Hash]*poolTx),
+		senders: make(map[types.Address][]*poolTx),
+	}
 }
 
-func (m *TxSortedMap) Get(hash types.Hash) *core.Transaction {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+func (p *TxPool) Get(hash types.Hash) *core.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if ptx, ok := p.lookup[hash]; ok {
+		return ptx.tx
+	}
 
-	return m.lookup[hash]
+	return nil
 }
 
-func (m *TxSortedMap) Add(tx *core.Transaction) bool {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// Add pools tx, evicting the lowest-fee transactions if the pool is full,
+// and returns why it was not pooled otherwise. A transaction with the nonce
+// of a pooled one of the same sender replaces it if it pays at least
+// MinFeeBump percent more, which also lets a sender cancel a transaction with
+// a dearer one that does nothing.
+func (p *TxPool) Add(tx *core.Transaction) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
 	hash := tx.Hash(core.TxHasher{})
 
-	_, ok := m.lookup[hash]
+	if _, ok := p.lookup[hash]; ok {
+		return fmt.Errorf("%w: %s", TxKnownError, hash)
+	}
+
+	if tx.Fee < p.opts.MinRelayFee {
+		return fmt.Errorf("%w: fee %d, need at least %d", FeeTooLowError, tx.Fee, p.opts.MinRelayFee)
+	}
 
-	if !ok {
-		m.lookup[hash] = tx
-		m.txs.Insert(tx)
+	ptx := &poolTx{
+		tx:    tx,
+		hash:  hash,
+		from:  tx.From.Address(),
+		size:  tx.Size(),
+		added: p.opts.Now(),
+	}
 
-		return true
+	replaced := p.queuedNoLock(ptx.from, tx.Nonce)
+
+	if replaced != nil {
+		if minFee := p.replacementFee(replaced.tx.Fee); tx.Fee < minFee {
+			return fmt.Errorf("%w: fee %d, need at least %d", UnderpricedReplacementError, tx.Fee, minFee)
+		}
+
+		p.unqueueNoLock(replaced)
+	}
+
+	queue := p.senders[ptx.from]
+	senderBytes := ptx.size
+
+	for _, other := range queue {
+		senderBytes += other.size
+	}
+
+	var err error
+
+	if len(queue) >= p.opts.MaxSenderTxs || senderBytes > p.opts.MaxSenderBytes {
+		err = fmt.Errorf("%w: %d transactions, %d bytes", SenderLimitError, len(queue), senderBytes-ptx.size)
+	} else {
+		err = p.makeRoomNoLock(ptx)
+	}
+
+	if err != nil {
+		if replaced != nil {
+			p.queueNoLock(replaced)
+		}
+
+		return err
+	}
+
+	p.queueNoLock(ptx)
+
+	return nil
+}
+
+// replacementFee is the least fee that replaces a transaction paying fee.
+func (p *TxPool) replacementFee(fee uint64) uint64 {
+	bump := fee/100*p.opts.MinFeeBump + (fee%100*p.opts.MinFeeBump+99)/100
+
+	return fee + max(bump, 1)
+}
+
+func (p *TxPool) queueNoLock(ptx *poolTx) {
+	queue := p.senders[ptx.from]
+	i := nonceIndex(queue, ptx.tx.Nonce)
+	p.senders[ptx.from] = append(queue[:i], append([]*poolTx{ptx}, queue[i:]...)...)
+	p.lookup[ptx.hash] = ptx
+	p.bytes += ptx.size
+	heap.Push(&p.byFee, ptx)
+}
+
+// unqueueNoLock drops ptx from the pool, leaving the rest of its sender's
+// queue in place.
+func (p *TxPool) unqueueNoLock(ptx *poolTx) {
+	queue := p.senders[ptx.from]
+	i := nonceIndex(queue, ptx.tx.Nonce)
+
+	p.setQueueNoLock(ptx.from, append(queue[:i], queue[i+1:]...))
+	p.dropNoLock(ptx)
+}
+
+// makeRoomNoLock evicts the lowest-fee transactions until ptx fits.
+// Evicting a transaction also evicts the later ones of its sender, which
+// could no longer execute, so a transaction is only evicted if neither it nor
+// any of those pays as much as ptx.
+func (p *TxPool) makeRoomNoLock(ptx *poolTx) error {
+	if ptx.size > p.opts.MaxBytes {
+		return fmt.Errorf("%w: transaction of %d bytes", MempoolFullError, ptx.size)
+	}
+
+	var popped []*poolTx
+	evicted := make(map[*poolTx]bool)
+	count, bytes := len(p.lookup), p.bytes
+
+	// the fee index is only peeked at here; evictions happen below
+	restore := func() {
+		for _, other := range popped {
+			heap.Push(&p.byFee, other)
+		}
+	}
+
+	for count >= p.opts.MaxTxs || bytes+ptx.size > p.opts.MaxBytes {
+		if p.byFee.Len() == 0 || p.byFee[0].tx.Fee >= ptx.tx.Fee {
+			restore()
+			return fmt.Errorf("%w: fee %d does not beat the transactions to evict", MempoolFullError, ptx.tx.Fee)
+		}
+
+		lowest := heap.Pop(&p.byFee).(*poolTx)
+		popped = append(popped, lowest)
+
+		// a sender's own earlier transaction is never evicted for a later one
+		if evicted[lowest] || lowest.from == ptx.from && lowest.tx.Nonce < ptx.tx.Nonce {
+			continue
+		}
+
+		later := p.laterNoLock(lowest)
+
+		if slices.ContainsFunc(later, func(other *poolTx) bool { return other.tx.Fee >= ptx.tx.Fee }) {
+			continue
+		}
+
+		for _, other := range later {
+			if !evicted[other] {
+				evicted[other] = true
+				count--
+				bytes -= other.size
+			}
+		}
+	}
+
+	restore()
+
+	for other := range evicted {
+		if _, ok := p.lookup[other.hash]; ok {
+			p.removeFromNoLock(other)
+		}
+	}
+
+	return nil
+}
+
+// nonceIndex returns where the transaction with nonce is or would go in a
+// sender's queue.
+func nonceIndex(queue []*poolTx, nonce uint64) int {
+	return sort.Search(len(queue), func(i int) bool {
+		return queue[i].tx.Nonce >= nonce
+	})
+}
+
+// queuedNoLock returns the pooled transaction of from with nonce, if any.
+func (p *TxPool) queuedNoLock(from types.Address, nonce uint64) *poolTx {
+	queue := p.senders[from]
+
+	if i := nonceIndex(queue, nonce); i < len(queue) && queue[i].tx.Nonce == nonce {
+		return queue[i]
+	}
+
+	return nil
+}
+
+// laterNoLock returns ptx and the transactions of its sender with later
+// nonces.
+func (p *TxPool) laterNoLock(ptx *poolTx) []*poolTx {
+	queue := p.senders[ptx.from]
+	i := nonceIndex(queue, ptx.tx.Nonce)
+
+	return queue[i:]
+}
+
+// removeFromNoLock evicts ptx and the later transactions of its sender.
+func (p *TxPool) removeFromNoLock(ptx *poolTx) int {
+	later := p.laterNoLock(ptx)
+	queue := p.senders[ptx.from]
+
+	for _, other := range later {
+		p.dropNoLock(other)
+		p.opts.OnEvict(other.hash)
+	}
+
+	p.setQueueNoLock(ptx.from, queue[:len(queue)-len(later)])
+
+	return len(later)
+}
+
+// Expire drops the transactions that have waited longer than the pool's
+// Lifetime, with the later transactions of their senders.
+func (p *TxPool) Expire() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	deadline := p.opts.Now().Add(-p.opts.Lifetime)
+	removed := 0
+
+	for _, queue := range p.senders {
+		for _, ptx := range queue {
+			if ptx.added.Before(deadline) {
+				removed += p.removeFromNoLock(ptx)
+				break
+			}
+		}
 	}
 
-	return false
+	return removed
 }
 
-func (m *TxSortedMap) Remove(hash types.Hash) bool {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (p *TxPool) Remove(hash types.Hash) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
-	m.txs.Remove(m.lookup[hash])
-	delete(m.lookup, hash)
+	ptx, ok := p.lookup[hash]
 
-	return true
+	if ok {
+		p.unqueueNoLock(ptx)
+	}
+
+	return ok
+}
+
+// RemoveStale drops transactions whose nonce the sender has already used on
+// chain, as they can never be included anymore.
+func (p *TxPool) RemoveStale(nonceOf func(types.Address) uint64) int {
+	nonces := p.noncesOf(nonceOf)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	removed := 0
+
+	for from, nonce := range nonces {
+		queue := p.senders[from]
+		stale := nonceIndex(queue, nonce)
+
+		for _, ptx := range queue[:stale] {
+			p.dropNoLock(ptx)
+		}
+
+		p.setQueueNoLock(from, queue[stale:])
+		removed += stale
+	}
+
+	return removed
+}
+
+// noncesOf looks up the chain nonce of every sender with pooled
+// transactions. nonceOf is called without the pool's lock, as the chain calls
+// into the pool while holding its own. Senders that arrive meanwhile wait for
+// the next call.
+func (p *TxPool) noncesOf(nonceOf func(types.Address) uint64) map[types.Address]uint64 {
+	p.lock.RLock()
+	senders := make([]types.Address, 0, len(p.senders))
+
+	for from := range p.senders {
+		senders = append(senders, from)
+	}
+
+	p.lock.RUnlock()
+
+	nonces := make(map[types.Address]uint64, len(senders))
+
+	for _, from := range senders {
+		nonces[from] = nonceOf(from)
+	}
+
+	return nonces
+}
+
+func (p *TxPool) setQueueNoLock(from types.Address, queue []*poolTx) {
+	if len(queue) == 0 {
+		delete(p.senders, from)
+		return
+	}
+
+	p.senders[from] = queue
+}
+
+func (p *TxPool) dropNoLock(ptx *poolTx) {
+	delete(p.lookup, ptx.hash)
+	heap.Remove(&p.byFee, ptx.index)
+	p.bytes -= ptx.size
+}
+
+// All returns the pooled transactions keyed by hash.
+func (p *TxPool) All() map[types.Hash]*core.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	all := make(map[types.Hash]*core.Transaction, len(p.lookup))
+
+	for hash, ptx := range p.lookup {
+		all[hash] = ptx.tx
+	}
+
+	return all
 }
 
-func (m *TxSortedMap) Count() uint16 {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+func (p *TxPool) Count() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 
-	return uint16(len(m.lookup))
+	return len(p.lookup)
 }
 
-func (m *TxSortedMap) Contains(hash types.Hash) bool {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+func (p *TxPool) Contains(hash types.Hash) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 
-	_, ok := m.lookup[hash]
+	_, ok := p.lookup[hash]
 
 	return ok
 }
 
-func (m *TxSortedMap) Clear() {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// Lowest returns the pooled transaction with the lowest fee, or nil if the
+// pool is empty.
+func (p *TxPool) Lowest() *core.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if len(p.byFee) == 0 {
+		return nil
+	}
+
+	return p.byFee[0].tx
+}
+
+func (p *TxPool) Clear() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lookup = make(map[types.Hash]*poolTx)
+	p.senders = make(map[types.Address][]*poolTx)
+	p.byFee = nil
+	p.bytes = 0
+}
+
+// ByFeePriority returns all pooled transactions highest fee first, while the
+// transactions of a single sender stay in nonce order so each of them can
+// execute after the previous one.
+func (p *TxPool) ByFeePriority() []*core.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	heads := make(senderHeap, 0, len(p.senders))
+
+	for _, queue := range p.senders {
+		heads = append(heads, queue)
+	}
+
+	return drainQueues(heads, len(p.lookup), func(*poolTx) bool { return true })
+}
+
+// Select returns the best set of transactions for a block of at most maxSize
+// bytes: highest fee first, and only those whose nonces follow on from the
+// sender's nonce on chain without a gap, so all of them can execute. A
+// sender's transactions stop at the first one that does not fit.
+func (p *TxPool) Select(nonceOf func(types.Address) uint64, maxSize int) []*core.Transaction {
+	nonces := p.noncesOf(nonceOf)
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	heads := make(senderHeap, 0, len(nonces))
+	next := make(map[types.Address]uint64, len(nonces))
+
+	for from, nonce := range nonces {
+		queue := p.senders[from]
+		start := nonceIndex(queue, nonce)
+
+		if start < len(queue) && queue[start].tx.Nonce == nonce {
+			heads = append(heads, queue[start:])
+			next[from] = nonce
+		}
+	}
+
+	size := 0
+
+	return drainQueues(heads, 0, func(ptx *poolTx) bool {
+		if ptx.tx.Nonce != next[ptx.from] || size+ptx.size > maxSize {
+			return false
+		}
+
+		next[ptx.from]++
+		size += ptx.size
+
+		return true
+	})
+}
+
+// drainQueues merges the sender queues in heads, highest fee head first, and
+// returns the transactions accept takes. A queue is dropped at the first
+// transaction accept refuses.
+func drainQueues(heads senderHeap, capacity int, accept func(*poolTx) bool) []*core.Transaction {
+	heap.Init(&heads)
+
+	sorted := make([]*core.Transaction, 0, capacity)
+
+	for heads.Len() > 0 {
+		queue := heads[0]
+
+		if !accept(queue[0]) {
+			heap.Pop(&heads)
+			continue
+		}
+
+		sorted = append(sorted, queue[0].tx)
+
+		if len(queue) == 1 {
+			heap.Pop(&heads)
+			continue
+		}
+
+		heads[0] = queue[1:]
+		heap.Fix(&heads, 0)
+	}
+
+	return sorted
+}
+
+// senderHeap is a max-heap of sender queues keyed by the fee of their head.
+type senderHeap [][]*poolTx
+
+func (h senderHeap) Len() int { return len(h) }
+
+func (h senderHeap) Less(i, j int) bool {
+	if h[i][0].tx.Fee != h[j][0].tx.Fee {
+		return h[i][0].tx.Fee > h[j][0].tx.Fee
+	}
+
+	return h[i][0].tx.Nonce < h[j][0].tx.Nonce
+}
+
+func (h senderHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *senderHeap) Push(x any) {
+	*h = append(*h, x.([]*poolTx))
+}
+
+func (h *senderHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+
+	return item
+}
+
+// feeIndex is a min-heap of all pooled transactions by fee. Among equal fees
+// the later nonce comes first, as it depends on the others.
+type feeIndex []*poolTx
+
+func (h feeIndex) Len() int { return len(h) }
+
+func (h feeIndex) Less(i, j int) bool {
+	if h[i].tx.Fee != h[j].tx.Fee {
+		return h[i].tx.Fee < h[j].tx.Fee
+	}
+
+	return h[i].tx.Nonce > h[j].tx.Nonce
+}
+
+func (h feeIndex) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *feeIndex) Push(x any) {
+	ptx := x.(*poolTx)
+	ptx.index = len(*h)
+	*h = append(*h, ptx)
+}
+
+func (h *feeIndex) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
 
-	m.lookup = make(map[types.Hash]*core.Transaction)
-	m.txs.Clear()
+	return item
 }
@@ -1,13 +1,264 @@
 package network
 
 import (
+	"github.com/Phanile/uretra_network/core"
+	"github.com/Phanile/uretra_network/crypto"
+	"github.com/Phanile/uretra_network/types"
+	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestTxPool_Add(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{})
+	key := crypto.GeneratePrivateKey()
 
+	tx := signedTx(t, key, 0, 10)
+
+	assert.Nil(t, pool.Add(tx))
+	assert.ErrorIs(t, pool.Add(tx), TxKnownError)
+
+	// one transaction per sender and nonce, unless it pays enough more
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 0, 5)), UnderpricedReplacementError)
+	assert.Nil(t, pool.Add(signedTx(t, key, 1, 2)))
+	assert.Equal(t, 2, pool.Count())
+	assert.Equal(t, tx, pool.Get(tx.Hash(core.TxHasher{})))
+}
+
+func TestTxPool_Remove(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{})
+	key := crypto.GeneratePrivateKey()
+
+	cheap := signedTx(t, key, 0, 1)
+	middle := signedTx(t, key, 1, 5)
+	dear := signedTx(t, crypto.GeneratePrivateKey(), 0, 10)
+
+	pool.Add(dear)
+	pool.Add(middle)
+	pool.Add(cheap)
+	assert.Equal(t, cheap, pool.Lowest())
+
+	assert.True(t, pool.Remove(cheap.Hash(core.TxHasher{})))
+	assert.False(t, pool.Remove(cheap.Hash(core.TxHasher{})))
+	assert.Equal(t, middle, pool.Lowest())
+	assert.Equal(t, []*core.Transaction{dear, middle}, pool.ByFeePriority())
+
+	pool.Clear()
+	assert.Nil(t, pool.Lowest())
+	assert.Equal(t, 0, pool.Count())
 }
 
 func TestTxPool_Sort(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{})
+	alice := crypto.GeneratePrivateKey()
+	bob := crypto.GeneratePrivateKey()
+
+	aliceFirst := signedTx(t, alice, 0, 1)
+	aliceSecond := signedTx(t, alice, 1, 50)
+	bobFirst := signedTx(t, bob, 0, 10)
+
+	pool.Add(aliceSecond)
+	pool.Add(bobFirst)
+	pool.Add(aliceFirst)
+
+	assert.Equal(t, []*core.Transaction{bobFirst, aliceFirst, aliceSecond}, pool.ByFeePriority())
+}
+
+func TestTxPool_RemoveStale(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{})
+	key := crypto.GeneratePrivateKey()
+
+	used := signedTx(t, key, 0, 1)
+	pending := signedTx(t, key, 1, 1)
+
+	pool.Add(used)
+	pool.Add(pending)
+
+	assert.Equal(t, 1, pool.RemoveStale(func(types.Address) uint64 { return 1 }))
+	assert.False(t, pool.Contains(used.Hash(core.TxHasher{})))
+	assert.True(t, pool.Contains(pending.Hash(core.TxHasher{})))
+}
+
+func TestTxPool_Replace(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{MaxSenderTxs: 2})
+	key := crypto.GeneratePrivateKey()
+
+	least := signedTx(t, key, 0, 1)
+	assert.Nil(t, pool.Add(least))
+	assert.Nil(t, pool.Add(signedTx(t, key, 1, 100)))
+
+	// the least fee is replaced by any higher fee
+	paid := signedTx(t, key, 0, 2)
+	assert.Nil(t, pool.Add(paid))
+	assert.False(t, pool.Contains(least.Hash(core.TxHasher{})))
+	assert.Equal(t, 2, pool.Count())
+
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 1, 109)), UnderpricedReplacementError)
+
+	bumped := signedTx(t, key, 1, 110)
+	assert.Nil(t, pool.Add(bumped))
+	assert.Equal(t, []*core.Transaction{paid, bumped}, pool.ByFeePriority())
+	assert.Equal(t, paid, pool.Lowest())
+
+	pool = NewTxPool(TxPoolOptions{MinFeeBump: 50})
+	assert.Nil(t, pool.Add(signedTx(t, key, 0, 10)))
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 0, 14)), UnderpricedReplacementError)
+	assert.Nil(t, pool.Add(signedTx(t, key, 0, 15)))
+}
+
+func TestTxPool_ReplaceFull(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{MaxTxs: 2})
+	alice := crypto.GeneratePrivateKey()
+
+	cheap := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+	pending := signedTx(t, alice, 0, 10)
+
+	assert.Nil(t, pool.Add(cheap))
+	assert.Nil(t, pool.Add(pending))
+
+	// replacing does not grow the pool, so nothing is evicted
+	replacement := signedTx(t, alice, 0, 20)
+	assert.Nil(t, pool.Add(replacement))
+	assert.Equal(t, []*core.Transaction{replacement, cheap}, pool.ByFeePriority())
+
+	// a failed replacement leaves the pooled transaction in place
+	assert.ErrorIs(t, pool.Add(signedTx(t, alice, 1, 1)), MempoolFullError)
+	assert.True(t, pool.Contains(replacement.Hash(core.TxHasher{})))
+}
+
+func TestTxPool_MinRelayFee(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+
+	pool := NewTxPool(TxPoolOptions{})
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 0, 0)), FeeTooLowError)
+	assert.Nil(t, pool.Add(signedTx(t, key, 0, defaultMinRelayFee)))
+
+	pool = NewTxPool(TxPoolOptions{MinRelayFee: 10})
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 0, 9)), FeeTooLowError)
+	assert.Nil(t, pool.Add(signedTx(t, key, 0, 10)))
+	assert.Equal(t, 1, pool.Count())
+}
+
+func TestTxPool_SenderLimit(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	first := signedTx(t, key, 0, 1)
+
+	pool := NewTxPool(TxPoolOptions{MaxSenderTxs: 2})
+	assert.Nil(t, pool.Add(first))
+	assert.Nil(t, pool.Add(signedTx(t, key, 1, 1)))
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 2, 1)), SenderLimitError)
+	assert.Nil(t, pool.Add(signedTx(t, crypto.GeneratePrivateKey(), 0, 1)))
+
+	pool = NewTxPool(TxPoolOptions{MaxSenderBytes: 2*first.Size() - 1})
+	assert.Nil(t, pool.Add(first))
+	assert.ErrorIs(t, pool.Add(signedTx(t, key, 1, 1)), SenderLimitError)
+}
+
+func TestTxPool_Evict(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{MaxTxs: 3})
+	alice := crypto.GeneratePrivateKey()
+
+	aliceFirst := signedTx(t, alice, 0, 1)
+	aliceSecond := signedTx(t, alice, 1, 20)
+	bob := signedTx(t, crypto.GeneratePrivateKey(), 0, 5)
+
+	assert.Nil(t, pool.Add(aliceFirst))
+	assert.Nil(t, pool.Add(aliceSecond))
+	assert.Nil(t, pool.Add(bob))
+
+	assert.ErrorIs(t, pool.Add(signedTx(t, crypto.GeneratePrivateKey(), 0, 1)), MempoolFullError)
+
+	// evicting alice's first transaction would take the second, which
+	// depends on it and pays more, along
+	carol := signedTx(t, crypto.GeneratePrivateKey(), 0, 2)
+	assert.ErrorIs(t, pool.Add(carol), MempoolFullError)
+
+	dave := signedTx(t, crypto.GeneratePrivateKey(), 0, 6)
+	assert.Nil(t, pool.Add(dave))
+	assert.Equal(t, []*core.Transaction{dave, aliceFirst, aliceSecond}, pool.ByFeePriority())
+
+	// alice cannot push out her own first transaction
+	assert.ErrorIs(t, pool.Add(signedTx(t, alice, 2, 3)), MempoolFullError)
+	assert.Equal(t, 3, pool.Count())
+
+	// outbidding both of alice's transactions evicts them together
+	erin := signedTx(t, crypto.GeneratePrivateKey(), 0, 21)
+	assert.Nil(t, pool.Add(erin))
+	assert.Equal(t, []*core.Transaction{erin, dave}, pool.ByFeePriority())
+
+	pool = NewTxPool(TxPoolOptions{MaxBytes: 2 * bob.Size()})
+	assert.Nil(t, pool.Add(aliceFirst))
+	assert.Nil(t, pool.Add(bob))
+	assert.Nil(t, pool.Add(carol))
+	assert.Equal(t, []*core.Transaction{bob, carol}, pool.ByFeePriority())
+	assert.Equal(t, carol, pool.Lowest())
+}
+
+func TestTxPool_Expire(t *testing.T) {
+	now := time.Unix(0, 0)
+	var evicted []types.Hash
+	pool := NewTxPool(TxPoolOptions{
+		Lifetime: time.Hour,
+		Now:      func() time.Time { return now },
+		OnEvict:  func(hash types.Hash) { evicted = append(evicted, hash) },
+	})
+
+	alice := crypto.GeneratePrivateKey()
+	aliceFirst := signedTx(t, alice, 0, 1)
+	aliceSecond := signedTx(t, alice, 1, 1)
+	bob := signedTx(t, crypto.GeneratePrivateKey(), 0, 1)
+
+	assert.Nil(t, pool.Add(aliceFirst))
+	now = now.Add(30 * time.Minute)
+	assert.Nil(t, pool.Add(aliceSecond))
+	assert.Nil(t, pool.Add(bob))
+
+	now = now.Add(31 * time.Minute)
+	assert.Equal(t, 2, pool.Expire())
+	assert.Equal(t, []*core.Transaction{bob}, pool.ByFeePriority())
+	assert.Equal(t, []types.Hash{aliceFirst.Hash(core.TxHasher{}), aliceSecond.Hash(core.TxHasher{})}, evicted)
+}
+
+func TestTxPool_Select(t *testing.T) {
+	pool := NewTxPool(TxPoolOptions{})
+	alice := crypto.GeneratePrivateKey()
+	bob := crypto.GeneratePrivateKey()
+	carol := crypto.GeneratePrivateKey()
+
+	aliceStale := signedTx(t, alice, 0, 100)
+	aliceFirst := signedTx(t, alice, 1, 1)
+	aliceSecond := signedTx(t, alice, 2, 50)
+	bobFirst := signedTx(t, bob, 0, 10)
+	// carol's nonce 0 is missing, so her transaction cannot execute yet
+	carolGap := signedTx(t, carol, 1, 1000)
+
+	for _, tx := range []*core.Transaction{aliceStale, aliceFirst, aliceSecond, bobFirst, carolGap} {
+		pool.Add(tx)
+	}
+
+	nonceOf := func(addr types.Address) uint64 {
+		if addr == alice.PublicKey().Address() {
+			return 1
+		}
+
+		return 0
+	}
+
+	assert.Equal(t, []*core.Transaction{bobFirst, aliceFirst, aliceSecond}, pool.Select(nonceOf, core.MaxBlockSize))
+
+	// the block is full after bob's transaction, which leaves no room for alice
+	assert.Equal(t, []*core.Transaction{bobFirst}, pool.Select(nonceOf, bobFirst.Size()+aliceFirst.Size()-1))
+}
+
+func signedTx(t *testing.T, key crypto.PrivateKey, nonce, fee uint64) *core.Transaction {
+	tx := &core.Transaction{
+		ChainID: core.DefaultChainID,
+		Data:    []byte("AMOUNT 5000 BTC"),
+		Nonce:   nonce,
+		Fee:     fee,
+	}
+
+	assert.Nil(t, tx.Sign(key))
 
+	return tx
 }
@@ -33,10 +33,10 @@ func RandomHash() Hash {
 
 func (h Hash) IsEmptyOrZero() bool {
 	for i := 0; i < 32; i++ {
-		if h[i] == 0 {
-			return true
+		if h[i] != 0 {
+			return false
 		}
 	}
 
-	return false
+	return true
 }